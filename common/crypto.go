@@ -1,6 +1,13 @@
 package common
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"golang.org/x/crypto/bcrypt"
+)
 
 func Password2Hash(password string) (string, error) {
 	passwordBytes := []byte(password)
@@ -12,3 +19,11 @@ func ValidatePasswordAndHash(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// TokenKey2Hash returns a deterministic salted hash of an API token key,
+// so that tokens can be looked up by hash without storing the key itself.
+func TokenKey2Hash(key string) string {
+	mac := hmac.New(sha256.New, []byte(config.TokenKeySalt))
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
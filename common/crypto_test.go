@@ -0,0 +1,27 @@
+package common
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestTokenKey2Hash(t *testing.T) {
+	Convey("TestTokenKey2Hash", t, func() {
+		previousSalt := config.TokenKeySalt
+		defer func() { config.TokenKeySalt = previousSalt }()
+
+		config.TokenKeySalt = "salt"
+		hash := TokenKey2Hash("sk-test")
+		So(hash, ShouldHaveLength, 64)
+		So(TokenKey2Hash("sk-test"), ShouldEqual, hash)
+		So(TokenKey2Hash("sk-test2"), ShouldNotEqual, hash)
+		// HMAC-SHA256("salt", "sk-test")
+		So(hash, ShouldEqual, "1d57bceecb5c1dfafc5dcec91e533a66a60ba4b79f23525e11f5dea179f12002")
+
+		config.TokenKeySalt = "another salt"
+		So(TokenKey2Hash("sk-test"), ShouldNotEqual, hash)
+	})
+}
@@ -17,8 +17,18 @@ const (
 	ChannelName       = "channel_name"
 	TokenId           = "token_id"
 	TokenName         = "token_name"
+	TokenUnlimited    = "token_unlimited"
 	BaseURL           = "base_url"
 	AvailableModels   = "available_models"
 	KeyRequestBody    = "key_request_body"
 	SystemPrompt      = "system_prompt"
+
+	EphemeralTokenId        = "ephemeral_token_id"
+	EphemeralTokenExpiresAt = "ephemeral_token_expires_at"
+	EphemeralTokenQuota     = "ephemeral_token_quota"
+	PayloadRecorder         = "payload_recorder"
+	RetryChain              = "retry_chain"
+	FirstTokenAt            = "first_token_at"
+	UpstreamPrices          = "upstream_prices"
+	UpstreamDiscount        = "upstream_discount"
 )
@@ -42,8 +42,17 @@ func Init() {
 			logger.SysError("SESSION_SECRET is set to an example value, please change it to a random string.")
 		} else {
 			config.SessionSecret = os.Getenv("SESSION_SECRET")
+			if config.EphemeralTokenSecret == "" {
+				config.EphemeralTokenSecret = config.SessionSecret
+			}
 		}
 	}
+	if config.EphemeralTokenSecret == "" {
+		logger.SysError("EPHEMERAL_TOKEN_SECRET and SESSION_SECRET are not set, ephemeral tokens can't be minted.")
+	}
+	if config.TokenKeySalt == "" {
+		logger.SysError("TOKEN_KEY_SALT is not set, token keys are hashed without a secret salt, please set it to a random string before creating tokens (changing it later invalidates all existing tokens).")
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -0,0 +1,46 @@
+package logger
+
+import (
+	"context"
+	"sync"
+)
+
+type fieldsKey struct{}
+
+type fields struct {
+	sync.RWMutex
+	values map[string]any
+}
+
+// WithFields returns a context carrying request scoped fields, they are added to every JSON log of the request.
+func WithFields(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, &fields{values: make(map[string]any)})
+}
+
+// SetField sets a request scoped field, it's a no-op when ctx has no fields.
+func SetField(ctx context.Context, key string, value any) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.values[key] = value
+}
+
+func getFields(ctx context.Context) map[string]any {
+	if ctx == nil {
+		return nil
+	}
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return nil
+	}
+	f.RLock()
+	defer f.RUnlock()
+	values := make(map[string]any, len(f.values))
+	for k, v := range f.values {
+		values[k] = v
+	}
+	return values
+}
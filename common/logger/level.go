@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+const modulePath = "github.com/songquanpeng/one-api/"
+
+var slogLevels = map[loggerLevel]slog.Level{
+	loggerDEBUG: slog.LevelDebug,
+	loggerINFO:  slog.LevelInfo,
+	loggerWarn:  slog.LevelWarn,
+	loggerError: slog.LevelError,
+	loggerFatal: slog.LevelError + 4,
+}
+
+var levelsLock sync.RWMutex
+
+// levels maps package paths relative to the module (e.g. "relay/adaptor") to the minimum level,
+// the longest matching prefix wins and "default" applies to everything else.
+var levels = map[string]string{}
+var defaultLevel = initDefaultLevel()
+var minLevel = defaultLevel
+
+func initDefaultLevel() slog.Level {
+	if config.DebugEnabled {
+		return slog.LevelDebug
+	}
+	level, err := parseLevel(config.LogLevel)
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+func LogLevels2JSONString() string {
+	levelsLock.RLock()
+	jsonBytes, err := json.Marshal(levels)
+	levelsLock.RUnlock()
+	if err != nil {
+		SysError("error marshalling log levels: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateLogLevelsByJSONString(jsonStr string) error {
+	newLevels := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &newLevels)
+	if err != nil {
+		return err
+	}
+	newDefaultLevel := initDefaultLevel()
+	if s, ok := newLevels["default"]; ok {
+		if newDefaultLevel, err = parseLevel(s); err != nil {
+			return fmt.Errorf("invalid default log level: %s", s)
+		}
+	}
+	newMinLevel := newDefaultLevel
+	for pkg, s := range newLevels {
+		level, err := parseLevel(s)
+		if err != nil {
+			return fmt.Errorf("invalid log level of %s: %s", pkg, s)
+		}
+		if level < newMinLevel {
+			newMinLevel = level
+		}
+	}
+	levelsLock.Lock()
+	defer levelsLock.Unlock()
+	levels = newLevels
+	defaultLevel = newDefaultLevel
+	minLevel = newMinLevel
+	return nil
+}
+
+// isEnabled is a cheap check before formatting the message
+func isEnabled(level loggerLevel) bool {
+	levelsLock.RLock()
+	defer levelsLock.RUnlock()
+	return slogLevels[level] >= minLevel
+}
+
+func isEnabledFor(funcName string, level loggerLevel) bool {
+	pkg := packageOf(funcName)
+	levelsLock.RLock()
+	defer levelsLock.RUnlock()
+	threshold := defaultLevel
+	matched := ""
+	for prefix, s := range levels {
+		if prefix == "default" || len(prefix) <= len(matched) {
+			continue
+		}
+		if pkg == prefix || strings.HasPrefix(pkg, prefix+"/") {
+			matched = prefix
+			threshold, _ = parseLevel(s)
+		}
+	}
+	return slogLevels[level] >= threshold
+}
+
+// packageOf turns "github.com/songquanpeng/one-api/relay/controller.RelayTextHelper" into "relay/controller"
+func packageOf(funcName string) string {
+	funcName = strings.TrimPrefix(funcName, modulePath)
+	slash := strings.LastIndex(funcName, "/")
+	if dot := strings.Index(funcName[slash+1:], "."); dot >= 0 {
+		return funcName[:slash+1+dot]
+	}
+	return funcName
+}
@@ -0,0 +1,56 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPackageOf(t *testing.T) {
+	Convey("TestPackageOf", t, func() {
+		So(packageOf("github.com/songquanpeng/one-api/relay/controller.RelayTextHelper"), ShouldEqual, "relay/controller")
+		So(packageOf("github.com/songquanpeng/one-api/relay/adaptor/openai.(*Adaptor).DoResponse"), ShouldEqual, "relay/adaptor/openai")
+		So(packageOf("github.com/songquanpeng/one-api/model.InitDB.func1"), ShouldEqual, "model")
+	})
+}
+
+func TestUpdateLogLevels(t *testing.T) {
+	Convey("TestUpdateLogLevels", t, func() {
+		defer func() { _ = UpdateLogLevelsByJSONString("{}") }()
+
+		Convey("the longest matching package prefix wins", func() {
+			So(UpdateLogLevelsByJSONString(`{"default": "warn", "relay": "debug", "relay/adaptor": "error"}`), ShouldBeNil)
+			So(isEnabledFor(modulePath+"model.InitDB", loggerINFO), ShouldBeFalse)
+			So(isEnabledFor(modulePath+"model.InitDB", loggerWarn), ShouldBeTrue)
+			So(isEnabledFor(modulePath+"relay/controller.RelayTextHelper", loggerDEBUG), ShouldBeTrue)
+			So(isEnabledFor(modulePath+"relay/adaptor/openai.StreamHandler", loggerWarn), ShouldBeFalse)
+			So(isEnabledFor(modulePath+"relayx.Foo", loggerDEBUG), ShouldBeFalse)
+			// the cheap check allows the lowest configured level
+			So(isEnabled(loggerDEBUG), ShouldBeTrue)
+		})
+
+		Convey("rejects invalid levels and keeps the previous ones", func() {
+			So(UpdateLogLevelsByJSONString(`{"relay": "error"}`), ShouldBeNil)
+			So(UpdateLogLevelsByJSONString(`{"relay": "loud"}`), ShouldNotBeNil)
+			So(UpdateLogLevelsByJSONString(`{"default": "loud"}`), ShouldNotBeNil)
+			So(LogLevels2JSONString(), ShouldEqual, `{"relay":"error"}`)
+		})
+	})
+}
+
+func TestFields(t *testing.T) {
+	Convey("TestFields", t, func() {
+		SetField(context.Background(), "user_id", 1)
+		So(getFields(context.Background()), ShouldBeEmpty)
+
+		ctx := WithFields(context.Background())
+		SetField(ctx, "user_id", 1)
+		SetField(ctx, "channel_id", 2)
+		fields := getFields(ctx)
+		So(fields, ShouldResemble, map[string]any{"user_id": 1, "channel_id": 2})
+		// the returned map is a copy
+		fields["user_id"] = 3
+		So(getFields(ctx)["user_id"], ShouldEqual, 1)
+	})
+}
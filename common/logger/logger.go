@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
@@ -39,16 +40,37 @@ func SetupLogger() {
 			} else {
 				logPath = filepath.Join(LogDir, fmt.Sprintf("oneapi-%s.log", time.Now().Format("20060102")))
 			}
-			fd, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-			if err != nil {
-				log.Fatal("failed to open log file")
+			// lumberjack rotates the file by size and removes backups older than LogMaxAge
+			fd := &lumberjack.Logger{
+				Filename:  logPath,
+				MaxSize:   config.LogMaxSize,
+				MaxAge:    config.LogMaxAge,
+				LocalTime: true,
 			}
 			gin.DefaultWriter = io.MultiWriter(os.Stdout, fd)
 			gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, fd)
 		}
+		levelNames := make(map[slog.Level]string)
+		for name, level := range slogLevels {
+			levelNames[level] = string(name)
+		}
+		options := &slog.HandlerOptions{
+			Level: slog.LevelDebug, // filtered by isEnabledFor
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.LevelKey {
+					a.Value = slog.StringValue(levelNames[a.Value.Any().(slog.Level)])
+				}
+				return a
+			},
+		}
+		jsonHandler = slog.NewJSONHandler(gin.DefaultWriter, options)
+		jsonErrorHandler = slog.NewJSONHandler(gin.DefaultErrorWriter, options)
 	})
 }
 
+var jsonHandler slog.Handler
+var jsonErrorHandler slog.Handler
+
 func SysLog(s string) {
 	logHelper(nil, loggerINFO, s)
 }
@@ -74,7 +96,7 @@ func SysErrorf(format string, a ...any) {
 }
 
 func Debug(ctx context.Context, msg string) {
-	if !config.DebugEnabled {
+	if !isEnabled(loggerDEBUG) {
 		return
 	}
 	logHelper(ctx, loggerDEBUG, msg)
@@ -93,7 +115,7 @@ func Error(ctx context.Context, msg string) {
 }
 
 func Debugf(ctx context.Context, format string, a ...any) {
-	if !config.DebugEnabled {
+	if !isEnabled(loggerDEBUG) {
 		return
 	}
 	logHelper(ctx, loggerDEBUG, fmt.Sprintf(format, a...))
@@ -120,33 +142,54 @@ func FatalLogf(format string, a ...any) {
 }
 
 func logHelper(ctx context.Context, level loggerLevel, msg string) {
-	writer := gin.DefaultErrorWriter
-	if level == loggerINFO {
-		writer = gin.DefaultWriter
+	SetupLogger()
+	file, line, fullFuncName := getCaller()
+	if level != loggerFatal && !isEnabledFor(fullFuncName, level) {
+		return
 	}
 	var requestId string
 	if ctx != nil {
-		rawRequestId := helper.GetRequestID(ctx)
-		if rawRequestId != "" {
-			requestId = fmt.Sprintf(" | %s", rawRequestId)
-		}
+		requestId = helper.GetRequestID(ctx)
 	}
-	lineInfo, funcName := getLineInfo()
+	parts := strings.Split(fullFuncName, ".")
+	funcName := parts[len(parts)-1]
 	now := time.Now()
-	_, _ = fmt.Fprintf(writer, "[%s] %v%s%s %s%s \n", level, now.Format("2006/01/02 - 15:04:05"), requestId, lineInfo, funcName, msg)
-	SetupLogger()
+	if config.LogFormat == "json" {
+		handler := jsonErrorHandler
+		if level == loggerINFO || level == loggerDEBUG {
+			handler = jsonHandler
+		}
+		record := slog.NewRecord(now, slogLevels[level], msg, 0)
+		record.AddAttrs(slog.String("source", fmt.Sprintf("%s:%d", file, line)), slog.String("func", funcName))
+		if requestId != "" {
+			record.AddAttrs(slog.String("request_id", requestId))
+		}
+		for key, value := range getFields(ctx) {
+			record.AddAttrs(slog.Any(key, value))
+		}
+		_ = handler.Handle(context.Background(), record)
+	} else {
+		writer := gin.DefaultErrorWriter
+		if level == loggerINFO {
+			writer = gin.DefaultWriter
+		}
+		if requestId != "" {
+			requestId = fmt.Sprintf(" | %s", requestId)
+		}
+		_, _ = fmt.Fprintf(writer, "[%s] %v%s | %s:%d [%s] %s \n", level, now.Format("2006/01/02 - 15:04:05"), requestId, file, line, funcName, msg)
+	}
 	if level == loggerFatal {
 		os.Exit(1)
 	}
 }
 
-func getLineInfo() (string, string) {
-	funcName := "[unknown] "
+// getCaller returns the position and full function name of the caller of the exported log functions
+func getCaller() (string, int, string) {
+	funcName := "unknown"
 	pc, file, line, ok := runtime.Caller(3)
 	if ok {
 		if fn := runtime.FuncForPC(pc); fn != nil {
-			parts := strings.Split(fn.Name(), ".")
-			funcName = "[" + parts[len(parts)-1] + "] "
+			funcName = fn.Name()
 		}
 	} else {
 		file = "unknown"
@@ -156,5 +199,5 @@ func getLineInfo() (string, string) {
 	if len(parts) > 1 {
 		file = parts[1]
 	}
-	return fmt.Sprintf(" | %s:%d", file, line), funcName
+	return file, line, funcName
 }
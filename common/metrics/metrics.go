@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "one_api"
+
+var (
+	relayRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_requests_total",
+		Help:      "Relay requests by model, channel, group and response status code.",
+	}, []string{"model", "channel", "group", "status"})
+	relayRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_retries_total",
+		Help:      "Relay retries on another channel.",
+	}, []string{"model", "group"})
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_latency_seconds",
+		Help:      "Time until the upstream response headers are received.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"model", "channel"})
+	firstTokenLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "time_to_first_token_seconds",
+		Help:      "Time until the first chunk of a stream is sent to the client.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"model", "channel"})
+	relayTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "relay_tokens_total",
+		Help:      "Tokens billed by model and type (prompt or completion).",
+	}, []string{"model", "type"})
+	quotaConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quota_consumed_total",
+		Help:      "Quota consumed by model and group.",
+	}, []string{"model", "group"})
+	channelResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_results_total",
+		Help:      "Channel call results reported to the monitor.",
+	}, []string{"channel", "success"})
+	channelDisables = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_disables_total",
+		Help:      "Channels disabled automatically.",
+	}, []string{"channel"})
+	logQueueLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "log_queue_length",
+		Help:      "Logs waiting in the async log writer queue.",
+	})
+	logQueueEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_queue_events_total",
+		Help:      "Logs delayed because the queue was full, and logs dropped or written synchronously after waiting.",
+	}, []string{"event"})
+	channelCacheSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_cache_size",
+		Help:      "Entries in the channel cache, by kind (channels or group_models).",
+	}, []string{"kind"})
+)
+
+func init() {
+	prometheus.MustRegister(relayRequests, relayRetries, upstreamLatency, firstTokenLatency,
+		relayTokens, quotaConsumed, channelResults, channelDisables, channelCacheSize, logQueueLength, logQueueEvents)
+}
+
+func ObserveRelayRequest(model string, channelId int, group string, statusCode int) {
+	relayRequests.WithLabelValues(model, strconv.Itoa(channelId), group, strconv.Itoa(statusCode)).Inc()
+}
+
+func ObserveRelayRetry(model string, group string) {
+	relayRetries.WithLabelValues(model, group).Inc()
+}
+
+func ObserveUpstreamLatency(model string, channelId int, latency time.Duration) {
+	upstreamLatency.WithLabelValues(model, strconv.Itoa(channelId)).Observe(latency.Seconds())
+}
+
+func ObserveFirstTokenLatency(model string, channelId int, latency time.Duration) {
+	firstTokenLatency.WithLabelValues(model, strconv.Itoa(channelId)).Observe(latency.Seconds())
+}
+
+func ObserveConsumption(model string, group string, promptTokens int, completionTokens int, quota int64) {
+	relayTokens.WithLabelValues(model, "prompt").Add(float64(promptTokens))
+	relayTokens.WithLabelValues(model, "completion").Add(float64(completionTokens))
+	if quota > 0 {
+		quotaConsumed.WithLabelValues(model, group).Add(float64(quota))
+	}
+}
+
+func ObserveChannelResult(channelId int, success bool) {
+	channelResults.WithLabelValues(strconv.Itoa(channelId), strconv.FormatBool(success)).Inc()
+}
+
+func ObserveChannelDisable(channelId int) {
+	channelDisables.WithLabelValues(strconv.Itoa(channelId)).Inc()
+}
+
+func SetChannelCacheSize(channels int, groupModels int) {
+	channelCacheSize.WithLabelValues("channels").Set(float64(channels))
+	channelCacheSize.WithLabelValues("group_models").Set(float64(groupModels))
+}
+
+func SetLogQueueLength(length int) {
+	logQueueLength.Set(float64(length))
+}
+
+func ObserveLogDelayed() {
+	logQueueEvents.WithLabelValues("delayed").Inc()
+}
+
+func ObserveLogDropped() {
+	logQueueEvents.WithLabelValues("dropped").Inc()
+}
+
+func ObserveLogWrittenSynchronously() {
+	logQueueEvents.WithLabelValues("sync").Inc()
+}
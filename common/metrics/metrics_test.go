@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestObserveConsumption(t *testing.T) {
+	Convey("TestObserveConsumption", t, func() {
+		ObserveConsumption("test-model", "default", 10, 20, 30)
+		ObserveConsumption("test-model", "default", 1, 2, 0)
+		So(testutil.ToFloat64(relayTokens.WithLabelValues("test-model", "prompt")), ShouldEqual, 11)
+		So(testutil.ToFloat64(relayTokens.WithLabelValues("test-model", "completion")), ShouldEqual, 22)
+		So(testutil.ToFloat64(quotaConsumed.WithLabelValues("test-model", "default")), ShouldEqual, 30)
+	})
+}
+
+func TestFirstTokenWriter(t *testing.T) {
+	Convey("TestFirstTokenWriter", t, func() {
+		gin.SetMode(gin.TestMode)
+		observed := 0
+		newWriter := func(contentType string) *FirstTokenWriter {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Writer.Header().Set("Content-Type", contentType)
+			return NewFirstTokenWriter(c.Writer, time.Now(), func(time.Duration) { observed++ })
+		}
+
+		Convey("observes the first chunk of a stream only", func() {
+			w := newWriter("text/event-stream")
+			_, _ = w.Write([]byte("data: {}\n\n"))
+			_, _ = w.WriteString("data: [DONE]\n\n")
+			So(observed, ShouldEqual, 1)
+		})
+
+		Convey("ignores non-stream responses", func() {
+			w := newWriter("application/json")
+			_, _ = w.Write([]byte("{}"))
+			So(observed, ShouldEqual, 0)
+		})
+	})
+}
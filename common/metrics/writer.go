@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FirstTokenWriter reports the time until the first chunk of an event stream is written.
+type FirstTokenWriter struct {
+	gin.ResponseWriter
+	start    time.Time
+	observed bool
+	observe  func(time.Duration)
+}
+
+func NewFirstTokenWriter(w gin.ResponseWriter, start time.Time, observe func(time.Duration)) *FirstTokenWriter {
+	return &FirstTokenWriter{ResponseWriter: w, start: start, observe: observe}
+}
+
+func (w *FirstTokenWriter) Write(data []byte) (int, error) {
+	w.check()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *FirstTokenWriter) WriteString(s string) (int, error) {
+	w.check()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *FirstTokenWriter) check() {
+	if w.observed {
+		return
+	}
+	w.observed = true
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.observe(time.Since(w.start))
+	}
+}
@@ -0,0 +1,63 @@
+package network
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// MatchPatterns reports whether value matches one of the comma separated patterns,
+// "*" in a pattern matches any sequence of characters.
+func MatchPatterns(value string, patterns string) bool {
+	if value == "" {
+		return false
+	}
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" && matchPattern(value, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(value string, pattern string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return value == pattern
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(value, part)
+		if idx < 0 {
+			return false
+		}
+		value = value[idx+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// GetOrigin returns the scheme and host part of rawUrl, e.g. the origin of a referer.
+func GetOrigin(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func IsValidOriginPatterns(patterns string) error {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !strings.HasPrefix(pattern, "http://") && !strings.HasPrefix(pattern, "https://") {
+			return fmt.Errorf("%s 必须以 http:// 或 https:// 开头", pattern)
+		}
+	}
+	return nil
+}
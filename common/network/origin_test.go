@@ -0,0 +1,25 @@
+package network
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchPatterns(t *testing.T) {
+	Convey("TestMatchPatterns", t, func() {
+		So(MatchPatterns("https://app.example.com", "https://app.example.com"), ShouldBeTrue)
+		So(MatchPatterns("https://a.example.com", "https://foo.com, https://*.example.com"), ShouldBeTrue)
+		So(MatchPatterns("https://example.com.evil.com", "https://*.example.com"), ShouldBeFalse)
+		So(MatchPatterns("https://example.com/chat/index.html", "https://example.com/*"), ShouldBeTrue)
+		So(MatchPatterns("http://example.com/chat", "https://example.com/*"), ShouldBeFalse)
+		So(MatchPatterns("", "*"), ShouldBeFalse)
+	})
+}
+
+func TestGetOrigin(t *testing.T) {
+	Convey("TestGetOrigin", t, func() {
+		So(GetOrigin("https://example.com:8080/chat?a=1"), ShouldEqual, "https://example.com:8080")
+		So(GetOrigin("not a url"), ShouldEqual, "")
+	})
+}
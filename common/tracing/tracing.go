@@ -0,0 +1,62 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	AttrChannelId = attribute.Key("oneapi.channel_id")
+	AttrModel     = attribute.Key("oneapi.model")
+	AttrRetry     = attribute.Key("oneapi.retry")
+	AttrUserId    = attribute.Key("oneapi.user_id")
+	AttrTokenId   = attribute.Key("oneapi.token_id")
+)
+
+var tracer = otel.Tracer("github.com/songquanpeng/one-api")
+
+// Init installs the OTLP exporter when an endpoint is configured, spans are no-op otherwise.
+// The returned function flushes the pending spans.
+func Init() func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if config.OtelExporterEndpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(config.OtelExporterEndpoint)}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		logger.FatalLog("failed to create OTLP trace exporter: " + err.Error())
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.OtelSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.OtelServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	logger.SysLog("tracing enabled, exporting spans to " + config.OtelExporterEndpoint)
+	return provider.Shutdown
+}
+
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on span if any and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
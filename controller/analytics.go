@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func getUsageQuery(c *gin.Context) *model.UsageQuery {
+	query := &model.UsageQuery{
+		Granularity: c.DefaultQuery("granularity", model.RollupGranularityDay),
+		Series:      c.Query("series") == "true",
+		ModelName:   c.Query("model_name"),
+		Group:       c.Query("group"),
+	}
+	query.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	query.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	query.UserId, _ = strconv.Atoi(c.Query("user_id"))
+	query.TokenId, _ = strconv.Atoi(c.Query("token_id"))
+	query.ChannelId, _ = strconv.Atoi(c.Query("channel"))
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		query.GroupBy = strings.Split(groupBy, ",")
+	}
+	return query
+}
+
+func GetUsageAnalytics(c *gin.Context) {
+	stats, err := model.GetUsageStats(getUsageQuery(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+func GetUserUsageAnalytics(c *gin.Context) {
+	query := getUsageQuery(c)
+	query.UserId = c.GetInt(ctxkey.Id)
+	// channels are not visible to common users
+	query.ChannelId = 0
+	for _, groupBy := range query.GroupBy {
+		if groupBy == "channel" || groupBy == "user" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权按此字段分组：" + groupBy,
+			})
+			return
+		}
+	}
+	stats, err := model.GetUsageStats(query)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, stat := range stats {
+		stat.UpstreamCost = 0
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+type channelMargin struct {
+	ChannelId    int     `json:"channel"`
+	ChannelName  string  `json:"channel_name"`
+	RequestCount int64   `json:"request_count"`
+	Quota        int64   `json:"quota"`
+	UpstreamCost int64   `json:"upstream_cost"`
+	Margin       int64   `json:"margin"`
+	MarginRate   float64 `json:"margin_rate"` // margin / quota
+}
+
+// GetChannelMargins compares what users are charged with what the channels charge us
+func GetChannelMargins(c *gin.Context) {
+	query := getUsageQuery(c)
+	query.Series = false
+	query.GroupBy = []string{"channel"}
+	stats, err := model.GetUsageStats(query)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channelIds := make([]int, 0, len(stats))
+	for _, stat := range stats {
+		channelIds = append(channelIds, stat.ChannelId)
+	}
+	names, err := model.GetChannelNames(channelIds)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	margins := make([]channelMargin, 0, len(stats))
+	for _, stat := range stats {
+		margin := channelMargin{
+			ChannelId:    stat.ChannelId,
+			ChannelName:  names[stat.ChannelId],
+			RequestCount: stat.RequestCount,
+			Quota:        stat.Quota,
+			UpstreamCost: stat.UpstreamCost,
+			Margin:       stat.Quota - stat.UpstreamCost,
+		}
+		if stat.Quota != 0 {
+			margin.MarginRate = float64(margin.Margin) / float64(stat.Quota)
+		}
+		margins = append(margins, margin)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    margins,
+	})
+}
+
+// BackfillUsageRollups rebuilds the rollups of the given days from the logs in the background
+func BackfillUsageRollups(c *gin.Context) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if startTimestamp <= 0 || endTimestamp <= startTimestamp {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的时间范围",
+		})
+		return
+	}
+	go func() {
+		if err := model.BackfillUsageRollups(startTimestamp, endTimestamp); err != nil {
+			logger.SysError("failed to backfill usage rollups: " + err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -77,6 +77,9 @@ func GetChannel(c *gin.Context) {
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
+	if err == nil {
+		err = channel.ValidateUpstreamPrices()
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -148,6 +151,9 @@ func DeleteDisabledChannel(c *gin.Context) {
 func UpdateChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
+	if err == nil {
+		err = channel.ValidateUpstreamPrices()
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
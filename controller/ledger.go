@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func getLedgerFilter(c *gin.Context) *model.LedgerFilter {
+	filter := &model.LedgerFilter{
+		Type:      c.Query("type"),
+		Reference: c.Query("reference"),
+	}
+	filter.UserId, _ = strconv.Atoi(c.Query("user_id"))
+	filter.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return filter
+}
+
+func getLedgerEntries(c *gin.Context, filter *model.LedgerFilter) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	entries, err := model.GetLedgerEntries(filter, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    entries,
+	})
+}
+
+func GetLedgerEntries(c *gin.Context) {
+	getLedgerEntries(c, getLedgerFilter(c))
+}
+
+func GetSelfLedgerEntries(c *gin.Context) {
+	filter := getLedgerFilter(c)
+	filter.UserId = c.GetInt(ctxkey.Id)
+	getLedgerEntries(c, filter)
+}
+
+// GetLedgerReconciliation returns the report of the last reconciliation, nil before the first one
+func GetLedgerReconciliation(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetLastLedgerReconciliation(),
+	})
+}
+
+func ReconcileLedger(c *gin.Context) {
+	report, err := model.ReconcileLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
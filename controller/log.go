@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
 	"strconv"
@@ -111,13 +112,25 @@ func GetLogsStat(c *gin.Context) {
 	channel, _ := strconv.Atoi(c.Query("channel"))
 	quotaNum := model.SumUsedQuota(logType, startTimestamp, endTimestamp, modelName, username, tokenName, channel)
 	//tokenNum := model.SumUsedToken(logType, startTimestamp, endTimestamp, modelName, username, "")
+	data := gin.H{
+		"quota": quotaNum,
+		//"token": tokenNum,
+	}
+	if c.Query("latency") == "true" {
+		latency, err := model.GetLatencyStats(startTimestamp, endTimestamp, modelName, channel)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		data["latency"] = latency
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data": gin.H{
-			"quota": quotaNum,
-			//"token": tokenNum,
-		},
+		"data":    data,
 	})
 	return
 }
@@ -167,3 +180,79 @@ func DeleteHistoryLogs(c *gin.Context) {
 	})
 	return
 }
+
+func GetLogArchives(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	archives, err := model.GetLogArchives(p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"running":  model.IsArchivingLogs(),
+			"policies": model.GetLogRetentionPolicies(),
+			"archives": archives,
+		},
+	})
+}
+
+// ArchiveLogs starts archiving the expired logs without waiting for the scheduled run
+func ArchiveLogs(c *gin.Context) {
+	if model.IsArchivingLogs() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "日志正在归档中",
+		})
+		return
+	}
+	go func() {
+		if err := model.ArchiveExpiredLogs(); err != nil {
+			logger.SysError("failed to archive logs: " + err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetPayloadLog(c *gin.Context) {
+	log, err := model.GetPayloadLogByRequestId(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    log,
+	})
+}
+
+func GetUserPayloadLog(c *gin.Context) {
+	log, err := model.GetUserPayloadLogByRequestId(c.GetInt(ctxkey.Id), c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    log,
+	})
+}
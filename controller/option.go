@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/i18n"
 	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 
 	"github.com/gin-gonic/gin"
 )
@@ -77,6 +79,25 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "ModelRatio", "CompletionRatio":
+		config.OptionMapRWMutex.RLock()
+		oldValue := config.OptionMap[option.Key]
+		config.OptionMapRWMutex.RUnlock()
+		keys, err := billingratio.GetCatalogPricedRatioChanges(oldValue, option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if len(keys) > 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("以下模型的倍率由价格目录决定，请在价格目录中修改其价格：%s", strings.Join(keys, ", ")),
+			})
+			return
+		}
 	case "TurnstileCheckEnabled":
 		if option.Value == "true" && config.TurnstileSiteKey == "" {
 			c.JSON(http.StatusOK, gin.H{
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -17,9 +18,10 @@ import (
 
 // 支付请求结构体
 type PaymentRequest struct {
-	Amount        float64 `json:"amount" binding:"required"`         // 充值金额（元）
+	Amount        float64 `json:"amount"`                            // 充值金额（元），订阅套餐时忽略
 	TopUpCode     string  `json:"top_up_code"`                       // 充值码
 	PaymentMethod string  `json:"payment_method" binding:"required"` // 支付方式
+	PlanId        int     `json:"plan_id"`                           // 订阅套餐ID，支付一个计费周期
 }
 
 // 支付响应结构体
@@ -58,6 +60,20 @@ func CreatePayment(c *gin.Context) {
 
 	userID := c.GetInt(ctxkey.Id)
 
+	// 订阅套餐按套餐价格支付
+	if req.PlanId != 0 {
+		plan, err := getPayablePlan(userID, req.PlanId)
+		if err != nil {
+			c.JSON(http.StatusOK, PaymentResponse{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+		req.Amount = plan.Price
+		req.TopUpCode = ""
+	}
+
 	// 验证充值金额
 	if req.Amount <= 0 {
 		c.JSON(http.StatusOK, PaymentResponse{
@@ -81,6 +97,7 @@ func CreatePayment(c *gin.Context) {
 		Status:        "pending", // 待处理状态
 		OrderID:       orderID,
 		TopUpCode:     req.TopUpCode,
+		PlanId:        req.PlanId,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
@@ -114,6 +131,22 @@ func CreatePayment(c *gin.Context) {
 	})
 }
 
+// 检查套餐是否可以购买：套餐需已上架，且用户没有生效中的其他套餐
+func getPayablePlan(userID int, planID int) (*model.SubscriptionPlan, error) {
+	plan, err := model.GetSubscriptionPlanById(planID)
+	if err != nil || plan.Status != model.SubscriptionPlanStatusEnabled {
+		return nil, errors.New("套餐不存在或已下架")
+	}
+	subscription, err := model.GetActiveSubscription(userID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription != nil && subscription.PlanId != plan.Id {
+		return nil, model.ErrSubscriptionConflict
+	}
+	return plan, nil
+}
+
 // 计算充值金额
 func CalculateAmount(c *gin.Context) {
 	var req AmountRequest
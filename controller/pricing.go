@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// GetModelPrices exports the price catalog in effect
+func GetModelPrices(c *gin.Context) {
+	prices, versionId := billingratio.GetActivePrices(helper.GetTimestamp())
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"version_id": versionId,
+			"prices":     prices,
+		},
+	})
+}
+
+// ImportModelPrices replaces the price catalog, or merges into it with ?mode=merge,
+// the result is saved as a price version which takes effect immediately.
+func ImportModelPrices(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的价格表",
+		})
+		return
+	}
+	prices, err := billingratio.ParseModelPrices(string(body))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("mode") == "merge" {
+		current, _ := billingratio.GetActivePrices(helper.GetTimestamp())
+		for name, price := range prices {
+			current[name] = price
+		}
+		prices = current
+	}
+	body, err = json.Marshal(prices)
+	if err == nil {
+		err = model.CreatePriceVersion(&model.PriceVersion{Prices: string(body), Comment: "import"})
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    len(prices),
+	})
+}
+
+// ValidateModelPrices reports the models served by channels which have no price in the catalog
+func ValidateModelPrices(c *gin.Context) {
+	counts, err := model.GetEnabledModelChannelCounts()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	missing := make([]model.ModelChannelCount, 0)
+	ratioOnly := make([]model.ModelChannelCount, 0)
+	for _, count := range counts {
+		switch billingratio.GetPriceSource(count.Model) {
+		case billingratio.PriceSourceCatalog:
+		case billingratio.PriceSourceRatio:
+			ratioOnly = append(ratioOnly, count)
+		default:
+			missing = append(missing, count)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"missing":    missing,
+			"ratio_only": ratioOnly,
+		},
+	})
+}
+
+type priceVersionRequest struct {
+	Prices      map[string]billingratio.ModelPrice `json:"prices"`
+	EffectiveAt int64                              `json:"effective_at"`
+	Comment     string                             `json:"comment"`
+}
+
+func GetPriceVersions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	versions, err := model.GetPriceVersions(p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"active_id": billingratio.GetActivePriceVersionId(),
+			"versions":  versions,
+		},
+	})
+}
+
+func GetPriceVersion(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	version, err := model.GetPriceVersionById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    version,
+	})
+}
+
+// PreviewPriceVersion compares the prices with the catalog which would be in effect right before them
+func PreviewPriceVersion(c *gin.Context) {
+	var request priceVersionRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	effectiveAt := request.EffectiveAt
+	if effectiveAt == 0 {
+		effectiveAt = helper.GetTimestamp()
+	}
+	current, versionId := billingratio.GetActivePrices(effectiveAt)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"base_version_id": versionId,
+			"changes":         billingratio.DiffModelPrices(current, request.Prices),
+		},
+	})
+}
+
+// CreatePriceVersion schedules the prices, which take effect immediately without effective_at
+func CreatePriceVersion(c *gin.Context) {
+	var request priceVersionRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || request.Prices == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	prices, _ := json.Marshal(request.Prices)
+	version := &model.PriceVersion{
+		Prices:      string(prices),
+		EffectiveAt: request.EffectiveAt,
+		Comment:     request.Comment,
+	}
+	if err := model.CreatePriceVersion(version); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    version,
+	})
+}
+
+func DeletePriceVersion(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeletePriceVersion(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
@@ -13,11 +15,14 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/payloadlog"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
@@ -42,6 +47,23 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 	return err
 }
 
+// tracedRelayHelper runs an attempt in its own span, retry is 0 for the first attempt
+func tracedRelayHelper(ctx context.Context, c *gin.Context, relayMode int, retry int) *model.ErrorWithStatusCode {
+	ctx, span := tracing.Start(ctx, "relay",
+		tracing.AttrChannelId.Int(c.GetInt(ctxkey.ChannelId)),
+		tracing.AttrModel.String(c.GetString(ctxkey.OriginalModel)),
+		tracing.AttrRetry.Int(retry),
+	)
+	c.Request = c.Request.WithContext(ctx)
+	bizErr := relayHelper(c, relayMode)
+	if bizErr != nil {
+		tracing.End(span, fmt.Errorf("status code %d: %s", bizErr.StatusCode, bizErr.Message))
+		return bizErr
+	}
+	span.End()
+	return nil
+}
+
 func Relay(c *gin.Context) {
 	ctx := c.Request.Context()
 	relayMode := relaymode.GetByPath(c.Request.URL.Path)
@@ -49,13 +71,25 @@ func Relay(c *gin.Context) {
 		requestBody, _ := common.GetRequestBody(c)
 		logger.Debugf(ctx, "request body: %s", string(requestBody))
 	}
+	c.Writer = metrics.NewFirstTokenWriter(c.Writer, time.Now(), func(latency time.Duration) {
+		c.Set(ctxkey.FirstTokenAt, time.Now())
+		metrics.ObserveFirstTokenLatency(c.GetString(ctxkey.RequestModel), c.GetInt(ctxkey.ChannelId), latency)
+	})
+	defer func() {
+		metrics.ObserveRelayRequest(c.GetString(ctxkey.RequestModel), c.GetInt(ctxkey.ChannelId), c.GetString(ctxkey.Group), c.Writer.Status())
+	}()
+	payloadlog.Start(c)
+	defer payloadlog.Finish(c)
 	channelId := c.GetInt(ctxkey.ChannelId)
 	userId := c.GetInt(ctxkey.Id)
-	bizErr := relayHelper(c, relayMode)
+	startTime := time.Now()
+	c.Set(ctxkey.RetryChain, strconv.Itoa(channelId))
+	bizErr := tracedRelayHelper(ctx, c, relayMode, 0)
 	if bizErr == nil {
 		monitor.Emit(channelId, true)
 		return
 	}
+	payloadlog.RecordAttempt(c, bizErr)
 	lastFailedChannelId := channelId
 	channelName := c.GetString(ctxkey.ChannelName)
 	group := c.GetString(ctxkey.Group)
@@ -77,19 +111,24 @@ func Relay(c *gin.Context) {
 		if channel.Id == lastFailedChannelId {
 			continue
 		}
+		metrics.ObserveRelayRetry(originalModel, group)
 		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		c.Set(ctxkey.RetryChain, c.GetString(ctxkey.RetryChain)+","+strconv.Itoa(channel.Id))
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		bizErr = relayHelper(c, relayMode)
+		bizErr = tracedRelayHelper(ctx, c, relayMode, retryTimes-i+1)
 		if bizErr == nil {
 			return
 		}
+		payloadlog.RecordAttempt(c, bizErr)
 		channelId := c.GetInt(ctxkey.ChannelId)
 		lastFailedChannelId = channelId
 		channelName := c.GetString(ctxkey.ChannelName)
 		go processChannelRelayError(ctx, userId, channelId, channelName, *bizErr)
 	}
 	if bizErr != nil {
+		errorLog := getRelayErrorLog(c, bizErr, originalModel, startTime)
+		go dbmodel.RecordErrorLog(ctx, errorLog)
 		if bizErr.StatusCode == http.StatusTooManyRequests {
 			bizErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
@@ -121,6 +160,26 @@ func shouldRetry(c *gin.Context, statusCode int) bool {
 	return true
 }
 
+// getRelayErrorLog keeps the final error of a failed relay along with the channels tried
+func getRelayErrorLog(c *gin.Context, err *model.ErrorWithStatusCode, modelName string, startTime time.Time) *dbmodel.Log {
+	errorCode := ""
+	if err.Code != nil {
+		errorCode = fmt.Sprintf("%v", err.Code)
+	}
+	return &dbmodel.Log{
+		UserId:      c.GetInt(ctxkey.Id),
+		ChannelId:   c.GetInt(ctxkey.ChannelId),
+		ModelName:   modelName,
+		TokenName:   c.GetString(ctxkey.TokenName),
+		TokenId:     c.GetInt(ctxkey.TokenId),
+		Content:     err.Message,
+		StatusCode:  err.StatusCode,
+		ErrorCode:   errorCode,
+		RetryChain:  c.GetString(ctxkey.RetryChain),
+		ElapsedTime: helper.CalcElapsedTime(startTime),
+	}
+}
+
 func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, err model.ErrorWithStatusCode) {
 	logger.Errorf(ctx, "relay error (channel id %d, user id: %d): %s", channelId, userId, err.Message)
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
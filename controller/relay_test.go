@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestGetRelayErrorLog(t *testing.T) {
+	Convey("TestGetRelayErrorLog", t, func() {
+		gin.SetMode(gin.TestMode)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.ChannelId, 7)
+		c.Set(ctxkey.TokenName, "default")
+		c.Set(ctxkey.RetryChain, "3,5,7")
+		bizErr := &model.ErrorWithStatusCode{
+			Error:      model.Error{Message: "upstream error", Code: 500},
+			StatusCode: http.StatusBadGateway,
+		}
+
+		log := getRelayErrorLog(c, bizErr, "gpt-4o", time.Now().Add(-time.Second))
+		So(log.UserId, ShouldEqual, 1)
+		So(log.ChannelId, ShouldEqual, 7)
+		So(log.TokenName, ShouldEqual, "default")
+		So(log.ModelName, ShouldEqual, "gpt-4o")
+		So(log.Content, ShouldEqual, "upstream error")
+		So(log.StatusCode, ShouldEqual, http.StatusBadGateway)
+		So(log.ErrorCode, ShouldEqual, "500")
+		So(log.RetryChain, ShouldEqual, "3,5,7")
+		So(log.ElapsedTime, ShouldBeGreaterThanOrEqualTo, 1000)
+	})
+}
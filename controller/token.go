@@ -10,7 +10,9 @@ import (
 	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 )
 
 func GetAllTokens(c *gin.Context) {
@@ -117,6 +119,18 @@ func validateToken(c *gin.Context, token model.Token) error {
 			return fmt.Errorf("无效的网段：%s", err.Error())
 		}
 	}
+	if token.AllowedOrigins != nil && *token.AllowedOrigins != "" {
+		err := network.IsValidOriginPatterns(*token.AllowedOrigins)
+		if err != nil {
+			return fmt.Errorf("无效的来源：%s", err.Error())
+		}
+	}
+	if token.AllowedReferers != nil && *token.AllowedReferers != "" {
+		err := network.IsValidOriginPatterns(*token.AllowedReferers)
+		if err != nil {
+			return fmt.Errorf("无效的引用页：%s", err.Error())
+		}
+	}
 	return nil
 }
 
@@ -140,16 +154,18 @@ func AddToken(c *gin.Context) {
 	}
 
 	cleanToken := model.Token{
-		UserId:         c.GetInt(ctxkey.Id),
-		Name:           token.Name,
-		Key:            random.GenerateKey(),
-		CreatedTime:    helper.GetTimestamp(),
-		AccessedTime:   helper.GetTimestamp(),
-		ExpiredTime:    token.ExpiredTime,
-		RemainQuota:    token.RemainQuota,
-		UnlimitedQuota: token.UnlimitedQuota,
-		Models:         token.Models,
-		Subnet:         token.Subnet,
+		UserId:          c.GetInt(ctxkey.Id),
+		Name:            token.Name,
+		Key:             random.GenerateKey(),
+		CreatedTime:     helper.GetTimestamp(),
+		AccessedTime:    helper.GetTimestamp(),
+		ExpiredTime:     token.ExpiredTime,
+		RemainQuota:     token.RemainQuota,
+		UnlimitedQuota:  token.UnlimitedQuota,
+		Models:          token.Models,
+		Subnet:          token.Subnet,
+		AllowedOrigins:  token.AllowedOrigins,
+		AllowedReferers: token.AllowedReferers,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -239,6 +255,8 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.AllowedOrigins = token.AllowedOrigins
+		cleanToken.AllowedReferers = token.AllowedReferers
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -255,3 +273,159 @@ func UpdateToken(c *gin.Context) {
 	})
 	return
 }
+
+type rotateTokenRequest struct {
+	GracePeriod *int64 `json:"grace_period"` // unit is second
+}
+
+func RotateToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := rotateTokenRequest{}
+	if c.Request.ContentLength > 0 {
+		err = c.ShouldBindJSON(&req)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	gracePeriod := int64(config.TokenRotationGracePeriod)
+	if req.GracePeriod != nil {
+		gracePeriod = *req.GracePeriod
+	}
+	if gracePeriod < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误：宽限期不能为负数",
+		})
+		return
+	}
+	token, err := model.GetTokenByIds(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = token.Rotate(gracePeriod)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+}
+
+type ephemeralTokenRequest struct {
+	TokenId int    `json:"token_id"`
+	TTL     int64  `json:"ttl"` // unit is second
+	Quota   int64  `json:"quota"`
+	Models  string `json:"models"`
+	Origin  string `json:"origin"`
+}
+
+func AddEphemeralToken(c *gin.Context) {
+	req := ephemeralTokenRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// when authorized by an API token, it is always the parent
+	if tokenId := c.GetInt(ctxkey.TokenId); tokenId != 0 {
+		req.TokenId = tokenId
+	}
+	parent, err := model.GetTokenByIds(req.TokenId, c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = validateEphemeralToken(parent, &req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	key, claims, err := model.MintEphemeralToken(parent, req.TTL, req.Quota, req.Models, req.Origin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key":             "sk-" + key,
+			"parent_token_id": parent.Id,
+			"expired_time":    claims.ExpiresAt,
+			"quota":           claims.Quota,
+			"models":          claims.Models,
+			"origin":          claims.Origin,
+		},
+	})
+}
+
+func validateEphemeralToken(parent *model.Token, req *ephemeralTokenRequest) error {
+	if parent.Status != model.TokenStatusEnabled {
+		return fmt.Errorf("父令牌状态不可用")
+	}
+	if req.TTL <= 0 || req.TTL > int64(config.EphemeralTokenMaxTTL) {
+		return fmt.Errorf("有效期必须在 1 到 %d 秒之间", config.EphemeralTokenMaxTTL)
+	}
+	if req.Quota < 0 {
+		return fmt.Errorf("额度不能为负数")
+	}
+	if !parent.UnlimitedQuota && req.Quota > parent.RemainQuota {
+		return fmt.Errorf("额度超过父令牌剩余额度")
+	}
+	requestModels := splitModels(req.Models)
+	req.Models = strings.Join(requestModels, ",")
+	if len(requestModels) != 0 && parent.GetModels() != "" {
+		parentModels := splitModels(parent.GetModels())
+		for _, modelName := range requestModels {
+			if !slices.Contains(parentModels, modelName) {
+				return fmt.Errorf("父令牌无权使用模型：%s", modelName)
+			}
+		}
+	}
+	return nil
+}
+
+// splitModels splits a comma separated model list, dropping the blanks around and between the names
+func splitModels(models string) []string {
+	var result []string
+	for _, modelName := range strings.Split(models, ",") {
+		modelName = strings.TrimSpace(modelName)
+		if modelName != "" {
+			result = append(result, modelName)
+		}
+	}
+	return result
+}
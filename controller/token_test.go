@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestValidateEphemeralToken(t *testing.T) {
+	Convey("TestValidateEphemeralToken", t, func() {
+		parentModels := "gpt-4o, gpt-4o-mini"
+		parent := &model.Token{Status: model.TokenStatusEnabled, RemainQuota: 1000, Models: &parentModels}
+
+		req := &ephemeralTokenRequest{TTL: 60, Quota: 10, Models: " gpt-4o-mini ,gpt-4o,, "}
+		So(validateEphemeralToken(parent, req), ShouldBeNil)
+		So(req.Models, ShouldEqual, "gpt-4o-mini,gpt-4o")
+
+		So(validateEphemeralToken(parent, &ephemeralTokenRequest{TTL: 60, Models: "gpt-4"}), ShouldNotBeNil)
+		So(validateEphemeralToken(parent, &ephemeralTokenRequest{TTL: 0}), ShouldNotBeNil)
+		So(validateEphemeralToken(parent, &ephemeralTokenRequest{TTL: 60, Quota: 2000}), ShouldNotBeNil)
+		So(validateEphemeralToken(parent, &ephemeralTokenRequest{TTL: 60, Quota: -1}), ShouldNotBeNil)
+
+		parent.Status = model.TokenStatusDisabled
+		So(validateEphemeralToken(parent, &ephemeralTokenRequest{TTL: 60}), ShouldNotBeNil)
+	})
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -16,10 +22,12 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/i18n"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	"github.com/songquanpeng/one-api/router"
 )
 
@@ -37,10 +45,15 @@ func main() {
 	if config.DebugEnabled {
 		logger.SysLog("running in debug mode")
 	}
+	shutdownTracing := tracing.Init()
+	defer func() {
+		_ = shutdownTracing(context.Background())
+	}()
 
 	// Initialize SQL Database
 	model.InitDB()
 	model.InitLogDB()
+	model.InitLogStore()
 
 	var err error
 	err = model.CreateRootAccountIfNeed()
@@ -62,6 +75,9 @@ func main() {
 
 	// Initialize options
 	model.InitOptionMap()
+	if err = model.LoadPriceVersions(); err != nil {
+		logger.SysError("failed to load price versions: " + err.Error())
+	}
 	logger.SysLog(fmt.Sprintf("using theme %s", config.Theme))
 	if common.RedisEnabled {
 		// for compatibility with old versions
@@ -74,8 +90,21 @@ func main() {
 	}
 	if config.MemoryCacheEnabled {
 		go model.SyncOptions(config.SyncFrequency)
+		go model.SyncPriceVersions(config.SyncFrequency)
 		go model.SyncChannelCache(config.SyncFrequency)
 	}
+	if config.IsMasterNode {
+		go model.CleanPayloadLogs(60 * 60)
+		go model.ArchiveLogs(60 * 60)
+		go model.ReconcileLedgerPeriodically(config.LedgerReconcileInterval)
+		go model.ReclaimQuotaReservations(60)
+		go model.ProcessSubscriptionsPeriodically(config.SubscriptionCheckInterval)
+	}
+	model.InitUsageRollupLocation()
+	go model.SyncUsageRollups(config.UsageRollupFlushInterval)
+	if config.AsyncLogEnabled {
+		model.StartLogWriter()
+	}
 	if os.Getenv("CHANNEL_TEST_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_TEST_FREQUENCY"))
 		if err != nil {
@@ -117,8 +146,28 @@ func main() {
 		port = strconv.Itoa(*common.Port)
 	}
 	logger.SysLogf("server started on http://124.156.136.20:%s", port)
-	err = server.Run("0.0.0.0:" + port)
-	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+	srv := &http.Server{
+		Addr:    "0.0.0.0:" + port,
+		Handler: server,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	// flush the pending logs and usage on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	logger.SysLog("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.SysError("failed to shutdown HTTP server: " + err.Error())
 	}
+	billing.Wait()
+	model.StopLogWriter()
+	model.FlushUsageRollups()
 }
@@ -6,7 +6,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/blacklist"
 	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/network"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
 	"strings"
@@ -90,64 +92,103 @@ func RootAuth() func(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		ctx := c.Request.Context()
-		key := c.Request.Header.Get("Authorization")
-		key = strings.TrimPrefix(key, "Bearer ")
-		key = strings.TrimPrefix(key, "sk-")
-		parts := strings.Split(key, "-")
-		key = parts[0]
-		token, err := model.ValidateUserToken(key)
-		if err != nil {
-			abortWithMessage(c, http.StatusUnauthorized, err.Error())
-			return
-		}
-		if token.Subnet != nil && *token.Subnet != "" {
-			if !network.IsIpInSubnets(ctx, c.ClientIP(), *token.Subnet) {
-				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌只能在指定网段使用：%s，当前 ip：%s", *token.Subnet, c.ClientIP()))
-				return
-			}
-		}
-		userEnabled, err := model.CacheIsUserEnabled(token.UserId)
-		if err != nil {
-			abortWithMessage(c, http.StatusInternalServerError, err.Error())
-			return
+		// the following handlers are not part of the span of auth
+		if authenticateToken(c) {
+			c.Next()
 		}
-		if !userEnabled || blacklist.IsUserBanned(token.UserId) {
-			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
-			return
-		}
-		requestModel, err := getRequestModel(c)
-		if err != nil && shouldCheckModel(c) {
-			abortWithMessage(c, http.StatusBadRequest, err.Error())
-			return
-		}
-		c.Set(ctxkey.RequestModel, requestModel)
-		if token.Models != nil && *token.Models != "" {
-			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
-				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
-				return
-			}
+	}
+}
+
+// authenticateToken sets the token and the user in the context, or aborts the request
+func authenticateToken(c *gin.Context) bool {
+	ctx := c.Request.Context()
+	_, span := tracing.Start(ctx, "TokenAuth")
+	defer span.End()
+	key := c.Request.Header.Get("Authorization")
+	key = strings.TrimPrefix(key, "Bearer ")
+	key = strings.TrimPrefix(key, "sk-")
+	var token *model.Token
+	var ephemeralClaims *model.EphemeralTokenClaims
+	var err error
+	parts := []string{key}
+	if model.IsEphemeralTokenKey(key) {
+		token, ephemeralClaims, err = model.ValidateEphemeralToken(key)
+	} else {
+		parts = strings.Split(key, "-")
+		key = parts[0]
+		token, err = model.ValidateUserToken(key)
+	}
+	if err != nil {
+		abortWithMessage(c, http.StatusUnauthorized, err.Error())
+		return false
+	}
+	if ephemeralClaims != nil && ephemeralClaims.Origin != "" && c.Request.Header.Get("Origin") != ephemeralClaims.Origin {
+		abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌只能在指定来源使用：%s", ephemeralClaims.Origin))
+		return false
+	}
+	if token.Subnet != nil && *token.Subnet != "" {
+		if !network.IsIpInSubnets(ctx, c.ClientIP(), *token.Subnet) {
+			abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌只能在指定网段使用：%s，当前 ip：%s", *token.Subnet, c.ClientIP()))
+			return false
 		}
-		c.Set(ctxkey.Id, token.UserId)
-		c.Set(ctxkey.TokenId, token.Id)
-		c.Set(ctxkey.TokenName, token.Name)
-		if len(parts) > 1 {
-			if model.IsAdmin(token.UserId) {
-				c.Set(ctxkey.SpecificChannelId, parts[1])
-			} else {
-				abortWithMessage(c, http.StatusForbidden, "普通用户不支持指定渠道")
-				return
-			}
+	}
+	if !token.IsRequestSourceAllowed(c.Request.Header.Get("Origin"), c.Request.Referer()) {
+		abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌不允许从当前来源使用：%s", c.Request.Header.Get("Origin")))
+		return false
+	}
+	userEnabled, err := model.CacheIsUserEnabled(token.UserId)
+	if err != nil {
+		abortWithMessage(c, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !userEnabled || blacklist.IsUserBanned(token.UserId) {
+		abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
+		return false
+	}
+	requestModel, err := getRequestModel(c)
+	if err != nil && shouldCheckModel(c) {
+		abortWithMessage(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+	c.Set(ctxkey.RequestModel, requestModel)
+	allowedModels := token.GetModels()
+	if ephemeralClaims != nil && ephemeralClaims.Models != "" {
+		allowedModels = ephemeralClaims.Models
+	}
+	if allowedModels != "" {
+		c.Set(ctxkey.AvailableModels, allowedModels)
+		if requestModel != "" && !isModelInList(requestModel, allowedModels) {
+			abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
+			return false
 		}
-
-		// set channel id for proxy relay
-		if channelId := c.Param("channelid"); channelId != "" {
-			c.Set(ctxkey.SpecificChannelId, channelId)
+	}
+	c.Set(ctxkey.Id, token.UserId)
+	c.Set(ctxkey.TokenId, token.Id)
+	c.Set(ctxkey.TokenName, token.Name)
+	c.Set(ctxkey.TokenUnlimited, token.UnlimitedQuota)
+	if ephemeralClaims != nil {
+		c.Set(ctxkey.EphemeralTokenId, ephemeralClaims.Id)
+		c.Set(ctxkey.EphemeralTokenExpiresAt, ephemeralClaims.ExpiresAt)
+		c.Set(ctxkey.EphemeralTokenQuota, ephemeralClaims.Quota)
+	}
+	if len(parts) > 1 {
+		if model.IsAdmin(token.UserId) {
+			c.Set(ctxkey.SpecificChannelId, parts[1])
+		} else {
+			abortWithMessage(c, http.StatusForbidden, "普通用户不支持指定渠道")
+			return false
 		}
+	}
 
-		c.Next()
+	// set channel id for proxy relay
+	if channelId := c.Param("channelid"); channelId != "" {
+		c.Set(ctxkey.SpecificChannelId, channelId)
 	}
+
+	logger.SetField(ctx, "user_id", token.UserId)
+	logger.SetField(ctx, "token_id", token.Id)
+	span.SetAttributes(tracing.AttrUserId.Int(token.UserId), tracing.AttrTokenId.Int(token.Id), tracing.AttrModel.String(requestModel))
+	return true
 }
 
 func shouldCheckModel(c *gin.Context) bool {
@@ -165,3 +206,61 @@ func shouldCheckModel(c *gin.Context) bool {
 	}
 	return false
 }
+
+// TokenOrUserAuth accepts an API token ("Bearer sk-...") as well as a user session or access token,
+// the id of the API token is set in the context when it's used.
+func TokenOrUserAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if !strings.HasPrefix(key, "sk-") {
+			authHelper(c, model.RoleCommonUser)
+			return
+		}
+		key = strings.TrimPrefix(key, "sk-")
+		if model.IsEphemeralTokenKey(key) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "临时令牌无权进行此操作",
+			})
+			c.Abort()
+			return
+		}
+		token, err := model.ValidateUserToken(key)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			c.Abort()
+			return
+		}
+		if token.Subnet != nil && *token.Subnet != "" && !network.IsIpInSubnets(c.Request.Context(), c.ClientIP(), *token.Subnet) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("该令牌只能在指定网段使用：%s，当前 ip：%s", *token.Subnet, c.ClientIP()),
+			})
+			c.Abort()
+			return
+		}
+		if !token.IsRequestSourceAllowed(c.Request.Header.Get("Origin"), c.Request.Referer()) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("该令牌不允许从当前来源使用：%s", c.Request.Header.Get("Origin")),
+			})
+			c.Abort()
+			return
+		}
+		userEnabled, err := model.CacheIsUserEnabled(token.UserId)
+		if err != nil || !userEnabled || blacklist.IsUserBanned(token.UserId) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "用户已被封禁",
+			})
+			c.Abort()
+			return
+		}
+		c.Set(ctxkey.Id, token.UserId)
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Next()
+	}
+}
@@ -3,6 +3,8 @@ package middleware
 import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
 )
 
 func CORS() gin.HandlerFunc {
@@ -13,3 +15,16 @@ func CORS() gin.HandlerFunc {
 	config.AllowHeaders = []string{"*"}
 	return cors.New(config)
 }
+
+// RelayCORS reflects every origin in preflight responses, or only the origins allowed by some token
+// when unlisted origins are not allowed.
+func RelayCORS() gin.HandlerFunc {
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOriginFunc = func(origin string) bool {
+		return config.CORSAllowUnlistedOrigins || model.CacheIsOriginAllowedByAnyToken(origin)
+	}
+	corsConfig.AllowCredentials = true
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"*"}
+	return cors.New(corsConfig)
+}
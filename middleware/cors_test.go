@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func preflight(router *gin.Engine, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodOptions, "/v1/chat/completions", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRelayCORS(t *testing.T) {
+	Convey("TestRelayCORS", t, func() {
+		model.UseTestDB(t, &model.Token{})
+		allowedOrigins := "https://app.example.com"
+		token := &model.Token{UserId: 1, Status: model.TokenStatusEnabled, AllowedOrigins: &allowedOrigins}
+		token.SetKey("corstoken1234567")
+		So(model.DB.Create(token).Error, ShouldBeNil)
+
+		router := gin.New()
+		router.Use(RelayCORS())
+		router.POST("/v1/chat/completions", func(c *gin.Context) {})
+
+		So(config.CORSAllowUnlistedOrigins, ShouldBeTrue)
+		w := preflight(router, "https://evil.example.org")
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://evil.example.org")
+
+		config.CORSAllowUnlistedOrigins = false
+		defer func() { config.CORSAllowUnlistedOrigins = true }()
+		w = preflight(router, "https://app.example.com")
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://app.example.com")
+
+		w = preflight(router, "https://evil.example.org")
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldBeEmpty)
+		So(w.Code, ShouldEqual, http.StatusForbidden)
+
+		// the origin of an ephemeral token is allowed while the token is valid
+		previousSecret := config.EphemeralTokenSecret
+		config.EphemeralTokenSecret = "secret"
+		defer func() { config.EphemeralTokenSecret = previousSecret }()
+		token.ExpiredTime = -1
+		_, _, err := model.MintEphemeralToken(token, 60, 0, "", "https://widget.example.net")
+		So(err, ShouldBeNil)
+		w = preflight(router, "https://widget.example.net")
+		So(w.Header().Get("Access-Control-Allow-Origin"), ShouldEqual, "https://widget.example.net")
+	})
+}
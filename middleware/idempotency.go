@@ -0,0 +1,397 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyMaxKeyLength   = 255
+	idempotencyPollInterval   = 200 * time.Millisecond
+	idempotencyInFlightMaxTTL = 10 * time.Minute
+)
+
+// idempotencyRecord is stored for an Idempotency-Key, it is in flight until the first request completes
+type idempotencyRecord struct {
+	BodyHash    string `json:"body_hash"`
+	Completed   bool   `json:"completed"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type idempotencyEntry struct {
+	record    idempotencyRecord
+	expiresAt time.Time
+}
+
+var errIdempotencyStoreFull = errors.New("idempotency store is full")
+
+// inMemoryIdempotencyStore is used when Redis is disabled, the keys are then only deduplicated on this node.
+// It holds at most IdempotencyMemoryMaxEntries keys and IdempotencyMemoryMaxSize bytes of responses.
+type inMemoryIdempotencyStore struct {
+	store map[string]*idempotencyEntry
+	size  int // bytes of the stored responses
+	mutex sync.Mutex
+	once  sync.Once
+}
+
+var idempotencyStore inMemoryIdempotencyStore
+
+func (s *inMemoryIdempotencyStore) init() {
+	s.once.Do(func() {
+		s.store = make(map[string]*idempotencyEntry)
+		go s.clearExpiredItems()
+	})
+}
+
+func (s *inMemoryIdempotencyStore) clearExpiredItems() {
+	for {
+		time.Sleep(time.Minute)
+		s.mutex.Lock()
+		s.removeExpired()
+		s.mutex.Unlock()
+	}
+}
+
+// removeExpired, remove, put and evict must be called with the mutex held
+func (s *inMemoryIdempotencyStore) removeExpired() {
+	now := time.Now()
+	for key, entry := range s.store {
+		if now.After(entry.expiresAt) {
+			s.remove(key)
+		}
+	}
+}
+
+func (s *inMemoryIdempotencyStore) remove(key string) {
+	if entry, ok := s.store[key]; ok {
+		s.size -= len(entry.record.Body)
+		delete(s.store, key)
+	}
+}
+
+func (s *inMemoryIdempotencyStore) put(key string, record *idempotencyRecord, ttl time.Duration) {
+	s.remove(key)
+	s.store[key] = &idempotencyEntry{record: *record, expiresAt: time.Now().Add(ttl)}
+	s.size += len(record.Body)
+}
+
+// evict makes room for a record of size bytes, the completed records closest to expiring go first since
+// the in-flight ones guard requests being processed. It reports whether there is room.
+func (s *inMemoryIdempotencyStore) evict(size int) bool {
+	full := func() bool {
+		return len(s.store)+1 > config.IdempotencyMemoryMaxEntries || s.size+size > config.IdempotencyMemoryMaxSize
+	}
+	if !full() {
+		return true
+	}
+	s.removeExpired()
+	if !full() {
+		return true
+	}
+	var completed []string
+	for key, entry := range s.store {
+		if entry.record.Completed {
+			completed = append(completed, key)
+		}
+	}
+	sort.Slice(completed, func(i, j int) bool {
+		return s.store[completed[i]].expiresAt.Before(s.store[completed[j]].expiresAt)
+	})
+	for _, key := range completed {
+		if !full() {
+			break
+		}
+		s.remove(key)
+	}
+	return !full()
+}
+
+func (s *inMemoryIdempotencyStore) claim(key string, record *idempotencyRecord, ttl time.Duration) (bool, *idempotencyRecord, error) {
+	s.init()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, ok := s.store[key]; ok && time.Now().Before(entry.expiresAt) {
+		existing := entry.record
+		return false, &existing, nil
+	}
+	s.remove(key)
+	if !s.evict(len(record.Body)) {
+		return false, nil, errIdempotencyStoreFull
+	}
+	s.put(key, record, ttl)
+	return true, nil, nil
+}
+
+func (s *inMemoryIdempotencyStore) set(key string, record *idempotencyRecord, ttl time.Duration) error {
+	s.init()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// the in-flight record of the key gives its room to the response
+	s.remove(key)
+	if !s.evict(len(record.Body)) {
+		return errIdempotencyStoreFull
+	}
+	s.put(key, record, ttl)
+	return nil
+}
+
+func (s *inMemoryIdempotencyStore) refresh(key string, ttl time.Duration) {
+	s.init()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, ok := s.store[key]; ok && !entry.record.Completed {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+}
+
+func (s *inMemoryIdempotencyStore) delete(key string) {
+	s.init()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(key)
+}
+
+// claimIdempotencyKey stores the in-flight record if the key is new, otherwise returns the existing record.
+// The existing record is nil when it expired between the attempts, the caller should claim again.
+func claimIdempotencyKey(key string, record *idempotencyRecord, ttl time.Duration) (bool, *idempotencyRecord, error) {
+	if !common.RedisEnabled {
+		return idempotencyStore.claim(key, record, ttl)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false, nil, err
+	}
+	claimed, err := common.RedisSetNX(key, string(data), ttl)
+	if err != nil || claimed {
+		return claimed, nil, err
+	}
+	value, err := common.RedisGet(key)
+	if errors.Is(err, redis.Nil) {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	existing := &idempotencyRecord{}
+	if err = json.Unmarshal([]byte(value), existing); err != nil {
+		return false, nil, err
+	}
+	return false, existing, nil
+}
+
+func setIdempotencyRecord(key string, record *idempotencyRecord, ttl time.Duration) error {
+	if !common.RedisEnabled {
+		return idempotencyStore.set(key, record, ttl)
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return common.RedisSet(key, string(data), ttl)
+}
+
+// refreshIdempotencyKey extends the in-flight record of a request still being processed
+func refreshIdempotencyKey(key string, ttl time.Duration) error {
+	if !common.RedisEnabled {
+		idempotencyStore.refresh(key, ttl)
+		return nil
+	}
+	return common.RedisExpire(key, ttl)
+}
+
+func deleteIdempotencyRecord(key string) error {
+	if !common.RedisEnabled {
+		idempotencyStore.delete(key)
+		return nil
+	}
+	return common.RedisDel(key)
+}
+
+// idempotencyWriter captures the response of the first request for the duplicates
+type idempotencyWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *idempotencyWriter) capture(p []byte) {
+	if w.truncated {
+		return
+	}
+	if w.body.Len()+len(p) > config.IdempotencyMaxResponseSize {
+		w.truncated = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(p)
+}
+
+func (w *idempotencyWriter) Write(p []byte) (int, error) {
+	w.capture(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *idempotencyWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func hashIdempotentRequest(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(c.Request.URL.Path))
+	hash.Write([]byte{0})
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func replayIdempotentResponse(c *gin.Context, record *idempotencyRecord) {
+	if record.ContentType != "" {
+		c.Header("Content-Type", record.ContentType)
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Status(record.StatusCode)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// Idempotency makes the POST requests with an Idempotency-Key header safe to retry:
+// the response of the first request is replayed to the duplicates within IdempotencyTTL,
+// a duplicate arriving while the first one is in flight waits for it, neither is dispatched nor billed again.
+// Only successful responses are kept, the key is released on failure so that the client can retry.
+func Idempotency() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		idempotencyKey := c.Request.Header.Get(IdempotencyKeyHeader)
+		if idempotencyKey == "" || c.Request.Method != http.MethodPost || config.IdempotencyTTL <= 0 {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		if len(idempotencyKey) > idempotencyMaxKeyLength {
+			abortWithMessage(c, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key 长度不能超过 %d", idempotencyMaxKeyLength))
+			return
+		}
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			abortWithMessage(c, http.StatusBadRequest, "读取请求体失败："+err.Error())
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		bodyHash := hashIdempotentRequest(c, requestBody)
+		key := fmt.Sprintf("idempotency:%d:%s", c.GetInt(ctxkey.TokenId), idempotencyKey)
+		ttl := time.Duration(config.IdempotencyTTL) * time.Second
+		inFlightTTL := ttl
+		if inFlightTTL > idempotencyInFlightMaxTTL {
+			// an in-flight record left by a crashed node must not block the key for the whole TTL
+			inFlightTTL = idempotencyInFlightMaxTTL
+		}
+
+		deadline := time.Now().Add(time.Duration(config.IdempotencyWaitTimeout) * time.Second)
+		for {
+			claimed, existing, err := claimIdempotencyKey(key, &idempotencyRecord{BodyHash: bodyHash}, inFlightTTL)
+			if err != nil {
+				logger.Errorf(ctx, "failed to claim idempotency key, processing the request anyway: %s", err.Error())
+				c.Next()
+				return
+			}
+			if claimed {
+				break
+			}
+			if existing == nil {
+				continue
+			}
+			if existing.BodyHash != bodyHash {
+				abortWithMessage(c, http.StatusConflict, "Idempotency-Key 已被用于不同的请求")
+				return
+			}
+			if existing.Completed {
+				logger.Infof(ctx, "replaying the response of idempotency key %s", idempotencyKey)
+				replayIdempotentResponse(c, existing)
+				return
+			}
+			if time.Now().After(deadline) {
+				abortWithMessage(c, http.StatusConflict, "使用该 Idempotency-Key 的请求仍在处理中，请稍后重试")
+				return
+			}
+			select {
+			case <-ctx.Done():
+				c.Abort()
+				return
+			case <-time.After(idempotencyPollInterval):
+			}
+		}
+
+		// the in-flight record is refreshed until the request completes, so that a long stream is not dispatched
+		// again, while the record of a crashed node still expires after inFlightTTL
+		stopRefresh := make(chan struct{})
+		refreshDone := make(chan struct{})
+		go func() {
+			defer close(refreshDone)
+			ticker := time.NewTicker(inFlightTTL / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stopRefresh:
+					return
+				case <-ticker.C:
+					if err := refreshIdempotencyKey(key, inFlightTTL); err != nil {
+						logger.Errorf(ctx, "failed to refresh idempotency key: %s", err.Error())
+					}
+				}
+			}
+		}()
+
+		writer := &idempotencyWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+			// stopped before the record is completed or released, the refresh must not extend either
+			close(stopRefresh)
+			<-refreshDone
+			panicked := recover()
+			statusCode := writer.Status()
+			if panicked != nil || !writer.Written() || statusCode < 200 || statusCode >= 300 || writer.truncated {
+				if err := deleteIdempotencyRecord(key); err != nil {
+					logger.Errorf(ctx, "failed to release idempotency key: %s", err.Error())
+				}
+				if panicked != nil {
+					panic(panicked)
+				}
+				return
+			}
+			err := setIdempotencyRecord(key, &idempotencyRecord{
+				BodyHash:    bodyHash,
+				Completed:   true,
+				StatusCode:  statusCode,
+				ContentType: writer.Header().Get("Content-Type"),
+				Body:        writer.body.Bytes(),
+			}, ttl)
+			if err != nil {
+				logger.Errorf(ctx, "failed to store idempotent response: %s", err.Error())
+			}
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+)
+
+func resetIdempotencyStore() {
+	idempotencyStore.init()
+	idempotencyStore.mutex.Lock()
+	defer idempotencyStore.mutex.Unlock()
+	idempotencyStore.store = make(map[string]*idempotencyEntry)
+	idempotencyStore.size = 0
+}
+
+func TestIdempotency(t *testing.T) {
+	Convey("TestIdempotency", t, func() {
+		previousRedisEnabled, previousWaitTimeout := common.RedisEnabled, config.IdempotencyWaitTimeout
+		previousMaxEntries, previousMaxSize := config.IdempotencyMemoryMaxEntries, config.IdempotencyMemoryMaxSize
+		common.RedisEnabled = false
+		defer func() {
+			common.RedisEnabled, config.IdempotencyWaitTimeout = previousRedisEnabled, previousWaitTimeout
+			config.IdempotencyMemoryMaxEntries, config.IdempotencyMemoryMaxSize = previousMaxEntries, previousMaxSize
+		}()
+		resetIdempotencyStore()
+
+		var calls atomic.Int32
+		statusCode := http.StatusOK
+		var started, release chan struct{}
+		router := gin.New()
+		router.POST("/v1/chat/completions", func(c *gin.Context) {
+			c.Set(ctxkey.TokenId, 1)
+		}, Idempotency(), func(c *gin.Context) {
+			n := calls.Add(1)
+			if started != nil {
+				close(started)
+				<-release
+			}
+			c.JSON(statusCode, gin.H{"call": n})
+		})
+		send := func(key string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(IdempotencyKeyHeader, key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("replays the response of a completed request", func() {
+			first := send("key-1", `{"model":"gpt-4o"}`)
+			So(first.Code, ShouldEqual, http.StatusOK)
+			second := send("key-1", `{"model":"gpt-4o"}`)
+			So(second.Code, ShouldEqual, http.StatusOK)
+			So(second.Body.String(), ShouldEqual, first.Body.String())
+			So(second.Header().Get(IdempotentReplayedHeader), ShouldEqual, "true")
+			So(calls.Load(), ShouldEqual, 1)
+		})
+
+		Convey("rejects a key reused with another body", func() {
+			send("key-1", `{"model":"gpt-4o"}`)
+			w := send("key-1", `{"model":"gpt-4o-mini"}`)
+			So(w.Code, ShouldEqual, http.StatusConflict)
+			So(calls.Load(), ShouldEqual, 1)
+		})
+
+		Convey("makes a duplicate wait for the request in flight", func() {
+			config.IdempotencyWaitTimeout = 10
+			started, release = make(chan struct{}), make(chan struct{})
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- send("key-1", `{"model":"gpt-4o"}`) }()
+			<-started
+			go func() { done <- send("key-1", `{"model":"gpt-4o"}`) }()
+			time.Sleep(2 * idempotencyPollInterval)
+			close(release)
+			first, second := <-done, <-done
+			So(first.Body.String(), ShouldEqual, second.Body.String())
+			So(calls.Load(), ShouldEqual, 1)
+		})
+
+		Convey("keeps the key in flight while a request outlives the in-flight TTL", func() {
+			previousTTL := config.IdempotencyTTL
+			config.IdempotencyTTL = 1
+			defer func() { config.IdempotencyTTL = previousTTL }()
+			config.IdempotencyWaitTimeout = 10
+			started, release = make(chan struct{}), make(chan struct{})
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- send("key-1", `{"model":"gpt-4o"}`) }()
+			<-started
+			time.Sleep(1500 * time.Millisecond)
+			go func() { done <- send("key-1", `{"model":"gpt-4o"}`) }()
+			time.Sleep(2 * idempotencyPollInterval)
+			close(release)
+			first, second := <-done, <-done
+			So(first.Body.String(), ShouldEqual, second.Body.String())
+			So(calls.Load(), ShouldEqual, 1)
+		})
+
+		Convey("gives up waiting after the timeout", func() {
+			config.IdempotencyWaitTimeout = 0
+			started, release = make(chan struct{}), make(chan struct{})
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- send("key-1", `{"model":"gpt-4o"}`) }()
+			<-started
+			So(send("key-1", `{"model":"gpt-4o"}`).Code, ShouldEqual, http.StatusConflict)
+			close(release)
+			So((<-done).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("releases the key of a failed request", func() {
+			statusCode = http.StatusInternalServerError
+			So(send("key-1", `{"model":"gpt-4o"}`).Code, ShouldEqual, http.StatusInternalServerError)
+			statusCode = http.StatusOK
+			So(send("key-1", `{"model":"gpt-4o"}`).Code, ShouldEqual, http.StatusOK)
+			So(calls.Load(), ShouldEqual, 2)
+		})
+
+		Convey("evicts the responses closest to expiring beyond the number of keys", func() {
+			config.IdempotencyMemoryMaxEntries = 2
+			send("key-1", `{}`)
+			send("key-2", `{}`)
+			send("key-3", `{}`)
+			So(len(idempotencyStore.store), ShouldEqual, 2)
+			send("key-1", `{}`)
+			So(calls.Load(), ShouldEqual, 4)
+			send("key-3", `{}`)
+			So(calls.Load(), ShouldEqual, 4)
+		})
+
+		Convey("evicts the responses beyond the total size", func() {
+			config.IdempotencyMemoryMaxSize = 15
+			send("key-1", `{}`)
+			send("key-2", `{}`)
+			So(idempotencyStore.size, ShouldBeLessThanOrEqualTo, 15)
+			So(len(idempotencyStore.store), ShouldEqual, 1)
+			send("key-1", `{}`)
+			So(calls.Load(), ShouldEqual, 3)
+		})
+
+		Convey("processes the request without deduplication when in-flight keys fill the store", func() {
+			config.IdempotencyMemoryMaxEntries = 1
+			config.IdempotencyWaitTimeout = 10
+			started, release = make(chan struct{}), make(chan struct{})
+			done := make(chan *httptest.ResponseRecorder)
+			go func() { done <- send("key-1", `{}`) }()
+			<-started
+			started = nil
+			So(send("key-2", `{}`).Code, ShouldEqual, http.StatusOK)
+			close(release)
+			So((<-done).Code, ShouldEqual, http.StatusOK)
+			So(calls.Load(), ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// MetricsAuth checks the bearer token of metrics scrapers, every scrape is refused when PROMETHEUS_TOKEN is empty.
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if config.PrometheusToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.PrometheusToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
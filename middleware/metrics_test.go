@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestMetricsAuth(t *testing.T) {
+	Convey("TestMetricsAuth", t, func() {
+		previousToken := config.PrometheusToken
+		defer func() { config.PrometheusToken = previousToken }()
+		router := gin.New()
+		router.GET("/metrics", MetricsAuth(), func(c *gin.Context) {
+			c.String(http.StatusOK, "ok")
+		})
+		scrape := func(authorization string) int {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		Convey("refuses every scrape without a configured token", func() {
+			config.PrometheusToken = ""
+			So(scrape(""), ShouldEqual, http.StatusUnauthorized)
+			So(scrape("Bearer "), ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("checks the bearer token", func() {
+			config.PrometheusToken = "secret"
+			So(scrape(""), ShouldEqual, http.StatusUnauthorized)
+			So(scrape("Bearer wrong"), ShouldEqual, http.StatusUnauthorized)
+			So(scrape("Bearer secret"), ShouldEqual, http.StatusOK)
+		})
+	})
+}
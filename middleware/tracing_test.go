@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestTracing(t *testing.T) {
+	Convey("TestTracing", t, func() {
+		ended := len(spanRecorder.Ended())
+		previousPropagator := otel.GetTextMapPropagator()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		defer otel.SetTextMapPropagator(previousPropagator)
+
+		var handlerSpan trace.SpanContext
+		router := gin.New()
+		router.POST("/v1/chat/completions", Tracing(), func(c *gin.Context) {
+			handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+			c.Status(http.StatusTeapot)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		So(handlerSpan.TraceID().String(), ShouldEqual, "4bf92f3577b34da6a3ce929d0e0e4736")
+		spans := spanRecorder.Ended()[ended:]
+		So(len(spans), ShouldEqual, 1)
+		So(spans[0].Name(), ShouldEqual, "POST /v1/chat/completions")
+		So(spans[0].Parent().SpanID().String(), ShouldEqual, "00f067aa0ba902b7")
+		found := false
+		for _, attr := range spans[0].Attributes() {
+			if attr.Key == "http.response.status_code" {
+				found = true
+				So(attr.Value.AsInt64(), ShouldEqual, http.StatusTeapot)
+			}
+		}
+		So(found, ShouldBeTrue)
+	})
+}
+
+func TestTokenAuthSpan(t *testing.T) {
+	Convey("TestTokenAuthSpan", t, func() {
+		ended := len(spanRecorder.Ended())
+		model.UseTestDB(t, &model.User{}, &model.Token{})
+		user := &model.User{Username: "alice", Password: "password", Status: model.UserStatusEnabled, AffCode: "a1", AccessToken: "t1"}
+		So(model.DB.Create(user).Error, ShouldBeNil)
+		token := &model.Token{UserId: user.Id, Status: model.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}
+		token.SetKey("spantoken1234567")
+		So(model.DB.Create(token).Error, ShouldBeNil)
+
+		endedSpans := func() []string {
+			var names []string
+			for _, span := range spanRecorder.Ended()[ended:] {
+				names = append(names, span.Name())
+			}
+			return names
+		}
+		var endedBeforeHandler []string
+		router := gin.New()
+		router.POST("/v1/chat/completions", TokenAuth(), func(c *gin.Context) {
+			endedBeforeHandler = endedSpans()
+		})
+		request := func(key string) int {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model": "gpt-4o"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer sk-"+key)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		So(request("spantoken1234567"), ShouldEqual, http.StatusOK)
+		So(endedBeforeHandler, ShouldResemble, []string{"TokenAuth"})
+
+		So(request("wrongtoken123456"), ShouldEqual, http.StatusUnauthorized)
+		So(endedSpans(), ShouldResemble, []string{"TokenAuth", "TokenAuth"})
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/utils"
 )
 
@@ -36,6 +37,9 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
 		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
 	}
+	if config.ChannelRoutingStrategy == config.ChannelRoutingCheapest {
+		return getCheapestSatisfiedChannel(channelQuery, model)
+	}
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("RANDOM()").First(&ability).Error
 	} else {
@@ -50,6 +54,26 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 	return &channel, err
 }
 
+func getCheapestSatisfiedChannel(abilityQuery *gorm.DB, model string) (*Channel, error) {
+	var channelIds []int
+	err := abilityQuery.Model(&Ability{}).Pluck("channel_id", &channelIds).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(channelIds) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var channels []*Channel
+	err = DB.Where("id in (?)", channelIds).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(channels) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return getCheapestChannel(channels, model), nil
+}
+
 func (channel *Channel) AddAbilities() error {
 	models_ := strings.Split(channel.Models, ",")
 	models_ = utils.DeDuplication(models_)
@@ -110,3 +134,20 @@ func GetGroupModels(ctx context.Context, group string) ([]string, error) {
 	sort.Strings(models)
 	return models, err
 }
+
+type ModelChannelCount struct {
+	Model    string `json:"model"`
+	Channels int    `json:"channels"`
+}
+
+// GetEnabledModelChannelCounts returns the models served by enabled channels and the number of the channels.
+func GetEnabledModelChannelCounts() ([]ModelChannelCount, error) {
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		trueVal = "true"
+	}
+	var counts []ModelChannelCount
+	err := DB.Model(&Ability{}).Select("model, count(distinct channel_id) as channels").
+		Where("enabled = " + trueVal).Group("model").Order("model").Scan(&counts).Error
+	return counts, err
+}
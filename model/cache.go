@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/network"
 	"github.com/songquanpeng/one-api/common/random"
 	"math/rand"
 	"sort"
@@ -26,18 +29,44 @@ var (
 )
 
 func CacheGetTokenByKey(key string) (*Token, error) {
-	keyCol := "`key`"
-	if common.UsingPostgreSQL {
-		keyCol = `"key"`
+	keyHash := common.TokenKey2Hash(key)
+	if !common.RedisEnabled {
+		return getTokenByKeyHash(keyHash)
 	}
 	var token Token
+	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token:%s", keyHash))
+	if err != nil {
+		token, err := getTokenByKeyHash(keyHash)
+		if err != nil {
+			return nil, err
+		}
+		if token.usingPreviousKey {
+			// not cached, so that the grace period and the last use of the old key are tracked precisely
+			return token, nil
+		}
+		jsonBytes, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		err = common.RedisSet(fmt.Sprintf("token:%s", keyHash), string(jsonBytes), time.Duration(TokenCacheSeconds)*time.Second)
+		if err != nil {
+			logger.SysError("Redis set token error: " + err.Error())
+		}
+		return token, nil
+	}
+	err = json.Unmarshal([]byte(tokenObjectString), &token)
+	return &token, err
+}
+
+// CacheGetTokenById is used to look up the parent of an ephemeral token on every request
+func CacheGetTokenById(id int) (*Token, error) {
 	if !common.RedisEnabled {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
-		return &token, err
+		return GetTokenById(id)
 	}
-	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token:%s", key))
+	var token Token
+	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token_id:%d", id))
 	if err != nil {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		token, err := GetTokenById(id)
 		if err != nil {
 			return nil, err
 		}
@@ -45,11 +74,11 @@ func CacheGetTokenByKey(key string) (*Token, error) {
 		if err != nil {
 			return nil, err
 		}
-		err = common.RedisSet(fmt.Sprintf("token:%s", key), string(jsonBytes), time.Duration(TokenCacheSeconds)*time.Second)
+		err = common.RedisSet(fmt.Sprintf("token_id:%d", id), string(jsonBytes), time.Duration(TokenCacheSeconds)*time.Second)
 		if err != nil {
 			logger.SysError("Redis set token error: " + err.Error())
 		}
-		return &token, nil
+		return token, nil
 	}
 	err = json.Unmarshal([]byte(tokenObjectString), &token)
 	return &token, err
@@ -175,6 +204,7 @@ func InitChannelCache() {
 	var channels []*Channel
 	DB.Where("status = ?", ChannelStatusEnabled).Find(&channels)
 	for _, channel := range channels {
+		channel.pricing = channel.parsePricing()
 		newChannelId2channel[channel.Id] = channel
 	}
 	var abilities []*Ability
@@ -210,6 +240,12 @@ func InitChannelCache() {
 		}
 	}
 
+	groupModels := 0
+	for _, model2channels := range newGroup2model2channels {
+		groupModels += len(model2channels)
+	}
+	metrics.SetChannelCacheSize(len(newChannelId2channel), groupModels)
+
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
 	channelSyncLock.Unlock()
@@ -245,6 +281,21 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			}
 		}
 	}
+	if config.ChannelRoutingStrategy == config.ChannelRoutingCheapest {
+		startIdx := 0
+		if ignoreFirstPriority && endIdx < len(channels) {
+			// stay within the next priority tier
+			startIdx = endIdx
+			endIdx = len(channels)
+			for i := startIdx; i < len(channels); i++ {
+				if channels[i].GetPriority() != channels[startIdx].GetPriority() {
+					endIdx = i
+					break
+				}
+			}
+		}
+		return getCheapestChannel(channels[startIdx:endIdx], model), nil
+	}
 	idx := rand.Intn(endIdx)
 	if ignoreFirstPriority {
 		if endIdx < len(channels) { // which means there are more than one priority
@@ -253,3 +304,80 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 	}
 	return channels[idx], nil
 }
+
+// getCheapestChannel picks the channel with the lowest upstream cost of the model, ties are broken randomly.
+func getCheapestChannel(channels []*Channel, model string) *Channel {
+	var cheapest []*Channel
+	lowestRatio := 0.0
+	for _, channel := range channels {
+		ratio := channel.GetUpstreamRatio(model)
+		if len(cheapest) == 0 || ratio < lowestRatio {
+			cheapest = []*Channel{channel}
+			lowestRatio = ratio
+		} else if ratio == lowestRatio {
+			cheapest = append(cheapest, channel)
+		}
+	}
+	return cheapest[rand.Intn(len(cheapest))]
+}
+
+var tokenAllowedOrigins []string
+var tokenAllowedOriginsSyncTime int64
+var tokenAllowedOriginsLock sync.RWMutex
+
+// tokenAllowedOriginsRefreshLock is held by the only caller querying the origins
+var tokenAllowedOriginsRefreshLock sync.Mutex
+
+// CacheIsOriginAllowedByAnyToken is used for CORS preflight requests, which don't carry the token.
+// The origins of the ephemeral tokens are allowed until the tokens expire.
+func CacheIsOriginAllowedByAnyToken(origin string) bool {
+	tokenAllowedOriginsLock.RLock()
+	syncTime := tokenAllowedOriginsSyncTime
+	allowedOrigins := tokenAllowedOrigins
+	tokenAllowedOriginsLock.RUnlock()
+	if syncTime == 0 {
+		// nothing to serve yet, the concurrent callers wait for the first query
+		tokenAllowedOriginsRefreshLock.Lock()
+		allowedOrigins = loadTokenAllowedOrigins()
+		tokenAllowedOriginsRefreshLock.Unlock()
+	} else if helper.GetTimestamp()-syncTime >= int64(config.SyncFrequency) && tokenAllowedOriginsRefreshLock.TryLock() {
+		// the stale origins are served until the refresh is done
+		go func() {
+			defer tokenAllowedOriginsRefreshLock.Unlock()
+			refreshTokenAllowedOrigins()
+		}()
+	}
+	for _, patterns := range allowedOrigins {
+		if network.MatchPatterns(origin, patterns) {
+			return true
+		}
+	}
+	return isEphemeralTokenOrigin(origin)
+}
+
+// loadTokenAllowedOrigins must be called with tokenAllowedOriginsRefreshLock held, it only queries the origins
+// when no one else has done it in the meantime.
+func loadTokenAllowedOrigins() []string {
+	tokenAllowedOriginsLock.RLock()
+	syncTime := tokenAllowedOriginsSyncTime
+	allowedOrigins := tokenAllowedOrigins
+	tokenAllowedOriginsLock.RUnlock()
+	if syncTime != 0 {
+		return allowedOrigins
+	}
+	return refreshTokenAllowedOrigins()
+}
+
+// refreshTokenAllowedOrigins keeps the stale origins when the query fails, it's retried after SyncFrequency.
+func refreshTokenAllowedOrigins() []string {
+	allowedOrigins, err := GetAllTokenAllowedOrigins()
+	tokenAllowedOriginsLock.Lock()
+	defer tokenAllowedOriginsLock.Unlock()
+	if err != nil {
+		logger.SysError("failed to get token allowed origins: " + err.Error())
+	} else {
+		tokenAllowedOrigins = allowedOrigins
+	}
+	tokenAllowedOriginsSyncTime = helper.GetTimestamp()
+	return tokenAllowedOrigins
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"gorm.io/gorm"
 )
 
@@ -38,6 +39,11 @@ type Channel struct {
 	Priority           *int64  `json:"priority" gorm:"bigint;default:0"`
 	Config             string  `json:"config"`
 	SystemPrompt       *string `json:"system_prompt" gorm:"type:text"`
+	// UpstreamPrices is what the upstream charges us per model, it doesn't affect what users are charged
+	UpstreamPrices   *string  `json:"upstream_prices" gorm:"type:text"`
+	UpstreamDiscount *float64 `json:"upstream_discount" gorm:"default:1"`
+
+	pricing *channelPricing // only set for the channels of the cache
 }
 
 type ChannelConfig struct {
@@ -82,6 +88,19 @@ func GetChannelById(id int, selectAll bool) (*Channel, error) {
 	return &channel, err
 }
 
+func GetChannelNames(ids []int) (map[int]string, error) {
+	var channels []*Channel
+	err := DB.Select("id", "name").Where("id in (?)", ids).Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(channels))
+	for _, channel := range channels {
+		names[channel.Id] = channel.Name
+	}
+	return names, nil
+}
+
 func BatchInsertChannels(channels []Channel) error {
 	var err error
 	err = DB.Create(&channels).Error
@@ -111,6 +130,27 @@ func (channel *Channel) GetBaseURL() string {
 	return *channel.BaseURL
 }
 
+// channelPricing holds the model mapping and the upstream prices parsed when the channel cache is synced,
+// so the cheapest routing doesn't parse them for every request.
+type channelPricing struct {
+	modelMapping   map[string]string
+	upstreamPrices map[string]billingratio.ModelPrice
+}
+
+func (channel *Channel) parsePricing() *channelPricing {
+	return &channelPricing{
+		modelMapping:   channel.GetModelMapping(),
+		upstreamPrices: channel.GetUpstreamPrices(),
+	}
+}
+
+func (channel *Channel) getPricing() *channelPricing {
+	if channel.pricing != nil {
+		return channel.pricing
+	}
+	return channel.parsePricing()
+}
+
 func (channel *Channel) GetModelMapping() map[string]string {
 	if channel.ModelMapping == nil || *channel.ModelMapping == "" || *channel.ModelMapping == "{}" {
 		return nil
@@ -124,6 +164,50 @@ func (channel *Channel) GetModelMapping() map[string]string {
 	return modelMapping
 }
 
+func (channel *Channel) GetUpstreamPrices() map[string]billingratio.ModelPrice {
+	if channel.UpstreamPrices == nil || *channel.UpstreamPrices == "" || *channel.UpstreamPrices == "{}" {
+		return nil
+	}
+	prices, err := billingratio.ParseModelPrices(*channel.UpstreamPrices)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to parse upstream prices for channel %d, error: %s", channel.Id, err.Error()))
+		return nil
+	}
+	return prices
+}
+
+func (channel *Channel) ValidateUpstreamPrices() error {
+	if channel.UpstreamPrices == nil || *channel.UpstreamPrices == "" {
+		return nil
+	}
+	_, err := billingratio.ParseModelPrices(*channel.UpstreamPrices)
+	return err
+}
+
+// GetUpstreamDiscount returns the discount the upstream gives on its prices, 1 means no discount.
+func (channel *Channel) GetUpstreamDiscount() float64 {
+	if channel.UpstreamDiscount == nil || *channel.UpstreamDiscount <= 0 {
+		return 1
+	}
+	return *channel.UpstreamDiscount
+}
+
+// GetUpstreamRatio is the blended cost of the model on the channel per prompt and completion token,
+// the channel falls back to the catalog when it has no upstream price for the model.
+func (channel *Channel) GetUpstreamRatio(modelName string) float64 {
+	pricing := channel.getPricing()
+	if mappedName, ok := pricing.modelMapping[modelName]; ok && mappedName != "" {
+		modelName = mappedName
+	}
+	modelRatio := billingratio.GetModelRatio(modelName, channel.Type)
+	completionRatio := billingratio.GetCompletionRatio(modelName, channel.Type)
+	if price, ok := pricing.upstreamPrices[modelName]; ok {
+		modelRatio = price.ModelRatio()
+		completionRatio = price.CompletionRatio()
+	}
+	return modelRatio * (1 + completionRatio) * channel.GetUpstreamDiscount()
+}
+
 func (channel *Channel) Insert() error {
 	var err error
 	err = DB.Create(channel).Error
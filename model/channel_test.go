@@ -0,0 +1,88 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func newPricedChannel(id int, priority int64, upstreamPrices string, modelMapping string) *Channel {
+	return &Channel{
+		Id:             id,
+		Name:           "channel",
+		Key:            "sk-test",
+		Status:         ChannelStatusEnabled,
+		Models:         "gpt-4o",
+		Group:          "default",
+		Priority:       &priority,
+		UpstreamPrices: &upstreamPrices,
+		ModelMapping:   &modelMapping,
+	}
+}
+
+func TestGetUpstreamRatio(t *testing.T) {
+	Convey("TestGetUpstreamRatio", t, func() {
+		channel := newPricedChannel(1, 0, `{"gpt-4o": {"input": 2, "output": 8}, "gpt-4o-2024-08-06": {"input": 1, "output": 4}}`, "")
+		discount := 0.5
+		// $2 / 1M input tokens is ratio 1, the output is 4 times the input
+		So(channel.GetUpstreamRatio("gpt-4o"), ShouldEqual, 5)
+		channel.UpstreamDiscount = &discount
+		So(channel.GetUpstreamRatio("gpt-4o"), ShouldEqual, 2.5)
+
+		Convey("prices the mapped model", func() {
+			mapping := `{"gpt-4o": "gpt-4o-2024-08-06"}`
+			channel.ModelMapping = &mapping
+			So(channel.GetUpstreamRatio("gpt-4o"), ShouldEqual, 1.25)
+		})
+	})
+}
+
+func TestCheapestChannelRouting(t *testing.T) {
+	Convey("TestCheapestChannelRouting", t, func() {
+		UseTestDB(t, &Channel{}, &Ability{})
+		previousStrategy, previousMemoryCache := config.ChannelRoutingStrategy, config.MemoryCacheEnabled
+		config.ChannelRoutingStrategy = config.ChannelRoutingCheapest
+		defer func() {
+			config.ChannelRoutingStrategy, config.MemoryCacheEnabled = previousStrategy, previousMemoryCache
+		}()
+		channels := []*Channel{
+			newPricedChannel(1, 10, `{"gpt-4o": {"input": 3, "output": 12}}`, ""),
+			newPricedChannel(2, 10, `{"gpt-4o": {"input": 2, "output": 8}}`, ""),
+			newPricedChannel(3, 10, `{"gpt-4o": {"input": 4, "output": 16}}`, ""),
+			newPricedChannel(4, 0, `{"gpt-4o": {"input": 1, "output": 4}}`, ""),
+		}
+		for _, channel := range channels {
+			So(channel.Insert(), ShouldBeNil)
+		}
+		So(DB.Model(&Channel{}).Where("id = ?", 3).Update("status", ChannelStatusManuallyDisabled).Error, ShouldBeNil)
+		So(DB.Model(&Ability{}).Where("channel_id = ?", 3).Update("enabled", false).Error, ShouldBeNil)
+
+		Convey("picks the cheapest enabled channel of the priority from the database", func() {
+			config.MemoryCacheEnabled = false
+			channel, err := CacheGetRandomSatisfiedChannel("default", "gpt-4o", false)
+			So(err, ShouldBeNil)
+			So(channel.Id, ShouldEqual, 2)
+		})
+
+		Convey("picks the cheapest channel of the cache by the prices parsed at sync", func() {
+			config.MemoryCacheEnabled = true
+			InitChannelCache()
+			cheapest, err := CacheGetRandomSatisfiedChannel("default", "gpt-4o", false)
+			So(err, ShouldBeNil)
+			So(cheapest.Id, ShouldEqual, 2)
+			So(cheapest.pricing, ShouldNotBeNil)
+
+			channel, err := CacheGetRandomSatisfiedChannel("default", "gpt-4o", true)
+			So(err, ShouldBeNil)
+			So(channel.Id, ShouldEqual, 4)
+
+			// the prices are not parsed again until the next sync
+			prices := `{"gpt-4o": {"input": 100, "output": 400}}`
+			cheapest.UpstreamPrices = &prices
+			channel, _ = CacheGetRandomSatisfiedChannel("default", "gpt-4o", false)
+			So(channel.Id, ShouldEqual, 2)
+		})
+	})
+}
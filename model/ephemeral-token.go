@@ -0,0 +1,247 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// EphemeralTokenPrefix marks a key as an ephemeral token, it follows the usual "sk-" prefix.
+const EphemeralTokenPrefix = "eph."
+
+// EphemeralTokenClaims is the signed payload of an ephemeral token.
+// Ephemeral tokens are never stored, their spend is billed to the parent token.
+type EphemeralTokenClaims struct {
+	ParentTokenId int    `json:"pid"`
+	UserId        int    `json:"uid"`
+	Quota         int64  `json:"quota,omitempty"` // 0 means only limited by the parent token
+	Models        string `json:"models,omitempty"`
+	Origin        string `json:"origin,omitempty"`
+	jwt.StandardClaims
+}
+
+var errEphemeralTokenSecretNotSet = errors.New("未设置 EPHEMERAL_TOKEN_SECRET 或 SESSION_SECRET，无法签发临时令牌")
+
+// ephemeralTokenSecret is set from EPHEMERAL_TOKEN_SECRET or SESSION_SECRET, never from the random session secret
+// of this process, which would invalidate the tokens on restart and on the other nodes.
+func ephemeralTokenSecret() ([]byte, error) {
+	if config.EphemeralTokenSecret == "" {
+		return nil, errEphemeralTokenSecretNotSet
+	}
+	return []byte(config.EphemeralTokenSecret), nil
+}
+
+func IsEphemeralTokenKey(key string) bool {
+	return strings.HasPrefix(key, EphemeralTokenPrefix)
+}
+
+// MintEphemeralToken signs a short-lived child token of parent, the returned key doesn't contain the "sk-" prefix.
+func MintEphemeralToken(parent *Token, ttl int64, quota int64, models string, origin string) (string, *EphemeralTokenClaims, error) {
+	secret, err := ephemeralTokenSecret()
+	if err != nil {
+		return "", nil, err
+	}
+	now := helper.GetTimestamp()
+	claims := &EphemeralTokenClaims{
+		ParentTokenId: parent.Id,
+		UserId:        parent.UserId,
+		Quota:         quota,
+		Models:        models,
+		Origin:        origin,
+		StandardClaims: jwt.StandardClaims{
+			Id:        random.GetUUID(),
+			IssuedAt:  now,
+			ExpiresAt: now + ttl,
+		},
+	}
+	if parent.ExpiredTime != -1 && parent.ExpiredTime < claims.ExpiresAt {
+		claims.ExpiresAt = parent.ExpiredTime
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	if origin != "" {
+		if err = rememberEphemeralTokenOrigin(origin, claims.ExpiresAt); err != nil {
+			return "", nil, err
+		}
+	}
+	return EphemeralTokenPrefix + signed, claims, nil
+}
+
+// ValidateEphemeralToken verifies an ephemeral token and its parent, returning the parent token used for billing.
+func ValidateEphemeralToken(key string) (*Token, *EphemeralTokenClaims, error) {
+	claims := &EphemeralTokenClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(key, EphemeralTokenPrefix), claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		return ephemeralTokenSecret()
+	})
+	if err != nil {
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, nil, errors.New("该令牌已过期")
+		}
+		return nil, nil, errors.New("无效的令牌")
+	}
+	if claims.Quota > 0 && !common.RedisEnabled && !config.IsMasterNode {
+		// without Redis the spend is only known by the node that served the request
+		return nil, nil, errors.New("未启用 Redis 的多节点部署不支持限制额度的临时令牌")
+	}
+	parent, err := CacheGetTokenById(claims.ParentTokenId)
+	if err != nil || parent.UserId != claims.UserId {
+		return nil, nil, errors.New("无效的令牌")
+	}
+	if err = validateTokenStatus(parent); err != nil {
+		return nil, nil, err
+	}
+	if claims.Quota > 0 && GetEphemeralTokenUsedQuota(claims.Id) >= claims.Quota {
+		return nil, nil, errors.New("该令牌额度已用尽")
+	}
+	return parent, claims, nil
+}
+
+// EphemeralTokenLimit is the ephemeral token authorizing a request, the estimated quota of the request is
+// reserved against its Quota along with the parent token.
+type EphemeralTokenLimit struct {
+	Id        string
+	ExpiresAt int64
+	Quota     int64 // 0 means only limited by the parent token
+}
+
+// ephemeralTokenOrigins maps the origins of the ephemeral tokens to the latest expiration, for relay CORS.
+// Without Redis only the node minting a token knows its origin.
+var ephemeralTokenOrigins = make(map[string]int64)
+var ephemeralTokenOriginsLock sync.Mutex
+
+func ephemeralTokenOriginKey(origin string) string {
+	return fmt.Sprintf("ephemeral_token_origin:%s", origin)
+}
+
+func rememberEphemeralTokenOrigin(origin string, expiresAt int64) error {
+	now := helper.GetTimestamp()
+	if common.RedisEnabled {
+		key := ephemeralTokenOriginKey(origin)
+		if value, err := common.RedisGet(key); err == nil {
+			if latest, _ := strconv.ParseInt(value, 10, 64); latest >= expiresAt {
+				return nil
+			}
+		}
+		return common.RedisSet(key, strconv.FormatInt(expiresAt, 10), time.Duration(expiresAt-now)*time.Second)
+	}
+	ephemeralTokenOriginsLock.Lock()
+	defer ephemeralTokenOriginsLock.Unlock()
+	for key, latest := range ephemeralTokenOrigins {
+		if latest < now {
+			delete(ephemeralTokenOrigins, key)
+		}
+	}
+	if ephemeralTokenOrigins[origin] < expiresAt {
+		ephemeralTokenOrigins[origin] = expiresAt
+	}
+	return nil
+}
+
+// isEphemeralTokenOrigin tells whether an unexpired ephemeral token is restricted to origin
+func isEphemeralTokenOrigin(origin string) bool {
+	if common.RedisEnabled {
+		_, err := common.RedisGet(ephemeralTokenOriginKey(origin))
+		return err == nil
+	}
+	ephemeralTokenOriginsLock.Lock()
+	defer ephemeralTokenOriginsLock.Unlock()
+	return ephemeralTokenOrigins[origin] >= helper.GetTimestamp()
+}
+
+type ephemeralTokenUsage struct {
+	usedQuota int64
+	expiresAt int64
+}
+
+var ephemeralTokenUsages = make(map[string]*ephemeralTokenUsage)
+var ephemeralTokenUsagesLock sync.Mutex
+
+func ephemeralTokenUsageKey(id string) string {
+	return fmt.Sprintf("ephemeral_token_used:%s", id)
+}
+
+// GetEphemeralTokenUsedQuota returns the spend of an ephemeral token. Without Redis it is kept in the memory
+// of this process, so the quota limited ephemeral tokens are refused by the slave nodes of such deployments.
+func GetEphemeralTokenUsedQuota(id string) int64 {
+	if common.RedisEnabled {
+		used, err := common.RedisGet(ephemeralTokenUsageKey(id))
+		if err != nil {
+			return 0
+		}
+		usedQuota, _ := strconv.ParseInt(used, 10, 64)
+		return usedQuota
+	}
+	ephemeralTokenUsagesLock.Lock()
+	defer ephemeralTokenUsagesLock.Unlock()
+	if usage, ok := ephemeralTokenUsages[id]; ok {
+		return usage.usedQuota
+	}
+	return 0
+}
+
+// IncreaseEphemeralTokenUsedQuota records spend of an ephemeral token until it expires, a negative quota gives it back.
+func IncreaseEphemeralTokenUsedQuota(id string, expiresAt int64, quota int64) error {
+	return increaseEphemeralTokenUsedQuota(id, expiresAt, quota, 0)
+}
+
+// increaseEphemeralTokenUsedQuota fails without changing anything when a positive quota would take the spend
+// above limit, a zero limit means no limit.
+func increaseEphemeralTokenUsedQuota(id string, expiresAt int64, quota int64, limit int64) error {
+	if id == "" || quota == 0 {
+		return nil
+	}
+	ttl := time.Duration(expiresAt-helper.GetTimestamp()) * time.Second
+	if ttl <= 0 {
+		return nil
+	}
+	overLimit := func(usedQuota int64) bool {
+		return limit > 0 && quota > 0 && usedQuota > limit
+	}
+	if common.RedisEnabled {
+		usedQuota, err := common.RedisIncrease(ephemeralTokenUsageKey(id), quota)
+		if err != nil {
+			return err
+		}
+		if overLimit(usedQuota) {
+			if _, err = common.RedisIncrease(ephemeralTokenUsageKey(id), -quota); err != nil {
+				return err
+			}
+			return ErrInsufficientEphemeralTokenQuota
+		}
+		return common.RedisExpire(ephemeralTokenUsageKey(id), ttl)
+	}
+	now := helper.GetTimestamp()
+	ephemeralTokenUsagesLock.Lock()
+	defer ephemeralTokenUsagesLock.Unlock()
+	for key, usage := range ephemeralTokenUsages {
+		if usage.expiresAt < now {
+			delete(ephemeralTokenUsages, key)
+		}
+	}
+	usage, ok := ephemeralTokenUsages[id]
+	if !ok {
+		usage = &ephemeralTokenUsage{expiresAt: expiresAt}
+		ephemeralTokenUsages[id] = usage
+	}
+	if overLimit(usage.usedQuota + quota) {
+		return ErrInsufficientEphemeralTokenQuota
+	}
+	usage.usedQuota += quota
+	return nil
+}
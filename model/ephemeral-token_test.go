@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestEphemeralToken(t *testing.T) {
+	Convey("TestEphemeralToken", t, func() {
+		UseTestDB(t, &Token{})
+		previousSecret := config.EphemeralTokenSecret
+		config.EphemeralTokenSecret = "secret"
+		defer func() { config.EphemeralTokenSecret = previousSecret }()
+		parent := &Token{UserId: 1, Name: "parent", Status: TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}
+		parent.SetKey("parentkey12345678")
+		So(DB.Create(parent).Error, ShouldBeNil)
+
+		Convey("a minted token is billed to its parent", func() {
+			key, claims, err := MintEphemeralToken(parent, 60, 0, "gpt-4o", "")
+			So(err, ShouldBeNil)
+			So(IsEphemeralTokenKey(key), ShouldBeTrue)
+			token, validated, err := ValidateEphemeralToken(key)
+			So(err, ShouldBeNil)
+			So(token.Id, ShouldEqual, parent.Id)
+			So(validated.Id, ShouldEqual, claims.Id)
+			So(validated.Models, ShouldEqual, "gpt-4o")
+		})
+
+		Convey("refuses to mint without a configured secret", func() {
+			config.EphemeralTokenSecret = ""
+			_, _, err := MintEphemeralToken(parent, 60, 0, "", "")
+			So(err, ShouldEqual, errEphemeralTokenSecretNotSet)
+		})
+
+		Convey("the expiration is capped by the parent", func() {
+			parent.ExpiredTime = helper.GetTimestamp() + 10
+			_, claims, err := MintEphemeralToken(parent, 3600, 0, "", "")
+			So(err, ShouldBeNil)
+			So(claims.ExpiresAt, ShouldEqual, parent.ExpiredTime)
+		})
+
+		Convey("tampered and foreign tokens are rejected", func() {
+			key, _, err := MintEphemeralToken(parent, 60, 0, "", "")
+			So(err, ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key + "x")
+			So(err, ShouldNotBeNil)
+
+			foreign := *parent
+			foreign.UserId = 2
+			key, _, err = MintEphemeralToken(&foreign, 60, 0, "", "")
+			So(err, ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("a disabled parent disables its children", func() {
+			key, _, err := MintEphemeralToken(parent, 60, 0, "", "")
+			So(err, ShouldBeNil)
+			So(DB.Model(parent).Update("status", TokenStatusDisabled).Error, ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("the quota of the token is enforced", func() {
+			key, claims, err := MintEphemeralToken(parent, 60, 100, "", "")
+			So(err, ShouldBeNil)
+			So(IncreaseEphemeralTokenUsedQuota(claims.Id, claims.ExpiresAt, 60), ShouldBeNil)
+			So(GetEphemeralTokenUsedQuota(claims.Id), ShouldEqual, 60)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldBeNil)
+			So(IncreaseEphemeralTokenUsedQuota(claims.Id, claims.ExpiresAt, 40), ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("quota limited tokens are refused by slave nodes without Redis", func() {
+			previousIsMasterNode := config.IsMasterNode
+			config.IsMasterNode = false
+			defer func() { config.IsMasterNode = previousIsMasterNode }()
+			key, _, err := MintEphemeralToken(parent, 60, 100, "", "")
+			So(err, ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldNotBeNil)
+			key, _, err = MintEphemeralToken(parent, 60, 0, "", "")
+			So(err, ShouldBeNil)
+			_, _, err = ValidateEphemeralToken(key)
+			So(err, ShouldBeNil)
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"gorm.io/gorm"
+)
+
+const (
+	LedgerTypeTopup        = "topup"
+	LedgerTypeConsume      = "consume"
+	LedgerTypeRefund       = "refund"
+	LedgerTypeRedemption   = "redemption"
+	LedgerTypeAdminAdjust  = "admin_adjust"
+	LedgerTypeInviteBonus  = "invite_bonus"
+	LedgerTypeSignupBonus  = "signup_bonus"
+	LedgerTypeSubscription = "subscription" // renewals paid from the balance
+	LedgerTypeOpening      = "opening"      // the balances from before the ledger
+)
+
+// LedgerAccountUser is the quota balance of the user, the other accounts are the system counterparts
+const LedgerAccountUser = "user"
+
+var ledgerCounterAccounts = map[string]string{
+	LedgerTypeTopup:        "topup",
+	LedgerTypeConsume:      "revenue",
+	LedgerTypeRefund:       "revenue",
+	LedgerTypeRedemption:   "redemption",
+	LedgerTypeAdminAdjust:  "adjustment",
+	LedgerTypeInviteBonus:  "bonus",
+	LedgerTypeSignupBonus:  "bonus",
+	LedgerTypeSubscription: "subscription",
+	LedgerTypeOpening:      "opening",
+}
+
+// LedgerEntry is one side of a quota transaction, the entries of a transaction sum to zero.
+// The ledger is append-only, the sum of the user account of a user equals User.Quota.
+type LedgerEntry struct {
+	Id            int    `json:"id"`
+	TransactionId string `json:"transaction_id" gorm:"type:varchar(64);index"`
+	UserId        int    `json:"user_id" gorm:"index:idx_ledger_user_account,priority:1"`
+	Account       string `json:"account" gorm:"type:varchar(32);index:idx_ledger_user_account,priority:2"`
+	Type          string `json:"type" gorm:"type:varchar(32);index"`
+	Amount        int64  `json:"amount" gorm:"bigint"` // positive for credit
+	TokenId       int    `json:"token_id" gorm:"default:0"`
+	Reference     string `json:"reference" gorm:"type:varchar(128);index;default:''"` // request id, order id or redemption id
+	Remark        string `json:"remark" gorm:"default:''"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerSource tells why the quota of a user changes
+type LedgerSource struct {
+	Type      string
+	Reference string
+	TokenId   int
+	Remark    string
+}
+
+func newLedgerEntries(userId int, amount int64, source LedgerSource) []*LedgerEntry {
+	transactionId := random.GetUUID()
+	now := helper.GetTimestamp()
+	entry := func(account string, amount int64) *LedgerEntry {
+		return &LedgerEntry{
+			TransactionId: transactionId,
+			UserId:        userId,
+			Account:       account,
+			Type:          source.Type,
+			Amount:        amount,
+			TokenId:       source.TokenId,
+			Reference:     source.Reference,
+			Remark:        source.Remark,
+			CreatedAt:     now,
+		}
+	}
+	return []*LedgerEntry{
+		entry(LedgerAccountUser, amount),
+		entry(ledgerCounterAccounts[source.Type], -amount),
+	}
+}
+
+// recordLedger must be called in the transaction which changes the quota
+func recordLedger(tx *gorm.DB, userId int, amount int64, source LedgerSource) error {
+	if amount == 0 {
+		return nil
+	}
+	return tx.Create(newLedgerEntries(userId, amount, source)).Error
+}
+
+// RecordLedger is for the quota changes made by updating the user directly
+func RecordLedger(userId int, amount int64, source LedgerSource) error {
+	return recordLedger(DB, userId, amount, source)
+}
+
+var ledgerBuffer []*LedgerEntry
+var ledgerBufferLock sync.Mutex
+
+// addLedgerRecord buffers the entries of a batch updated quota change until the next batch update.
+func addLedgerRecord(userId int, amount int64, source LedgerSource) {
+	if amount == 0 {
+		return
+	}
+	ledgerBufferLock.Lock()
+	defer ledgerBufferLock.Unlock()
+	ledgerBuffer = append(ledgerBuffer, newLedgerEntries(userId, amount, source)...)
+}
+
+func flushLedgerBuffer() {
+	ledgerBufferLock.Lock()
+	entries := ledgerBuffer
+	ledgerBuffer = nil
+	ledgerBufferLock.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+	if err := DB.CreateInBatches(entries, 100).Error; err != nil {
+		logger.SysError("failed to batch insert ledger entries: " + err.Error())
+	}
+}
+
+// migrateLedger opens the ledger with the current balances the first time it is created
+func migrateLedger() error {
+	if err := DB.AutoMigrate(&LedgerEntry{}); err != nil {
+		return err
+	}
+	var count int64
+	if err := DB.Model(&LedgerEntry{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	var users []*User
+	if err := DB.Select("id", "quota").Where("quota <> 0").Find(&users).Error; err != nil {
+		return err
+	}
+	var entries []*LedgerEntry
+	for _, user := range users {
+		entries = append(entries, newLedgerEntries(user.Id, user.Quota, LedgerSource{Type: LedgerTypeOpening})...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	logger.SysLog(fmt.Sprintf("opening the quota ledger with the balances of %d users", len(users)))
+	return DB.CreateInBatches(entries, 100).Error
+}
+
+type LedgerFilter struct {
+	UserId         int
+	Type           string
+	Reference      string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+// GetLedgerEntries returns the user account side of the transactions
+func GetLedgerEntries(filter *LedgerFilter, startIdx int, num int) ([]*LedgerEntry, error) {
+	tx := DB.Where("account = ?", LedgerAccountUser)
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Type != "" {
+		tx = tx.Where("type = ?", filter.Type)
+	}
+	if filter.Reference != "" {
+		tx = tx.Where("reference = ?", filter.Reference)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	var entries []*LedgerEntry
+	err := tx.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, err
+}
+
+type LedgerDrift struct {
+	UserId int   `json:"user_id"`
+	Quota  int64 `json:"quota"`
+	Ledger int64 `json:"ledger"`
+	Drift  int64 `json:"drift"` // quota - ledger
+}
+
+type LedgerReconciliation struct {
+	CheckedAt int64          `json:"checked_at"`
+	Users     int            `json:"users"`
+	Imbalance int64          `json:"imbalance"` // sum of all the entries, not zero when a transaction is incomplete
+	Drifts    []*LedgerDrift `json:"drifts"`
+}
+
+var lastReconciliation atomic.Pointer[LedgerReconciliation]
+
+func GetLastLedgerReconciliation() *LedgerReconciliation {
+	return lastReconciliation.Load()
+}
+
+// ReconcileLedger compares the ledger balance of every user with User.Quota.
+// The quota changes still buffered by the batch updaters of other nodes show up as drift until they are flushed.
+func ReconcileLedger() (*LedgerReconciliation, error) {
+	if config.BatchUpdateEnabled {
+		batchUpdate()
+	}
+	report := &LedgerReconciliation{
+		CheckedAt: helper.GetTimestamp(),
+		Drifts:    make([]*LedgerDrift, 0),
+	}
+	var balances []struct {
+		UserId int
+		Amount int64
+	}
+	var users []*User
+	// read from one snapshot, or the quota changed between the queries would be reported as drifts
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&LedgerEntry{}).Select("user_id, sum(amount) as amount").
+			Where("account = ?", LedgerAccountUser).Group("user_id").Scan(&balances).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Select("id", "quota").Find(&users).Error; err != nil {
+			return err
+		}
+		return tx.Model(&LedgerEntry{}).Select("coalesce(sum(amount), 0)").Scan(&report.Imbalance).Error
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	ledgerBalances := make(map[int]int64, len(balances))
+	for _, balance := range balances {
+		ledgerBalances[balance.UserId] = balance.Amount
+	}
+	report.Users = len(users)
+	for _, user := range users {
+		ledgerBalance := ledgerBalances[user.Id]
+		if ledgerBalance != user.Quota {
+			report.Drifts = append(report.Drifts, &LedgerDrift{
+				UserId: user.Id,
+				Quota:  user.Quota,
+				Ledger: ledgerBalance,
+				Drift:  user.Quota - ledgerBalance,
+			})
+		}
+	}
+	lastReconciliation.Store(report)
+	return report, nil
+}
+
+func ReconcileLedgerPeriodically(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		report, err := ReconcileLedger()
+		if err != nil {
+			logger.SysError("failed to reconcile the quota ledger: " + err.Error())
+			continue
+		}
+		if len(report.Drifts) != 0 || report.Imbalance != 0 {
+			logger.SysError(fmt.Sprintf("quota ledger drifts for %d of %d users, imbalance %d", len(report.Drifts), report.Users, report.Imbalance))
+		} else {
+			logger.SysLog("quota ledger reconciled")
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestUpdateWithLedger(t *testing.T) {
+	Convey("TestUpdateWithLedger", t, func() {
+		UseTestDB(t, &User{}, &LedgerEntry{})
+		user := &User{Username: "alice", Password: "password", Quota: 1000, Status: UserStatusEnabled, AffCode: "a1", AccessToken: "t1"}
+		So(DB.Create(user).Error, ShouldBeNil)
+		So(RecordLedger(user.Id, 1000, LedgerSource{Type: LedgerTypeOpening}), ShouldBeNil)
+		source := LedgerSource{Type: LedgerTypeAdminAdjust, Reference: "request", Remark: "admin 1"}
+
+		Convey("records the change of the saved quota with the update", func() {
+			// consumed after the admin loaded the user
+			So(DecreaseUserQuota(user.Id, 300, LedgerSource{Type: LedgerTypeConsume}), ShouldBeNil)
+			updated := &User{Id: user.Id, Username: "alice", Quota: 5000}
+			oldQuota, newQuota, err := updated.UpdateWithLedger(false, source)
+			So(err, ShouldBeNil)
+			So(oldQuota, ShouldEqual, 700)
+			So(newQuota, ShouldEqual, 5000)
+
+			entries, err := GetLedgerEntries(&LedgerFilter{UserId: user.Id, Type: LedgerTypeAdminAdjust}, 0, 10)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Amount, ShouldEqual, 4300)
+			So(entries[0].Reference, ShouldEqual, "request")
+
+			report, err := ReconcileLedger()
+			So(err, ShouldBeNil)
+			So(report.Drifts, ShouldBeEmpty)
+			So(report.Imbalance, ShouldEqual, 0)
+		})
+
+		Convey("records nothing when the quota is unchanged", func() {
+			updated := &User{Id: user.Id, Username: "alice", DisplayName: "Alice"}
+			oldQuota, newQuota, err := updated.UpdateWithLedger(false, source)
+			So(err, ShouldBeNil)
+			So(oldQuota, ShouldEqual, newQuota)
+			entries, _ := GetLedgerEntries(&LedgerFilter{UserId: user.Id, Type: LedgerTypeAdminAdjust}, 0, 10)
+			So(entries, ShouldBeEmpty)
+		})
+	})
+}
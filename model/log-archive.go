@@ -0,0 +1,313 @@
+package model
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	LogArchiveStatusRunning   = "running"
+	LogArchiveStatusSucceeded = "succeeded"
+	LogArchiveStatusFailed    = "failed"
+)
+
+// LogArchive records a run archiving the expired logs of one type into File.
+type LogArchive struct {
+	Id         int    `json:"id"`
+	Type       int    `json:"type"`
+	Cutoff     int64  `json:"cutoff" gorm:"bigint"` // logs created before it are archived
+	File       string `json:"file" gorm:"default:''"`
+	Count      int64  `json:"count" gorm:"default:0"`
+	Status     string `json:"status" gorm:"type:varchar(16)"`
+	Message    string `json:"message" gorm:"type:text"`
+	StartedAt  int64  `json:"started_at" gorm:"bigint;index"`
+	FinishedAt int64  `json:"finished_at" gorm:"bigint"`
+}
+
+// LogRetentionPolicy keeps the logs of Type for Days, types without a policy are kept forever.
+type LogRetentionPolicy struct {
+	Type int `json:"type"`
+	Days int `json:"days"`
+}
+
+var logRetentionPoliciesLock sync.RWMutex
+var logRetentionPolicies = make([]*LogRetentionPolicy, 0)
+
+func LogRetentionPolicies2JSONString() string {
+	logRetentionPoliciesLock.RLock()
+	defer logRetentionPoliciesLock.RUnlock()
+	jsonBytes, err := json.Marshal(logRetentionPolicies)
+	if err != nil {
+		logger.SysError("error marshalling log retention policies: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateLogRetentionPoliciesByJSONString(jsonStr string) error {
+	newPolicies := make([]*LogRetentionPolicy, 0)
+	err := json.Unmarshal([]byte(jsonStr), &newPolicies)
+	if err != nil {
+		return err
+	}
+	types := make(map[int]bool)
+	for _, policy := range newPolicies {
+		if policy.Type <= LogTypeUnknown || policy.Type > LogTypeError {
+			return fmt.Errorf("unknown log type %d", policy.Type)
+		}
+		if policy.Days <= 0 {
+			return fmt.Errorf("retention days of log type %d must be positive", policy.Type)
+		}
+		if types[policy.Type] {
+			return fmt.Errorf("duplicated retention policy for log type %d", policy.Type)
+		}
+		types[policy.Type] = true
+	}
+	logRetentionPoliciesLock.Lock()
+	defer logRetentionPoliciesLock.Unlock()
+	logRetentionPolicies = newPolicies
+	return nil
+}
+
+func GetLogRetentionPolicies() []*LogRetentionPolicy {
+	logRetentionPoliciesLock.RLock()
+	defer logRetentionPoliciesLock.RUnlock()
+	return logRetentionPolicies
+}
+
+func GetLogArchives(startIdx int, num int) (archives []*LogArchive, err error) {
+	err = LOG_DB.Order("id desc").Limit(num).Offset(startIdx).Find(&archives).Error
+	return archives, err
+}
+
+// logDeleter is implemented by the log stores able to delete logs by time, archival is skipped for the others.
+// The logs are not deleted by id since the ids given by the stores without auto increment are only unique within a node.
+type logDeleter interface {
+	// DeleteRange removes the logs of logType created in [start, end), start 0 means no lower bound
+	DeleteRange(logType int, start int64, end int64) error
+}
+
+var logArchiving atomic.Bool
+
+func IsArchivingLogs() bool {
+	return logArchiving.Load()
+}
+
+// ArchiveLogs archives the expired logs periodically.
+func ArchiveLogs(frequency int) {
+	for {
+		if err := ArchiveExpiredLogs(); err != nil {
+			logger.SysError("failed to archive logs: " + err.Error())
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
+
+// ArchiveExpiredLogs writes the logs past their retention to compressed files and then deletes them,
+// LogArchiveBatchSize logs at a time so the table is never locked for long.
+func ArchiveExpiredLogs() error {
+	if !logArchiving.CompareAndSwap(false, true) {
+		return fmt.Errorf("logs are being archived")
+	}
+	defer logArchiving.Store(false)
+	policies := GetLogRetentionPolicies()
+	if len(policies) == 0 {
+		return nil
+	}
+	deleter, ok := getLogStore().(logDeleter)
+	if !ok {
+		return fmt.Errorf("log store %s does not support retention policies", config.LogStore)
+	}
+	for _, policy := range policies {
+		cutoff := helper.GetTimestamp() - int64(policy.Days)*24*60*60
+		archive, err := archiveLogsBefore(deleter, policy.Type, cutoff)
+		if archive == nil {
+			if err != nil {
+				logger.SysError(fmt.Sprintf("failed to archive logs of type %d: %s", policy.Type, err.Error()))
+			}
+			continue
+		}
+		archive.FinishedAt = helper.GetTimestamp()
+		archive.Status = LogArchiveStatusSucceeded
+		if err != nil {
+			archive.Status = LogArchiveStatusFailed
+			archive.Message = err.Error()
+			logger.SysError(fmt.Sprintf("failed to archive logs of type %d: %s", policy.Type, err.Error()))
+		} else {
+			logger.SysLogf("archived %d logs of type %d to %s", archive.Count, policy.Type, archive.File)
+		}
+		if err = LOG_DB.Save(archive).Error; err != nil {
+			logger.SysError("failed to save log archive: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// archiveLogsBefore returns a nil archive when no log has expired. The logs are archived from the newest,
+// each batch is deleted by the range of seconds it covers.
+func archiveLogsBefore(deleter logDeleter, logType int, cutoff int64) (*LogArchive, error) {
+	end := cutoff
+	logs, err := getLogStore().Find(&LogFilter{Type: logType, EndTimestamp: end - 1}, 0, config.LogArchiveBatchSize)
+	if err != nil || len(logs) == 0 {
+		return nil, err
+	}
+	now := time.Now()
+	archive := &LogArchive{
+		Type:      logType,
+		Cutoff:    cutoff,
+		File:      fmt.Sprintf("logs-type%d-%s.%s.gz", logType, now.Format("20060102150405"), config.LogArchiveFormat),
+		Status:    LogArchiveStatusRunning,
+		StartedAt: now.Unix(),
+	}
+	if err = LOG_DB.Create(archive).Error; err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(config.LogArchiveDir, 0755); err != nil {
+		return archive, err
+	}
+	file, err := os.OpenFile(filepath.Join(config.LogArchiveDir, archive.File), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return archive, err
+	}
+	defer file.Close()
+	writer, err := newLogArchiveWriter(file, config.LogArchiveFormat)
+	if err != nil {
+		return archive, err
+	}
+	for len(logs) > 0 {
+		// a partial batch holds every expired log left
+		start := int64(0)
+		if len(logs) == config.LogArchiveBatchSize {
+			start, logs, err = batchRange(logType, logs, end)
+			if err != nil {
+				return archive, err
+			}
+		}
+		if err = writer.write(logs); err != nil {
+			return archive, err
+		}
+		// the logs must be on disk before they are deleted
+		if err = writer.flush(); err != nil {
+			return archive, err
+		}
+		if err = file.Sync(); err != nil {
+			return archive, err
+		}
+		if err = deleter.DeleteRange(logType, start, end); err != nil {
+			return archive, err
+		}
+		archive.Count += int64(len(logs))
+		if err = LOG_DB.Model(archive).Update("count", archive.Count).Error; err != nil {
+			return archive, err
+		}
+		if start == 0 {
+			break
+		}
+		end = start
+		logs, err = getLogStore().Find(&LogFilter{Type: logType, EndTimestamp: end - 1}, 0, config.LogArchiveBatchSize)
+		if err != nil {
+			return archive, err
+		}
+	}
+	return archive, writer.close()
+}
+
+// batchRange returns the start of the seconds covered by a full batch and the logs created in them. The logs of the
+// oldest second are left to the next batch since it may hold only some of them, unless the whole batch is in the same
+// second. They are found again as the stores may order the logs by id rather than by time.
+func batchRange(logType int, logs []*Log, end int64) (int64, []*Log, error) {
+	oldest := logs[0].CreatedAt
+	for _, log := range logs {
+		if log.CreatedAt < oldest {
+			oldest = log.CreatedAt
+		}
+	}
+	start := oldest + 1
+	if start >= end {
+		start = oldest
+	}
+	logs, err := getLogStore().Find(&LogFilter{Type: logType, StartTimestamp: start, EndTimestamp: end - 1}, 0, 0)
+	return start, logs, err
+}
+
+type logArchiveWriter struct {
+	gzipWriter *gzip.Writer
+	csvWriter  *csv.Writer
+	encoder    *json.Encoder
+}
+
+func newLogArchiveWriter(w io.Writer, format string) (*logArchiveWriter, error) {
+	writer := &logArchiveWriter{gzipWriter: gzip.NewWriter(w)}
+	switch format {
+	case "jsonl":
+		writer.encoder = json.NewEncoder(writer.gzipWriter)
+	case "csv":
+		writer.csvWriter = csv.NewWriter(writer.gzipWriter)
+		if err := writer.csvWriter.Write(logColumns()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown log archive format %s", format)
+	}
+	return writer, nil
+}
+
+// logColumns returns the json keys of Log, used as the csv header.
+func logColumns() []string {
+	logType := reflect.TypeOf(Log{})
+	columns := make([]string, 0, logType.NumField())
+	for i := 0; i < logType.NumField(); i++ {
+		columns = append(columns, strings.Split(logType.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return columns
+}
+
+func (w *logArchiveWriter) write(logs []*Log) error {
+	for _, log := range logs {
+		if w.encoder != nil {
+			if err := w.encoder.Encode(log); err != nil {
+				return err
+			}
+			continue
+		}
+		value := reflect.ValueOf(log).Elem()
+		record := make([]string, 0, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			record = append(record, fmt.Sprint(value.Field(i).Interface()))
+		}
+		if err := w.csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *logArchiveWriter) flush() error {
+	if w.csvWriter != nil {
+		w.csvWriter.Flush()
+		if err := w.csvWriter.Error(); err != nil {
+			return err
+		}
+	}
+	return w.gzipWriter.Flush()
+}
+
+func (w *logArchiveWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.gzipWriter.Close()
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func readArchivedLogs(path string) ([]*Log, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var logs []*Log
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		log := &Log{}
+		if err = json.Unmarshal(scanner.Bytes(), log); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, scanner.Err()
+}
+
+func TestArchiveLogsBefore(t *testing.T) {
+	Convey("TestArchiveLogsBefore", t, func() {
+		UseTestDB(t, &Log{}, &LogArchive{})
+		previousDir, previousFormat, previousBatchSize := config.LogArchiveDir, config.LogArchiveFormat, config.LogArchiveBatchSize
+		config.LogArchiveDir, config.LogArchiveFormat, config.LogArchiveBatchSize = t.TempDir(), "jsonl", 3
+		defer func() {
+			config.LogArchiveDir, config.LogArchiveFormat, config.LogArchiveBatchSize = previousDir, previousFormat, previousBatchSize
+		}()
+
+		// ids are out of the time order, as with logs inserted by several nodes
+		createdAts := []int64{100, 104, 101, 102, 102, 102, 102, 103, 105, 200, 300}
+		for i, createdAt := range createdAts {
+			So(LOG_DB.Create(&Log{Type: LogTypeConsume, CreatedAt: createdAt, Quota: i}).Error, ShouldBeNil)
+		}
+		So(LOG_DB.Create(&Log{Type: LogTypeTopup, CreatedAt: 100}).Error, ShouldBeNil)
+
+		archive, err := archiveLogsBefore(&sqlLogStore{db: LOG_DB}, LogTypeConsume, 200)
+		So(err, ShouldBeNil)
+		So(archive.Count, ShouldEqual, 9)
+		var saved LogArchive
+		So(LOG_DB.First(&saved, archive.Id).Error, ShouldBeNil)
+		So(saved.Count, ShouldEqual, 9)
+
+		archived, err := readArchivedLogs(filepath.Join(config.LogArchiveDir, archive.File))
+		So(err, ShouldBeNil)
+		quotas := make(map[int]bool)
+		for _, log := range archived {
+			So(log.CreatedAt, ShouldBeLessThan, 200)
+			quotas[log.Quota] = true
+		}
+		// every expired log is archived exactly once
+		So(len(archived), ShouldEqual, 9)
+		So(len(quotas), ShouldEqual, 9)
+
+		var remaining []*Log
+		So(LOG_DB.Order("created_at").Find(&remaining).Error, ShouldBeNil)
+		So(len(remaining), ShouldEqual, 3)
+		So(remaining[0].Type, ShouldEqual, LogTypeTopup)
+		So(remaining[1].CreatedAt, ShouldEqual, 200)
+		So(remaining[2].CreatedAt, ShouldEqual, 300)
+
+		Convey("returns no archive when nothing has expired", func() {
+			archive, err := archiveLogsBefore(&sqlLogStore{db: LOG_DB}, LogTypeConsume, 200)
+			So(err, ShouldBeNil)
+			So(archive, ShouldBeNil)
+		})
+	})
+}
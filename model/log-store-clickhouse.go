@@ -0,0 +1,275 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// clickhouseLogStore talks to the http interface of ClickHouse, values are bound with query parameters.
+// The columns are named after the json keys of Log, so rows are encoded as JSONEachRow directly.
+type clickhouseLogStore struct {
+	url    *url.URL
+	table  string
+	client *http.Client
+}
+
+// clickhouseLogColumns are the name and the type of the columns, new columns are appended to the tables
+// created by older versions on start.
+var clickhouseLogColumns = [][2]string{
+	{"id", "Int64"},
+	{"user_id", "Int64"},
+	{"created_at", "Int64"},
+	{"type", "Int32"},
+	{"content", "String"},
+	{"username", "String"},
+	{"token_name", "String"},
+	{"token_id", "Int64"},
+	{"model_name", "String"},
+	{"quota", "Int64"},
+	{"prompt_tokens", "Int64"},
+	{"completion_tokens", "Int64"},
+	{"cached_tokens", "Int64"},
+	{"cache_creation_tokens", "Int64"},
+	{"reasoning_tokens", "Int64"},
+	{"channel", "Int64"},
+	{"request_id", "String"},
+	{"elapsed_time", "Int64"},
+	{"first_token_time", "Int64"},
+	{"upstream_time", "Int64"},
+	{"tokens_per_second", "Float64"},
+	{"is_stream", "Bool"},
+	{"system_prompt_reset", "Bool"},
+	{"status_code", "Int32"},
+	{"error_code", "String"},
+	{"retry_chain", "String"},
+	{"cost", "String"},
+	{"price_version_id", "Int64"},
+	{"upstream_cost", "Int64"},
+}
+
+const clickhouseLogTableEngine = `ENGINE = MergeTree
+PARTITION BY toYYYYMM(toDateTime(created_at))
+ORDER BY (type, created_at)`
+
+func newClickHouseLogStore(rawURL string, table string) (*clickhouseLogStore, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("CLICKHOUSE_URL is not set")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	s := &clickhouseLogStore{
+		url:    u,
+		table:  table,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+	if config.IsMasterNode {
+		if err = s.migrate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *clickhouseLogStore) migrate() error {
+	columns := make([]string, 0, len(clickhouseLogColumns))
+	additions := make([]string, 0, len(clickhouseLogColumns))
+	for _, column := range clickhouseLogColumns {
+		columns = append(columns, column[0]+" "+column[1])
+		additions = append(additions, "ADD COLUMN IF NOT EXISTS "+column[0]+" "+column[1])
+	}
+	statement := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n) %s", s.table, strings.Join(columns, ",\n\t"), clickhouseLogTableEngine)
+	if _, err := s.query(statement, nil, nil); err != nil {
+		return err
+	}
+	_, err := s.query(fmt.Sprintf("ALTER TABLE %s %s", s.table, strings.Join(additions, ", ")), nil, nil)
+	return err
+}
+
+// query runs the statement with the params bound to {name:Type} placeholders, the statement is sent in
+// the url when body is not nil.
+func (s *clickhouseLogStore) query(statement string, params map[string]string, body io.Reader) ([]byte, error) {
+	u := *s.url
+	values := u.Query()
+	for name, value := range params {
+		values.Set("param_"+name, value)
+	}
+	values.Set("output_format_json_quote_64bit_integers", "0")
+	// the table may not be migrated yet by the master node
+	values.Set("input_format_skip_unknown_fields", "1")
+	if body == nil {
+		body = strings.NewReader(statement)
+	} else {
+		values.Set("query", statement)
+	}
+	u.RawQuery = values.Encode()
+	u.User = nil
+	req, err := http.NewRequest(http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.url.User != nil {
+		password, _ := s.url.User.Password()
+		req.SetBasicAuth(s.url.User.Username(), password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("clickhouse returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// selectRows decodes the JSONEachRow output into a new T for each row.
+func selectRows[T any](s *clickhouseLogStore, statement string, params map[string]string) ([]*T, error) {
+	data, err := s.query(statement+" FORMAT JSONEachRow", params, nil)
+	if err != nil {
+		return nil, err
+	}
+	var rows []*T
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		row := new(T)
+		if err = json.Unmarshal(scanner.Bytes(), row); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+func (s *clickhouseLogStore) where(filter *LogFilter) (string, map[string]string) {
+	var conditions []string
+	params := make(map[string]string)
+	add := func(column string, typ string, value string) {
+		conditions = append(conditions, fmt.Sprintf("%s = {%s:%s}", column, column, typ))
+		params[column] = value
+	}
+	if filter.UserId != 0 {
+		add("user_id", "Int64", strconv.Itoa(filter.UserId))
+	}
+	if filter.Type != LogTypeUnknown {
+		add("type", "Int32", strconv.Itoa(filter.Type))
+	}
+	if filter.ModelName != "" {
+		add("model_name", "String", filter.ModelName)
+	}
+	if filter.Username != "" {
+		add("username", "String", filter.Username)
+	}
+	if filter.TokenName != "" {
+		add("token_name", "String", filter.TokenName)
+	}
+	if filter.ChannelId != 0 {
+		add("channel", "Int64", strconv.Itoa(filter.ChannelId))
+	}
+	if filter.StartTimestamp != 0 {
+		conditions = append(conditions, "created_at >= {start_timestamp:Int64}")
+		params["start_timestamp"] = strconv.FormatInt(filter.StartTimestamp, 10)
+	}
+	if filter.EndTimestamp != 0 {
+		conditions = append(conditions, "created_at <= {end_timestamp:Int64}")
+		params["end_timestamp"] = strconv.FormatInt(filter.EndTimestamp, 10)
+	}
+	if len(conditions) == 0 {
+		return "", params
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), params
+}
+
+func (s *clickhouseLogStore) Insert(logs []*Log) error {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	for _, log := range logs {
+		log.Id = nextLogId()
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+	_, err := s.query(fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", s.table), nil, &buffer)
+	return err
+}
+
+func (s *clickhouseLogStore) Find(filter *LogFilter, startIdx int, num int) ([]*Log, error) {
+	where, params := s.where(filter)
+	statement := fmt.Sprintf("SELECT * FROM %s%s ORDER BY created_at DESC, id DESC", s.table, where)
+	if num > 0 {
+		statement += fmt.Sprintf(" LIMIT %d OFFSET %d", num, startIdx)
+	}
+	return selectRows[Log](s, statement, params)
+}
+
+func (s *clickhouseLogStore) Search(userId int, keyword string, num int) ([]*Log, error) {
+	params := map[string]string{"keyword": keyword}
+	where := "toString(type) = {keyword:String} OR startsWith(content, {keyword:String})"
+	if userId != 0 {
+		params["user_id"] = strconv.Itoa(userId)
+		where = "user_id = {user_id:Int64} AND toString(type) = {keyword:String}"
+	}
+	statement := fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY created_at DESC, id DESC LIMIT %d", s.table, where, num)
+	return selectRows[Log](s, statement, params)
+}
+
+func (s *clickhouseLogStore) FindLatencies(filter *LogFilter, num int) ([]*Log, error) {
+	where, params := s.where(filter)
+	statement := fmt.Sprintf("SELECT channel, model_name, elapsed_time, first_token_time, upstream_time, tokens_per_second FROM %s%s ORDER BY created_at DESC, id DESC LIMIT %d", s.table, where, num)
+	return selectRows[Log](s, statement, params)
+}
+
+func (s *clickhouseLogStore) Sum(filter *LogFilter) ([]*LogSum, error) {
+	where, params := s.where(filter)
+	statement := fmt.Sprintf("SELECT model_name, count() AS request_count, sum(quota) AS quota, sum(prompt_tokens) AS prompt_tokens, sum(completion_tokens) AS completion_tokens FROM %s%s GROUP BY model_name ORDER BY model_name", s.table, where)
+	return selectRows[LogSum](s, statement, params)
+}
+
+// DeleteBefore counts the logs first since the deletion is an asynchronous mutation in ClickHouse.
+func (s *clickhouseLogStore) DeleteBefore(timestamp int64) (int64, error) {
+	params := map[string]string{"timestamp": strconv.FormatInt(timestamp, 10)}
+	type countRow struct {
+		Count int64 `json:"count"`
+	}
+	counts, err := selectRows[countRow](s, fmt.Sprintf("SELECT count() AS count FROM %s WHERE created_at < {timestamp:Int64}", s.table), params)
+	if err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 || counts[0].Count == 0 {
+		return 0, nil
+	}
+	_, err = s.query(fmt.Sprintf("ALTER TABLE %s DELETE WHERE created_at < {timestamp:Int64}", s.table), params, nil)
+	if err != nil {
+		return 0, err
+	}
+	return counts[0].Count, nil
+}
+
+// DeleteRange uses the lightweight delete so the rows are gone for the following queries.
+func (s *clickhouseLogStore) DeleteRange(logType int, start int64, end int64) error {
+	where, params := s.where(&LogFilter{Type: logType, StartTimestamp: start})
+	params["end_timestamp"] = strconv.FormatInt(end, 10)
+	if where == "" {
+		where = " WHERE created_at < {end_timestamp:Int64}"
+	} else {
+		where += " AND created_at < {end_timestamp:Int64}"
+	}
+	_, err := s.query(fmt.Sprintf("DELETE FROM %s%s", s.table, where), params, nil)
+	return err
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestClickHouseLogStore(t *testing.T) {
+	Convey("TestClickHouseLogStore", t, func() {
+		var statements []string
+		var params []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			statement := r.URL.Query().Get("query")
+			if statement == "" {
+				body, _ := io.ReadAll(r.Body)
+				statement = string(body)
+			}
+			statements = append(statements, statement)
+			params = append(params, r.URL.Query().Get("param_model_name"))
+			if strings.HasPrefix(statement, "SELECT") {
+				_, _ = w.Write([]byte(`{"channel":1,"model_name":"gpt-4o","elapsed_time":100}` + "\n" + `{"channel":2,"model_name":"gpt-4o","elapsed_time":200}` + "\n"))
+			}
+		}))
+		defer server.Close()
+		previousIsMasterNode := config.IsMasterNode
+		config.IsMasterNode = true
+		defer func() { config.IsMasterNode = previousIsMasterNode }()
+
+		s, err := newClickHouseLogStore(server.URL+"/?database=oneapi", "logs")
+		So(err, ShouldBeNil)
+
+		Convey("adds the missing columns to existing tables", func() {
+			So(len(statements), ShouldEqual, 2)
+			So(statements[0], ShouldStartWith, "CREATE TABLE IF NOT EXISTS logs (")
+			So(statements[1], ShouldStartWith, "ALTER TABLE logs ADD COLUMN IF NOT EXISTS id Int64,")
+			// every field of Log has a column
+			for _, column := range logColumns() {
+				So(statements[0], ShouldContainSubstring, "\n\t"+column+" ")
+				So(statements[1], ShouldContainSubstring, "ADD COLUMN IF NOT EXISTS "+column+" ")
+			}
+		})
+
+		Convey("selects the latency columns with a limit", func() {
+			logs, err := s.FindLatencies(&LogFilter{Type: LogTypeConsume, ModelName: "gpt-4o"}, 10)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 2)
+			So(logs[1].ChannelId, ShouldEqual, 2)
+			So(logs[1].ElapsedTime, ShouldEqual, 200)
+			last := statements[len(statements)-1]
+			So(last, ShouldStartWith, "SELECT channel, model_name, elapsed_time, first_token_time, upstream_time, tokens_per_second FROM logs WHERE")
+			So(last, ShouldContainSubstring, "model_name = {model_name:String}")
+			So(last, ShouldEndWith, "LIMIT 10 FORMAT JSONEachRow")
+			So(params[len(params)-1], ShouldEqual, "gpt-4o")
+		})
+	})
+}
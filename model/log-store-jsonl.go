@@ -0,0 +1,260 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// jsonlLogStore appends logs to a file rotated by size, one json object per line.
+// Queries scan the files from the newest, so it suits archival more than frequent searching.
+type jsonlLogStore struct {
+	path   string
+	writer *lumberjack.Logger
+}
+
+func newJSONLLogStore(path string) (*jsonlLogStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &jsonlLogStore{
+		path: path,
+		writer: &lumberjack.Logger{
+			Filename:  path,
+			MaxSize:   config.LogStoreMaxSize,
+			LocalTime: true,
+		},
+	}, nil
+}
+
+func (s *jsonlLogStore) Insert(logs []*Log) error {
+	var builder strings.Builder
+	for _, log := range logs {
+		log.Id = nextLogId()
+		jsonBytes, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		builder.Write(jsonBytes)
+		builder.WriteByte('\n')
+	}
+	// a single write keeps the batch in one file across rotation
+	_, err := s.writer.Write([]byte(builder.String()))
+	return err
+}
+
+// files returns the current file and then the rotated ones from the newest.
+func (s *jsonlLogStore) files() ([]string, error) {
+	ext := filepath.Ext(s.path)
+	prefix := strings.TrimSuffix(s.path, ext) + "-"
+	backups, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	// lumberjack names backups by time, so the lexical order is the time order
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return append([]string{s.path}, backups...), nil
+}
+
+// scan calls match for the logs from the newest until it returns false.
+func (s *jsonlLogStore) scan(match func(log *Log) bool) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		stopped, err := scanJSONLFile(file, match)
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonlReadBlockSize is how much of a file is read at a time, the files are read backward from the newest log
+var jsonlReadBlockSize = 64 * 1024
+
+// scanJSONLFile calls match for the logs of file from the last line, it reports whether match returned false.
+func scanJSONLFile(file string, match func(log *Log) bool) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// not created yet or removed by rotation
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	decode := func(line []byte) bool {
+		log := &Log{}
+		// skip the line being written
+		if len(line) == 0 || json.Unmarshal(line, log) != nil {
+			return true
+		}
+		return match(log)
+	}
+	block := make([]byte, jsonlReadBlockSize)
+	// the beginning of the line whose end has been read
+	var partial []byte
+	for offset := info.Size(); offset > 0; {
+		n := int64(len(block))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		if _, err = f.ReadAt(block[:n], offset); err != nil {
+			return false, err
+		}
+		chunk := append(block[:n:n], partial...)
+		for {
+			i := bytes.LastIndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			if !decode(chunk[i+1:]) {
+				return true, nil
+			}
+			chunk = chunk[:i]
+		}
+		// copied since the block is reused
+		partial = append([]byte(nil), chunk...)
+	}
+	return !decode(partial), nil
+}
+
+func (filter *LogFilter) match(log *Log) bool {
+	return (filter.UserId == 0 || log.UserId == filter.UserId) &&
+		(filter.Type == LogTypeUnknown || log.Type == filter.Type) &&
+		(filter.ModelName == "" || log.ModelName == filter.ModelName) &&
+		(filter.Username == "" || log.Username == filter.Username) &&
+		(filter.TokenName == "" || log.TokenName == filter.TokenName) &&
+		(filter.StartTimestamp == 0 || log.CreatedAt >= filter.StartTimestamp) &&
+		(filter.EndTimestamp == 0 || log.CreatedAt <= filter.EndTimestamp) &&
+		(filter.ChannelId == 0 || log.ChannelId == filter.ChannelId)
+}
+
+func (s *jsonlLogStore) Find(filter *LogFilter, startIdx int, num int) ([]*Log, error) {
+	var logs []*Log
+	skipped := 0
+	err := s.scan(func(log *Log) bool {
+		if !filter.match(log) {
+			return true
+		}
+		if skipped < startIdx {
+			skipped++
+			return true
+		}
+		logs = append(logs, log)
+		return num <= 0 || len(logs) < num
+	})
+	return logs, err
+}
+
+func (s *jsonlLogStore) Search(userId int, keyword string, num int) ([]*Log, error) {
+	var logs []*Log
+	err := s.scan(func(log *Log) bool {
+		typeMatched := strconv.Itoa(log.Type) == keyword
+		if userId != 0 {
+			if log.UserId != userId || !typeMatched {
+				return true
+			}
+		} else if !typeMatched && !strings.HasPrefix(log.Content, keyword) {
+			return true
+		}
+		logs = append(logs, log)
+		return len(logs) < num
+	})
+	return logs, err
+}
+
+func (s *jsonlLogStore) FindLatencies(filter *LogFilter, num int) ([]*Log, error) {
+	var logs []*Log
+	err := s.scan(func(log *Log) bool {
+		if !filter.match(log) {
+			return true
+		}
+		logs = append(logs, &Log{
+			ChannelId:       log.ChannelId,
+			ModelName:       log.ModelName,
+			ElapsedTime:     log.ElapsedTime,
+			FirstTokenTime:  log.FirstTokenTime,
+			UpstreamTime:    log.UpstreamTime,
+			TokensPerSecond: log.TokensPerSecond,
+		})
+		return len(logs) < num
+	})
+	return logs, err
+}
+
+func (s *jsonlLogStore) Sum(filter *LogFilter) ([]*LogSum, error) {
+	sums := make(map[string]*LogSum)
+	err := s.scan(func(log *Log) bool {
+		if !filter.match(log) {
+			return true
+		}
+		sum, ok := sums[log.ModelName]
+		if !ok {
+			sum = &LogSum{ModelName: log.ModelName}
+			sums[log.ModelName] = sum
+		}
+		sum.RequestCount++
+		sum.Quota += int64(log.Quota)
+		sum.PromptTokens += int64(log.PromptTokens)
+		sum.CompletionTokens += int64(log.CompletionTokens)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*LogSum, 0, len(sums))
+	for _, sum := range sums {
+		result = append(result, sum)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ModelName < result[j].ModelName
+	})
+	return result, nil
+}
+
+// DeleteBefore removes the rotated files last written before timestamp, the file being appended is kept.
+func (s *jsonlLogStore) DeleteBefore(timestamp int64) (int64, error) {
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, file := range files[1:] {
+		info, err := os.Stat(file)
+		if err != nil || info.ModTime().Unix() >= timestamp {
+			continue
+		}
+		var logs int64
+		if _, err = scanJSONLFile(file, func(*Log) bool {
+			logs++
+			return true
+		}); err != nil {
+			return count, err
+		}
+		if err = os.Remove(file); err != nil {
+			return count, err
+		}
+		count += logs
+	}
+	return count, nil
+}
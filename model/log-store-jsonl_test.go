@@ -0,0 +1,74 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJSONLLogStore(t *testing.T) {
+	Convey("TestJSONLLogStore", t, func() {
+		// small blocks split the lines across reads
+		previousBlockSize := jsonlReadBlockSize
+		jsonlReadBlockSize = 7
+		defer func() { jsonlReadBlockSize = previousBlockSize }()
+
+		dir := t.TempDir()
+		s, err := newJSONLLogStore(filepath.Join(dir, "logs.jsonl"))
+		So(err, ShouldBeNil)
+		defer s.writer.Close()
+
+		// a rotated file holds the older logs
+		rotated := filepath.Join(dir, "logs-2024-01-01T00-00-00.000.jsonl")
+		So(os.WriteFile(rotated, []byte(`{"id":1,"type":2,"user_id":1,"content":"first"}`+"\n"+`{"id":2,"type":1,"user_id":2,"content":"topup"}`+"\n"), 0644), ShouldBeNil)
+		So(s.Insert([]*Log{
+			{Type: LogTypeConsume, UserId: 1, Content: "second", ChannelId: 3, ElapsedTime: 10},
+			{Type: LogTypeConsume, UserId: 2, Content: "third 中文"},
+		}), ShouldBeNil)
+		// the line being written is skipped
+		f, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+		So(err, ShouldBeNil)
+		_, err = f.WriteString(`{"id":9,"type":2,"con`)
+		So(err, ShouldBeNil)
+		So(f.Close(), ShouldBeNil)
+
+		Convey("finds the logs from the newest across files", func() {
+			logs, err := s.Find(&LogFilter{Type: LogTypeConsume}, 0, 0)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 3)
+			So(logs[0].Content, ShouldEqual, "third 中文")
+			So(logs[1].Content, ShouldEqual, "second")
+			So(logs[2].Content, ShouldEqual, "first")
+
+			logs, err = s.Find(&LogFilter{UserId: 1}, 1, 1)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Content, ShouldEqual, "first")
+		})
+
+		Convey("stops scanning once enough logs are found", func() {
+			logs, err := s.Search(0, "1", 1)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Content, ShouldEqual, "topup")
+
+			logs, err = s.FindLatencies(&LogFilter{UserId: 1}, 1)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].ChannelId, ShouldEqual, 3)
+			So(logs[0].ElapsedTime, ShouldEqual, 10)
+			So(logs[0].Content, ShouldBeEmpty)
+		})
+
+		Convey("deletes the rotated files", func() {
+			count, err := s.DeleteBefore(time.Now().Add(time.Hour).Unix())
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+			_, err = os.Stat(rotated)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
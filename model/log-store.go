@@ -0,0 +1,171 @@
+package model
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	LogStoreSQL        = "sql"
+	LogStoreJSONL      = "jsonl"
+	LogStoreClickHouse = "clickhouse"
+)
+
+// LogFilter selects logs, zero values match everything.
+type LogFilter struct {
+	UserId         int
+	Type           int
+	StartTimestamp int64
+	EndTimestamp   int64
+	ModelName      string
+	Username       string
+	TokenName      string
+	ChannelId      int
+}
+
+// LogSum is the usage of a model summed from the logs
+type LogSum struct {
+	ModelName        string `json:"model_name"`
+	RequestCount     int64  `json:"request_count"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+// LogStore is where the logs table lives, usage rollups and payload logs always stay in LOG_DB.
+type LogStore interface {
+	Insert(logs []*Log) error
+	// Find returns the matched logs from the newest, num <= 0 means no limit
+	Find(filter *LogFilter, startIdx int, num int) ([]*Log, error)
+	// Search matches the keyword against type or content prefix, or only against type for a user
+	Search(userId int, keyword string, num int) ([]*Log, error)
+	// FindLatencies returns the newest num matched logs with only the channel, the model and the latency fields set
+	FindLatencies(filter *LogFilter, num int) ([]*Log, error)
+	// Sum sums the matched logs by model, it's only used for the recent logs not in the usage rollups yet
+	Sum(filter *LogFilter) ([]*LogSum, error)
+	DeleteBefore(timestamp int64) (int64, error)
+}
+
+var logStore LogStore
+
+// InitLogStore must be called after InitLogDB.
+func InitLogStore() {
+	var err error
+	switch config.LogStore {
+	case LogStoreSQL:
+		logStore = &sqlLogStore{db: LOG_DB}
+	case LogStoreJSONL:
+		logStore, err = newJSONLLogStore(config.LogStorePath)
+	case LogStoreClickHouse:
+		logStore, err = newClickHouseLogStore(config.ClickHouseURL, config.ClickHouseLogTable)
+	default:
+		err = fmt.Errorf("unknown log store %s", config.LogStore)
+	}
+	if err != nil {
+		logger.FatalLog("failed to initialize log store: " + err.Error())
+		return
+	}
+	logger.SysLogf("using %s log store", config.LogStore)
+}
+
+func getLogStore() LogStore {
+	if logStore == nil {
+		// not initialized, e.g. in tests
+		return &sqlLogStore{db: LOG_DB}
+	}
+	return logStore
+}
+
+// lastLogId gives ids to the stores without auto increment, it's seeded with the start time so ids keep
+// increasing across restarts, they are only unique within a node.
+var lastLogId = time.Now().UnixMicro()
+
+func nextLogId() int {
+	return int(atomic.AddInt64(&lastLogId, 1))
+}
+
+type sqlLogStore struct {
+	db *gorm.DB
+}
+
+func (s *sqlLogStore) where(filter *LogFilter) *gorm.DB {
+	tx := s.db.Model(&Log{})
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Type != LogTypeUnknown {
+		tx = tx.Where("type = ?", filter.Type)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("username = ?", filter.Username)
+	}
+	if filter.TokenName != "" {
+		tx = tx.Where("token_name = ?", filter.TokenName)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	return tx
+}
+
+func (s *sqlLogStore) Insert(logs []*Log) error {
+	return s.db.CreateInBatches(logs, len(logs)).Error
+}
+
+func (s *sqlLogStore) Find(filter *LogFilter, startIdx int, num int) (logs []*Log, err error) {
+	tx := s.where(filter).Order("id desc")
+	if num > 0 {
+		tx = tx.Limit(num).Offset(startIdx)
+	}
+	err = tx.Find(&logs).Error
+	return logs, err
+}
+
+func (s *sqlLogStore) Search(userId int, keyword string, num int) (logs []*Log, err error) {
+	var tx *gorm.DB
+	if userId == 0 {
+		tx = s.db.Where("type = ? or content LIKE ?", keyword, keyword+"%")
+	} else {
+		tx = s.db.Where("user_id = ? and type = ?", userId, keyword)
+	}
+	err = tx.Order("id desc").Limit(num).Find(&logs).Error
+	return logs, err
+}
+
+func (s *sqlLogStore) FindLatencies(filter *LogFilter, num int) (logs []*Log, err error) {
+	err = s.where(filter).
+		Select("channel_id, model_name, elapsed_time, first_token_time, upstream_time, tokens_per_second").
+		Order("id desc").Limit(num).Find(&logs).Error
+	return logs, err
+}
+
+func (s *sqlLogStore) Sum(filter *LogFilter) (sums []*LogSum, err error) {
+	err = s.where(filter).
+		Select("model_name, count(1) as request_count, sum(quota) as quota, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Group("model_name").Order("model_name").Scan(&sums).Error
+	return sums, err
+}
+
+func (s *sqlLogStore) DeleteBefore(timestamp int64) (int64, error) {
+	result := s.db.Where("created_at < ?", timestamp).Delete(&Log{})
+	return result.RowsAffected, result.Error
+}
+
+func (s *sqlLogStore) DeleteRange(logType int, start int64, end int64) error {
+	return s.where(&LogFilter{Type: logType, StartTimestamp: start}).Where("created_at < ?", end).Delete(&Log{}).Error
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+)
+
+type logEntry struct {
+	ctx context.Context
+	log *Log
+}
+
+// logQueue is nil until StartLogWriter is called and after StopLogWriter, logs are written synchronously then
+var logQueue chan *logEntry
+var logQueueLock sync.RWMutex
+var logWriterDone chan struct{}
+
+// StartLogWriter starts the goroutine inserting logs in batches of LogBatchSize, or every LogBatchInterval.
+func StartLogWriter() {
+	logQueue = make(chan *logEntry, config.LogQueueSize)
+	logWriterDone = make(chan struct{})
+	go runLogWriter(logQueue, logWriterDone)
+	logger.SysLogf("async log writer started, queue size %d, batch size %d", config.LogQueueSize, config.LogBatchSize)
+}
+
+// StopLogWriter flushes the queued logs, it must be called after the last log is recorded.
+func StopLogWriter() {
+	logQueueLock.Lock()
+	queue := logQueue
+	logQueue = nil
+	logQueueLock.Unlock()
+	if queue == nil {
+		return
+	}
+	close(queue)
+	<-logWriterDone
+}
+
+// enqueueLog applies backpressure by waiting up to LogEnqueueTimeout when the queue is full. After that the log is
+// inserted synchronously, unless it's droppable.
+func enqueueLog(ctx context.Context, log *Log) {
+	logQueueLock.RLock()
+	defer logQueueLock.RUnlock()
+	if logQueue == nil {
+		insertLog(ctx, log)
+		return
+	}
+	entry := &logEntry{ctx: ctx, log: log}
+	select {
+	case logQueue <- entry:
+		return
+	default:
+	}
+	metrics.ObserveLogDelayed()
+	timer := time.NewTimer(time.Duration(config.LogEnqueueTimeout) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case logQueue <- entry:
+	case <-timer.C:
+		if isLogDroppable(log) {
+			metrics.ObserveLogDropped()
+			logger.Warnf(ctx, "log queue is full, dropping log: %+v", log)
+			return
+		}
+		metrics.ObserveLogWrittenSynchronously()
+		insertLog(ctx, log)
+	}
+}
+
+// isLogDroppable tells the logs which can be lost under load, billing, error and audit logs must be kept
+func isLogDroppable(log *Log) bool {
+	return log.Type == LogTypeTest
+}
+
+func runLogWriter(queue chan *logEntry, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(time.Duration(config.LogBatchInterval) * time.Millisecond)
+	defer ticker.Stop()
+	batch := make([]*logEntry, 0, config.LogBatchSize)
+	for {
+		select {
+		case entry, ok := <-queue:
+			if !ok {
+				writeLogs(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= config.LogBatchSize {
+				writeLogs(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			writeLogs(batch)
+			batch = batch[:0]
+		}
+		metrics.SetLogQueueLength(len(queue))
+	}
+}
+
+func writeLogs(batch []*logEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	logs := make([]*Log, 0, len(batch))
+	for _, entry := range batch {
+		logs = append(logs, entry.log)
+	}
+	err := getLogStore().Insert(logs)
+	if err != nil {
+		logger.SysError("failed to record logs in batch, retrying one by one: " + err.Error())
+		for _, entry := range batch {
+			insertLog(entry.ctx, entry.log)
+		}
+		return
+	}
+	for _, log := range logs {
+		addUsageRollup(log)
+	}
+}
+
+func insertLog(ctx context.Context, log *Log) {
+	err := getLogStore().Insert([]*Log{log})
+	if err != nil {
+		logger.Error(ctx, "failed to record log: "+err.Error())
+		return
+	}
+	addUsageRollup(log)
+}
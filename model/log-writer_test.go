@@ -0,0 +1,56 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func countLogs(logType int) int64 {
+	var count int64
+	LOG_DB.Model(&Log{}).Where("type = ?", logType).Count(&count)
+	return count
+}
+
+func TestLogWriter(t *testing.T) {
+	Convey("TestLogWriter", t, func() {
+		UseTestDB(t, &Log{})
+		ctx := context.Background()
+
+		Convey("flushes the queued logs when stopped", func() {
+			StartLogWriter()
+			for i := 0; i < 3; i++ {
+				enqueueLog(ctx, &Log{Type: LogTypeTopup, Quota: i})
+			}
+			StopLogWriter()
+			So(countLogs(LogTypeTopup), ShouldEqual, 3)
+			// written synchronously once stopped
+			enqueueLog(ctx, &Log{Type: LogTypeTopup})
+			So(countLogs(LogTypeTopup), ShouldEqual, 4)
+		})
+
+		Convey("keeps the valuable logs when the queue stays full", func() {
+			previousTimeout := config.LogEnqueueTimeout
+			config.LogEnqueueTimeout = 1
+			// a full queue without a writer
+			logQueue = make(chan *logEntry, 1)
+			logQueue <- &logEntry{ctx: ctx, log: &Log{Type: LogTypeConsume}}
+			defer func() {
+				logQueue = nil
+				config.LogEnqueueTimeout = previousTimeout
+			}()
+
+			enqueueLog(ctx, &Log{Type: LogTypeConsume})
+			enqueueLog(ctx, &Log{Type: LogTypeTopup})
+			enqueueLog(ctx, &Log{Type: LogTypeError})
+			enqueueLog(ctx, &Log{Type: LogTypeTest})
+			So(countLogs(LogTypeConsume), ShouldEqual, 1)
+			So(countLogs(LogTypeTopup), ShouldEqual, 1)
+			So(countLogs(LogTypeError), ShouldEqual, 1)
+			So(countLogs(LogTypeTest), ShouldEqual, 0)
+		})
+	})
+}
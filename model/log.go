@@ -3,32 +3,45 @@ package model
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
+	"time"
 
-	"gorm.io/gorm"
-
-	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
 type Log struct {
-	Id                int    `json:"id"`
-	UserId            int    `json:"user_id" gorm:"index"`
-	CreatedAt         int64  `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
-	Type              int    `json:"type" gorm:"index:idx_created_at_type"`
-	Content           string `json:"content"`
-	Username          string `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
-	TokenName         string `json:"token_name" gorm:"index;default:''"`
-	ModelName         string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
-	Quota             int    `json:"quota" gorm:"default:0"`
-	PromptTokens      int    `json:"prompt_tokens" gorm:"default:0"`
-	CompletionTokens  int    `json:"completion_tokens" gorm:"default:0"`
-	ChannelId         int    `json:"channel" gorm:"index"`
-	RequestId         string `json:"request_id" gorm:"default:''"`
-	ElapsedTime       int64  `json:"elapsed_time" gorm:"default:0"` // unit is ms
-	IsStream          bool   `json:"is_stream" gorm:"default:false"`
-	SystemPromptReset bool   `json:"system_prompt_reset" gorm:"default:false"`
+	Id                  int     `json:"id"`
+	UserId              int     `json:"user_id" gorm:"index"`
+	CreatedAt           int64   `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
+	Type                int     `json:"type" gorm:"index:idx_created_at_type"`
+	Content             string  `json:"content"`
+	Username            string  `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
+	TokenName           string  `json:"token_name" gorm:"index;default:''"`
+	TokenId             int     `json:"token_id" gorm:"default:0"`
+	ModelName           string  `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
+	Quota               int     `json:"quota" gorm:"default:0"`
+	PromptTokens        int     `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens    int     `json:"completion_tokens" gorm:"default:0"`
+	CachedTokens        int     `json:"cached_tokens" gorm:"default:0"`         // prompt tokens read from the prompt cache
+	CacheCreationTokens int     `json:"cache_creation_tokens" gorm:"default:0"` // prompt tokens written to the prompt cache
+	ReasoningTokens     int     `json:"reasoning_tokens" gorm:"default:0"`      // completion tokens spent on thinking
+	ChannelId           int     `json:"channel" gorm:"index"`
+	RequestId           string  `json:"request_id" gorm:"default:''"`
+	ElapsedTime         int64   `json:"elapsed_time" gorm:"default:0"`      // unit is ms
+	FirstTokenTime      int64   `json:"first_token_time" gorm:"default:0"`  // unit is ms, only for stream
+	UpstreamTime        int64   `json:"upstream_time" gorm:"default:0"`     // unit is ms
+	TokensPerSecond     float64 `json:"tokens_per_second" gorm:"default:0"` // output tokens per second after the first token
+	IsStream            bool    `json:"is_stream" gorm:"default:false"`
+	SystemPromptReset   bool    `json:"system_prompt_reset" gorm:"default:false"`
+	StatusCode          int     `json:"status_code" gorm:"default:0"`
+	ErrorCode           string  `json:"error_code" gorm:"default:''"`
+	RetryChain          string  `json:"retry_chain" gorm:"default:''"`     // ids of the channels tried in order, comma separated
+	Cost                string  `json:"cost" gorm:"type:text"`             // itemized cost of consume logs in JSON
+	PriceVersionId      int     `json:"price_version_id" gorm:"default:0"` // 0 for the unversioned catalog and the models priced by ratios
+	UpstreamCost        int     `json:"upstream_cost" gorm:"default:0"`    // what the channel charges us, in quota
 }
 
 const (
@@ -38,17 +51,15 @@ const (
 	LogTypeManage
 	LogTypeSystem
 	LogTypeTest
+	LogTypeGuardrail
+	LogTypeError
 )
 
 func recordLogHelper(ctx context.Context, log *Log) {
 	requestId := helper.GetRequestID(ctx)
 	log.RequestId = requestId
-	err := LOG_DB.Create(log).Error
-	if err != nil {
-		logger.Error(ctx, "failed to record log: "+err.Error())
-		return
-	}
 	logger.Infof(ctx, "record log: %+v", log)
+	enqueueLog(ctx, log)
 }
 
 func RecordLog(ctx context.Context, userId int, logType int, content string) {
@@ -87,6 +98,23 @@ func RecordConsumeLog(ctx context.Context, log *Log) {
 	recordLogHelper(ctx, log)
 }
 
+func RecordGuardrailLog(ctx context.Context, log *Log) {
+	log.Username = GetUsernameById(log.UserId)
+	log.CreatedAt = helper.GetTimestamp()
+	log.Type = LogTypeGuardrail
+	recordLogHelper(ctx, log)
+}
+
+func RecordErrorLog(ctx context.Context, log *Log) {
+	if !config.LogErrorEnabled {
+		return
+	}
+	log.Username = GetUsernameById(log.UserId)
+	log.CreatedAt = helper.GetTimestamp()
+	log.Type = LogTypeError
+	recordLogHelper(ctx, log)
+}
+
 func RecordTestLog(ctx context.Context, log *Log) {
 	log.CreatedAt = helper.GetTimestamp()
 	log.Type = LogTypeTest
@@ -94,123 +122,144 @@ func RecordTestLog(ctx context.Context, log *Log) {
 }
 
 func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int) (logs []*Log, err error) {
-	var tx *gorm.DB
-	if logType == LogTypeUnknown {
-		tx = LOG_DB
-	} else {
-		tx = LOG_DB.Where("type = ?", logType)
-	}
-	if modelName != "" {
-		tx = tx.Where("model_name = ?", modelName)
-	}
-	if username != "" {
-		tx = tx.Where("username = ?", username)
-	}
-	if tokenName != "" {
-		tx = tx.Where("token_name = ?", tokenName)
-	}
-	if startTimestamp != 0 {
-		tx = tx.Where("created_at >= ?", startTimestamp)
-	}
-	if endTimestamp != 0 {
-		tx = tx.Where("created_at <= ?", endTimestamp)
-	}
-	if channel != 0 {
-		tx = tx.Where("channel_id = ?", channel)
-	}
-	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
-	return logs, err
+	return getLogStore().Find(&LogFilter{
+		Type:           logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		Username:       username,
+		TokenName:      tokenName,
+		ChannelId:      channel,
+	}, startIdx, num)
 }
 
 func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
-	var tx *gorm.DB
-	if logType == LogTypeUnknown {
-		tx = LOG_DB.Where("user_id = ?", userId)
-	} else {
-		tx = LOG_DB.Where("user_id = ? and type = ?", userId, logType)
-	}
-	if modelName != "" {
-		tx = tx.Where("model_name = ?", modelName)
-	}
-	if tokenName != "" {
-		tx = tx.Where("token_name = ?", tokenName)
-	}
-	if startTimestamp != 0 {
-		tx = tx.Where("created_at >= ?", startTimestamp)
-	}
-	if endTimestamp != 0 {
-		tx = tx.Where("created_at <= ?", endTimestamp)
-	}
-	err = tx.Order("id desc").Limit(num).Offset(startIdx).Omit("id").Find(&logs).Error
+	logs, err = getLogStore().Find(&LogFilter{
+		UserId:         userId,
+		Type:           logType,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		TokenName:      tokenName,
+	}, startIdx, num)
+	hideLogInternals(logs)
 	return logs, err
 }
 
 func SearchAllLogs(keyword string) (logs []*Log, err error) {
-	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(config.MaxRecentItems).Find(&logs).Error
-	return logs, err
+	return getLogStore().Search(0, keyword, config.MaxRecentItems)
 }
 
 func SearchUserLogs(userId int, keyword string) (logs []*Log, err error) {
-	err = LOG_DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(config.MaxRecentItems).Omit("id").Find(&logs).Error
+	logs, err = getLogStore().Search(userId, keyword, config.MaxRecentItems)
+	hideLogInternals(logs)
 	return logs, err
 }
 
-func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (quota int64) {
-	ifnull := "ifnull"
-	if common.UsingPostgreSQL {
-		ifnull = "COALESCE"
-	}
-	tx := LOG_DB.Table("logs").Select(fmt.Sprintf("%s(sum(quota),0)", ifnull))
-	if username != "" {
-		tx = tx.Where("username = ?", username)
-	}
-	if tokenName != "" {
-		tx = tx.Where("token_name = ?", tokenName)
+// hideLogInternals keeps users from learning the total number of logs and our upstream cost
+func hideLogInternals(logs []*Log) {
+	for _, log := range logs {
+		log.Id = 0
+		log.UpstreamCost = 0
 	}
-	if startTimestamp != 0 {
-		tx = tx.Where("created_at >= ?", startTimestamp)
+}
+
+// SumUsedQuota reads the hourly usage rollups before the current day, so the start is widened to the hour it's in.
+// The current day is read from the logs since its rollups lag by the flush interval.
+func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (quota int64) {
+	stat, err := sumUsage(startTimestamp, endTimestamp, modelName, username, tokenName, channel)
+	if err != nil {
+		logger.SysError("failed to sum used quota: " + err.Error())
+		return 0
 	}
-	if endTimestamp != 0 {
-		tx = tx.Where("created_at <= ?", endTimestamp)
+	return stat.Quota
+}
+
+func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
+	stat, err := sumUsage(startTimestamp, endTimestamp, modelName, username, tokenName, 0)
+	if err != nil {
+		logger.SysError("failed to sum used token: " + err.Error())
+		return 0
 	}
-	if modelName != "" {
-		tx = tx.Where("model_name = ?", modelName)
+	return int(stat.PromptTokens + stat.CompletionTokens)
+}
+
+// sumUsage adds the logs of the current day to the rollups of the days before
+func sumUsage(startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (*UsageStat, error) {
+	today := getRollupDay(helper.GetTimestamp())
+	stat := &UsageStat{}
+	if startTimestamp < today {
+		rollupEnd := today - 1
+		if endTimestamp != 0 && endTimestamp < rollupEnd {
+			rollupEnd = endTimestamp
+		}
+		var err error
+		stat, err = sumUsageRollups(startTimestamp, rollupEnd, modelName, username, tokenName, channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if endTimestamp != 0 && endTimestamp < today {
+		return stat, nil
+	}
+	if startTimestamp < today {
+		startTimestamp = today
+	}
+	sums, err := getLogStore().Sum(&LogFilter{
+		Type:           LogTypeConsume,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		Username:       username,
+		TokenName:      tokenName,
+		ChannelId:      channel,
+	})
+	if err != nil {
+		return nil, err
 	}
-	if channel != 0 {
-		tx = tx.Where("channel_id = ?", channel)
+	for _, sum := range sums {
+		stat.RequestCount += sum.RequestCount
+		stat.Quota += sum.Quota
+		stat.PromptTokens += sum.PromptTokens
+		stat.CompletionTokens += sum.CompletionTokens
 	}
-	tx.Where("type = ?", LogTypeConsume).Scan(&quota)
-	return quota
+	return stat, nil
 }
 
-func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
-	ifnull := "ifnull"
-	if common.UsingPostgreSQL {
-		ifnull = "COALESCE"
+// sumUsageRollups resolves the username and the token name of the log filters to the ids the rollups are keyed by
+func sumUsageRollups(startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (*UsageStat, error) {
+	query := &UsageQuery{
+		Granularity:    RollupGranularityHour,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		ChannelId:      channel,
 	}
-	tx := LOG_DB.Table("logs").Select(fmt.Sprintf("%s(sum(prompt_tokens),0) + %s(sum(completion_tokens),0)", ifnull, ifnull))
 	if username != "" {
-		tx = tx.Where("username = ?", username)
+		err := DB.Model(&User{}).Where("username = ?", username).Select("id").Find(&query.UserId).Error
+		if err != nil || query.UserId == 0 {
+			return &UsageStat{}, err
+		}
 	}
 	if tokenName != "" {
-		tx = tx.Where("token_name = ?", tokenName)
-	}
-	if startTimestamp != 0 {
-		tx = tx.Where("created_at >= ?", startTimestamp)
-	}
-	if endTimestamp != 0 {
-		tx = tx.Where("created_at <= ?", endTimestamp)
-	}
-	if modelName != "" {
-		tx = tx.Where("model_name = ?", modelName)
-	}
-	tx.Where("type = ?", LogTypeConsume).Scan(&token)
-	return token
+		tx := DB.Model(&Token{}).Where("name = ?", tokenName)
+		if query.UserId != 0 {
+			tx = tx.Where("user_id = ?", query.UserId)
+		}
+		err := tx.Pluck("id", &query.TokenIds).Error
+		if err != nil || len(query.TokenIds) == 0 {
+			return &UsageStat{}, err
+		}
+	}
+	stats, err := GetUsageStats(query)
+	if err != nil || len(stats) == 0 {
+		return &UsageStat{}, err
+	}
+	return stats[0], nil
 }
 
 func DeleteOldLog(targetTimestamp int64) (int64, error) {
-	result := LOG_DB.Where("created_at < ?", targetTimestamp).Delete(&Log{})
-	return result.RowsAffected, result.Error
+	return getLogStore().DeleteBefore(targetTimestamp)
 }
 
 type LogStatistic struct {
@@ -222,30 +271,151 @@ type LogStatistic struct {
 	CompletionTokens int    `gorm:"column:completion_tokens"`
 }
 
+// SearchLogsByDayAndModel reads the daily usage rollups, so the days are in the timezone of USAGE_ROLLUP_TIMEZONE.
+// The current day is read from the logs since its rollups lag by the flush interval.
 func SearchLogsByDayAndModel(userId, start, end int) (LogStatistics []*LogStatistic, err error) {
-	groupSelect := "DATE_FORMAT(FROM_UNIXTIME(created_at), '%Y-%m-%d') as day"
+	today := getRollupDay(helper.GetTimestamp())
+	if int64(start) < today {
+		rollupEnd := today - 1
+		if end != 0 && int64(end) < rollupEnd {
+			rollupEnd = int64(end)
+		}
+		stats, err := GetUsageStats(&UsageQuery{
+			Granularity:    RollupGranularityDay,
+			StartTimestamp: int64(start),
+			EndTimestamp:   rollupEnd,
+			GroupBy:        []string{"model"},
+			Series:         true,
+			UserId:         userId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, stat := range stats {
+			// only errors were logged
+			if stat.RequestCount == 0 {
+				continue
+			}
+			LogStatistics = append(LogStatistics, &LogStatistic{
+				Day:              time.Unix(stat.Bucket, 0).In(rollupLocation).Format("2006-01-02"),
+				ModelName:        stat.ModelName,
+				RequestCount:     int(stat.RequestCount),
+				Quota:            int(stat.Quota),
+				PromptTokens:     int(stat.PromptTokens),
+				CompletionTokens: int(stat.CompletionTokens),
+			})
+		}
+	}
+	if end != 0 && int64(end) < today {
+		return LogStatistics, nil
+	}
+	if int64(start) < today {
+		start = int(today)
+	}
+	sums, err := getLogStore().Sum(&LogFilter{UserId: userId, Type: LogTypeConsume, StartTimestamp: int64(start), EndTimestamp: int64(end)})
+	if err != nil {
+		return nil, err
+	}
+	for _, sum := range sums {
+		LogStatistics = append(LogStatistics, &LogStatistic{
+			Day:              time.Unix(today, 0).In(rollupLocation).Format("2006-01-02"),
+			ModelName:        sum.ModelName,
+			RequestCount:     int(sum.RequestCount),
+			Quota:            int(sum.Quota),
+			PromptTokens:     int(sum.PromptTokens),
+			CompletionTokens: int(sum.CompletionTokens),
+		})
+	}
+	return LogStatistics, nil
+}
 
-	if common.UsingPostgreSQL {
-		groupSelect = "TO_CHAR(date_trunc('day', to_timestamp(created_at)), 'YYYY-MM-DD') as day"
-	}
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
 
-	if common.UsingSQLite {
-		groupSelect = "strftime('%Y-%m-%d', datetime(created_at, 'unixepoch')) as day"
-	}
+type LatencyStat struct {
+	ChannelId       int                `json:"channel"`
+	ModelName       string             `json:"model_name"`
+	Count           int                `json:"count"`
+	ElapsedTime     LatencyPercentiles `json:"elapsed_time"`
+	FirstTokenTime  LatencyPercentiles `json:"first_token_time"`
+	UpstreamTime    LatencyPercentiles `json:"upstream_time"`
+	TokensPerSecond LatencyPercentiles `json:"tokens_per_second"`
+}
 
-	err = LOG_DB.Raw(`
-		SELECT `+groupSelect+`,
-		model_name, count(1) as request_count,
-		sum(quota) as quota,
-		sum(prompt_tokens) as prompt_tokens,
-		sum(completion_tokens) as completion_tokens
-		FROM logs
-		WHERE type=2
-		AND user_id= ?
-		AND created_at BETWEEN ? AND ?
-		GROUP BY day, model_name
-		ORDER BY day, model_name
-	`, userId, start, end).Scan(&LogStatistics).Error
+// GetLatencyStats computes the percentiles of the newest LatencyStatsMaxLogs consume logs by channel and model,
+// it's done in memory since percentile functions differ between databases.
+func GetLatencyStats(startTimestamp int64, endTimestamp int64, modelName string, channel int) ([]*LatencyStat, error) {
+	logs, err := getLogStore().FindLatencies(&LogFilter{
+		Type:           LogTypeConsume,
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+		ModelName:      modelName,
+		ChannelId:      channel,
+	}, config.LatencyStatsMaxLogs)
+	if err != nil {
+		return nil, err
+	}
+	type group struct {
+		channelId       int
+		modelName       string
+		elapsedTime     []float64
+		firstTokenTime  []float64
+		upstreamTime    []float64
+		tokensPerSecond []float64
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, log := range logs {
+		key := fmt.Sprintf("%d:%s", log.ChannelId, log.ModelName)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{channelId: log.ChannelId, modelName: log.ModelName}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.elapsedTime = append(g.elapsedTime, float64(log.ElapsedTime))
+		// zero means not measured, e.g. logs written before these fields existed
+		if log.FirstTokenTime > 0 {
+			g.firstTokenTime = append(g.firstTokenTime, float64(log.FirstTokenTime))
+		}
+		if log.UpstreamTime > 0 {
+			g.upstreamTime = append(g.upstreamTime, float64(log.UpstreamTime))
+		}
+		if log.TokensPerSecond > 0 {
+			g.tokensPerSecond = append(g.tokensPerSecond, log.TokensPerSecond)
+		}
+	}
+	stats := make([]*LatencyStat, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		stats = append(stats, &LatencyStat{
+			ChannelId:       g.channelId,
+			ModelName:       g.modelName,
+			Count:           len(g.elapsedTime),
+			ElapsedTime:     getPercentiles(g.elapsedTime),
+			FirstTokenTime:  getPercentiles(g.firstTokenTime),
+			UpstreamTime:    getPercentiles(g.upstreamTime),
+			TokensPerSecond: getPercentiles(g.tokensPerSecond),
+		})
+	}
+	return stats, nil
+}
 
-	return LogStatistics, err
+func getPercentiles(values []float64) LatencyPercentiles {
+	if len(values) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Float64s(values)
+	percentile := func(p float64) float64 {
+		// nearest rank
+		rank := int(math.Ceil(p*float64(len(values)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return values[rank]
+	}
+	return LatencyPercentiles{P50: percentile(0.5), P95: percentile(0.95), P99: percentile(0.99)}
 }
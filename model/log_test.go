@@ -0,0 +1,84 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestRecordErrorLog(t *testing.T) {
+	Convey("TestRecordErrorLog", t, func() {
+		UseTestDB(t, &User{}, &Log{})
+		So(DB.Create(&User{Id: 1, Username: "alice", Group: "default"}).Error, ShouldBeNil)
+		ctx := context.Background()
+
+		RecordConsumeLog(ctx, &Log{UserId: 1, ModelName: "gpt-4o", Quota: 10, RetryChain: "3,5"})
+		RecordErrorLog(ctx, &Log{UserId: 1, ModelName: "gpt-4o", Content: "upstream error", StatusCode: 502, ErrorCode: "bad_gateway", RetryChain: "3,5,7"})
+
+		Convey("stores the status, the error code and the retry chain", func() {
+			logs, err := GetAllLogs(LogTypeError, 0, 0, "", "", "", 0, 10, 0)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Username, ShouldEqual, "alice")
+			So(logs[0].StatusCode, ShouldEqual, 502)
+			So(logs[0].ErrorCode, ShouldEqual, "bad_gateway")
+			So(logs[0].RetryChain, ShouldEqual, "3,5,7")
+			So(logs[0].CreatedAt, ShouldBeGreaterThan, 0)
+		})
+
+		Convey("filters the logs of a user by type", func() {
+			logs, err := GetUserLogs(1, LogTypeConsume, 0, 0, "", "", 0, 10)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].RetryChain, ShouldEqual, "3,5")
+			logs, err = GetUserLogs(1, LogTypeError, 0, 0, "", "", 0, 10)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Content, ShouldEqual, "upstream error")
+		})
+
+		Convey("is skipped when error logs are disabled", func() {
+			config.LogErrorEnabled = false
+			defer func() { config.LogErrorEnabled = true }()
+			RecordErrorLog(ctx, &Log{UserId: 1, Content: "ignored"})
+			logs, err := GetAllLogs(LogTypeError, 0, 0, "", "", "", 0, 10, 0)
+			So(err, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+		})
+	})
+}
+
+func TestGetLatencyStats(t *testing.T) {
+	Convey("TestGetLatencyStats", t, func() {
+		UseTestDB(t, &Log{})
+		previousMaxLogs := config.LatencyStatsMaxLogs
+		config.LatencyStatsMaxLogs = 4
+		defer func() { config.LatencyStatsMaxLogs = previousMaxLogs }()
+
+		logs := []*Log{
+			// the oldest log is beyond LatencyStatsMaxLogs
+			{Type: LogTypeConsume, CreatedAt: 1, ChannelId: 1, ModelName: "gpt-4o", ElapsedTime: 9000, UpstreamTime: 9000},
+			{Type: LogTypeConsume, CreatedAt: 2, ChannelId: 1, ModelName: "gpt-4o", ElapsedTime: 100, UpstreamTime: 90, FirstTokenTime: 50, TokensPerSecond: 20},
+			{Type: LogTypeConsume, CreatedAt: 3, ChannelId: 1, ModelName: "gpt-4o", ElapsedTime: 200, UpstreamTime: 190},
+			{Type: LogTypeError, CreatedAt: 4, ChannelId: 1, ModelName: "gpt-4o", ElapsedTime: 5000},
+			{Type: LogTypeConsume, CreatedAt: 5, ChannelId: 1, ModelName: "gpt-4o", ElapsedTime: 300, UpstreamTime: 290, FirstTokenTime: 70, TokensPerSecond: 40},
+			{Type: LogTypeConsume, CreatedAt: 6, ChannelId: 2, ModelName: "gpt-4o", ElapsedTime: 400, UpstreamTime: 390},
+		}
+		So(LOG_DB.Create(logs).Error, ShouldBeNil)
+
+		stats, err := GetLatencyStats(0, 0, "", 0)
+		So(err, ShouldBeNil)
+		So(len(stats), ShouldEqual, 2)
+		So(stats[0].ChannelId, ShouldEqual, 2)
+		So(stats[0].Count, ShouldEqual, 1)
+		So(stats[1].ChannelId, ShouldEqual, 1)
+		So(stats[1].Count, ShouldEqual, 3)
+		So(stats[1].ElapsedTime, ShouldResemble, LatencyPercentiles{P50: 200, P95: 300, P99: 300})
+		// zero means not measured
+		So(stats[1].FirstTokenTime, ShouldResemble, LatencyPercentiles{P50: 50, P95: 70, P99: 70})
+		So(stats[1].TokensPerSecond.P50, ShouldEqual, 20)
+	})
+}
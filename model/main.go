@@ -45,6 +45,9 @@ func CreateRootAccountIfNeed() error {
 			Quota:       500000000000000,
 		}
 		DB.Create(&rootUser)
+		if err = RecordLedger(rootUser.Id, rootUser.Quota, LedgerSource{Type: LedgerTypeOpening}); err != nil {
+			return err
+		}
 		if config.InitialRootToken != "" {
 			logger.SysLog("creating initial root token as requested")
 			token := Token{
@@ -59,7 +62,9 @@ func CreateRootAccountIfNeed() error {
 				RemainQuota:    500000000000000,
 				UnlimitedQuota: true,
 			}
-			DB.Create(&token)
+			if err := token.Insert(); err != nil {
+				logger.SysError("failed to create initial root token: " + err.Error())
+			}
 		}
 	}
 	return nil
@@ -150,6 +155,9 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Token{}); err != nil {
 		return err
 	}
+	if err = migrateTokenKeys(); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&User{}); err != nil {
 		return err
 	}
@@ -165,12 +173,36 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&PayloadLog{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&LogArchive{}); err != nil {
+		return err
+	}
+	if err = migrateUsageRollups(DB); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&PriceVersion{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&PaymentRecord{}); err != nil {
 		return err
 	}
 	if err = DB.AutoMigrate(&TopUpCode{}); err != nil {
 		return err
 	}
+	if err = migrateLedger(); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&QuotaReservation{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&SubscriptionPlan{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Subscription{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&Channel{}); err != nil {
 		return err
 	}
@@ -211,6 +243,15 @@ func migrateLOGDB() error {
 	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
 		return err
 	}
+	if err = LOG_DB.AutoMigrate(&PayloadLog{}); err != nil {
+		return err
+	}
+	if err = LOG_DB.AutoMigrate(&LogArchive{}); err != nil {
+		return err
+	}
+	if err = migrateUsageRollups(LOG_DB); err != nil {
+		return err
+	}
 	return nil
 }
 
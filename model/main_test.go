@@ -0,0 +1,16 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMigrateDB(t *testing.T) {
+	Convey("TestMigrateDB", t, func() {
+		UseTestDB(t)
+		So(migrateDB(), ShouldBeNil)
+		// migrating an up-to-date database is a no-op
+		So(migrateDB(), ShouldBeNil)
+	})
+}
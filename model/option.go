@@ -1,9 +1,13 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/guardrail"
 	"strconv"
 	"strings"
 	"time"
@@ -36,6 +40,7 @@ func InitOptionMap() {
 	config.OptionMap["AutomaticEnableChannelEnabled"] = strconv.FormatBool(config.AutomaticEnableChannelEnabled)
 	config.OptionMap["ApproximateTokenEnabled"] = strconv.FormatBool(config.ApproximateTokenEnabled)
 	config.OptionMap["LogConsumeEnabled"] = strconv.FormatBool(config.LogConsumeEnabled)
+	config.OptionMap["LogErrorEnabled"] = strconv.FormatBool(config.LogErrorEnabled)
 	config.OptionMap["DisplayInCurrencyEnabled"] = strconv.FormatBool(config.DisplayInCurrencyEnabled)
 	config.OptionMap["DisplayTokenStatEnabled"] = strconv.FormatBool(config.DisplayTokenStatEnabled)
 	config.OptionMap["ChannelDisableThreshold"] = strconv.FormatFloat(config.ChannelDisableThreshold, 'f', -1, 64)
@@ -70,26 +75,64 @@ func InitOptionMap() {
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
+	config.OptionMap["CacheReadRatio"] = billingratio.CacheReadRatio2JSONString()
+	config.OptionMap["CacheWriteRatio"] = billingratio.CacheWriteRatio2JSONString()
+	config.OptionMap["ModelPrices"] = billingratio.ModelPrices2JSONString()
+	config.OptionMap["ModelsPricing"] = billingratio.ModelsPricing2JSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
 	config.OptionMap["RetryTimes"] = strconv.Itoa(config.RetryTimes)
+	config.OptionMap["ChannelRoutingStrategy"] = config.ChannelRoutingStrategy
 	config.OptionMap["Theme"] = config.Theme
+	config.OptionMap["GuardrailRules"] = guardrail.Rules2JSONString()
+	config.OptionMap["PayloadLogSampling"] = PayloadLogSampling2JSONString()
+	config.OptionMap["LogLevels"] = logger.LogLevels2JSONString()
+	config.OptionMap["LogRetentionPolicies"] = LogRetentionPolicies2JSONString()
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
 
 func loadOptionsFromDatabase() {
 	options, _ := AllOption()
+	modelRatio := ""
+	hasModelPrices := false
 	for _, option := range options {
-		if option.Key == "ModelRatio" {
+		switch option.Key {
+		case "ModelRatio":
+			modelRatio = option.Value
 			option.Value = billingratio.AddNewMissingRatio(option.Value)
+		case "ModelPrices":
+			hasModelPrices = true
 		}
 		err := updateOptionMap(option.Key, option.Value)
 		if err != nil {
 			logger.SysError("failed to update option map: " + err.Error())
 		}
 	}
+	if modelRatio != "" && !hasModelPrices {
+		migrateModelRatioToPrices(modelRatio)
+	}
+}
+
+// migrateModelRatioToPrices converts the customized model ratios into the price catalog once
+func migrateModelRatioToPrices(modelRatio string) {
+	prices, err := billingratio.ConvertRatiosToPrices(modelRatio)
+	if err != nil {
+		logger.SysError("failed to convert model ratio to prices: " + err.Error())
+		return
+	}
+	jsonBytes, err := json.Marshal(prices)
+	if err != nil {
+		logger.SysError("failed to marshal model prices: " + err.Error())
+		return
+	}
+	err = UpdateOption("ModelPrices", string(jsonBytes))
+	if err != nil {
+		logger.SysError("failed to save model prices: " + err.Error())
+		return
+	}
+	logger.SysLog(fmt.Sprintf("migrated %d model ratios into the price catalog", len(prices)))
 }
 
 func SyncOptions(frequency int) {
@@ -149,6 +192,8 @@ func updateOptionMap(key string, value string) (err error) {
 			config.ApproximateTokenEnabled = boolValue
 		case "LogConsumeEnabled":
 			config.LogConsumeEnabled = boolValue
+		case "LogErrorEnabled":
+			config.LogErrorEnabled = boolValue
 		case "DisplayInCurrencyEnabled":
 			config.DisplayInCurrencyEnabled = boolValue
 		case "DisplayTokenStatEnabled":
@@ -229,6 +274,22 @@ func updateOptionMap(key string, value string) (err error) {
 		err = billingratio.UpdateGroupRatioByJSONString(value)
 	case "CompletionRatio":
 		err = billingratio.UpdateCompletionRatioByJSONString(value)
+	case "CacheReadRatio":
+		err = billingratio.UpdateCacheReadRatioByJSONString(value)
+	case "CacheWriteRatio":
+		err = billingratio.UpdateCacheWriteRatioByJSONString(value)
+	case "ModelPrices":
+		err = billingratio.UpdateModelPricesByJSONString(value)
+	case "ModelsPricing":
+		err = billingratio.UpdateModelsPricingByJSONString(value)
+	case "GuardrailRules":
+		err = guardrail.UpdateRulesByJSONString(value)
+	case "PayloadLogSampling":
+		err = UpdatePayloadLogSamplingByJSONString(value)
+	case "LogLevels":
+		err = logger.UpdateLogLevelsByJSONString(value)
+	case "LogRetentionPolicies":
+		err = UpdateLogRetentionPoliciesByJSONString(value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
@@ -239,6 +300,11 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
 	case "Theme":
 		config.Theme = value
+	case "ChannelRoutingStrategy":
+		if value != config.ChannelRoutingRandom && value != config.ChannelRoutingCheapest {
+			return fmt.Errorf("unsupported channel routing strategy %s", value)
+		}
+		config.ChannelRoutingStrategy = value
 	}
 	return err
 }
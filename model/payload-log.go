@@ -0,0 +1,126 @@
+package model
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// PayloadLog keeps the bodies of a sampled relay request, for debugging customer issues.
+type PayloadLog struct {
+	Id                int    `json:"id"`
+	RequestId         string `json:"request_id" gorm:"index;default:''"`
+	CreatedAt         int64  `json:"created_at" gorm:"bigint;index"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	TokenId           int    `json:"token_id" gorm:"default:0"`
+	ChannelId         int    `json:"channel" gorm:"default:0"`
+	ModelName         string `json:"model_name" gorm:"default:''"`
+	RetryChain        string `json:"retry_chain" gorm:"type:text"` // JSON list of failed attempts
+	RequestBody       string `json:"request_body" gorm:"type:text"`
+	RequestTruncated  bool   `json:"request_truncated" gorm:"default:false"`
+	ResponseBody      string `json:"response_body" gorm:"type:text"`
+	ResponseTruncated bool   `json:"response_truncated" gorm:"default:false"`
+	StatusCode        int    `json:"status_code" gorm:"default:0"`
+	IsStream          bool   `json:"is_stream" gorm:"default:false"`
+}
+
+func RecordPayloadLog(log *PayloadLog) error {
+	log.CreatedAt = helper.GetTimestamp()
+	return LOG_DB.Create(log).Error
+}
+
+func GetPayloadLogByRequestId(requestId string) (*PayloadLog, error) {
+	var log PayloadLog
+	err := LOG_DB.Where("request_id = ?", requestId).Order("id desc").First(&log).Error
+	return &log, err
+}
+
+func GetUserPayloadLogByRequestId(userId int, requestId string) (*PayloadLog, error) {
+	var log PayloadLog
+	err := LOG_DB.Where("user_id = ? and request_id = ?", userId, requestId).Order("id desc").First(&log).Error
+	return &log, err
+}
+
+const payloadLogDeleteBatchSize = 1000
+
+// DeletePayloadLogsBefore deletes in batches, so that the large bodies never lock the table for long.
+func DeletePayloadLogsBefore(timestamp int64) (int64, error) {
+	var total int64
+	for {
+		var ids []int
+		err := LOG_DB.Model(&PayloadLog{}).Where("created_at < ?", timestamp).Order("id").Limit(payloadLogDeleteBatchSize).Pluck("id", &ids).Error
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		result := LOG_DB.Where("id IN ?", ids).Delete(&PayloadLog{})
+		total += result.RowsAffected
+		if result.Error != nil {
+			return total, result.Error
+		}
+		if len(ids) < payloadLogDeleteBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// CleanPayloadLogs deletes payload logs older than the retention period.
+func CleanPayloadLogs(frequency int) {
+	for {
+		if config.PayloadLogRetentionDays > 0 {
+			count, err := DeletePayloadLogsBefore(helper.GetTimestamp() - int64(config.PayloadLogRetentionDays)*24*60*60)
+			if err != nil {
+				logger.SysError("failed to clean payload logs: " + err.Error())
+			} else if count > 0 {
+				logger.SysLogf("cleaned %d expired payload logs", count)
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
+
+// PayloadLogSampling holds the sampling rates between 0 and 1, a token rate takes precedence over its group.
+type PayloadLogSampling struct {
+	Groups map[string]float64 `json:"groups,omitempty"`
+	Tokens map[int]float64    `json:"tokens,omitempty"`
+}
+
+var payloadLogSamplingLock sync.RWMutex
+var payloadLogSampling = PayloadLogSampling{}
+
+func PayloadLogSampling2JSONString() string {
+	payloadLogSamplingLock.RLock()
+	defer payloadLogSamplingLock.RUnlock()
+	jsonBytes, err := json.Marshal(payloadLogSampling)
+	if err != nil {
+		logger.SysError("error marshalling payload log sampling: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdatePayloadLogSamplingByJSONString(jsonStr string) error {
+	newSampling := PayloadLogSampling{}
+	err := json.Unmarshal([]byte(jsonStr), &newSampling)
+	if err != nil {
+		return err
+	}
+	payloadLogSamplingLock.Lock()
+	defer payloadLogSamplingLock.Unlock()
+	payloadLogSampling = newSampling
+	return nil
+}
+
+func GetPayloadLogSampleRate(group string, tokenId int) float64 {
+	payloadLogSamplingLock.RLock()
+	defer payloadLogSamplingLock.RUnlock()
+	if rate, ok := payloadLogSampling.Tokens[tokenId]; ok {
+		return rate
+	}
+	return payloadLogSampling.Groups[group]
+}
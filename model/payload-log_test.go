@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeletePayloadLogsBefore(t *testing.T) {
+	Convey("TestDeletePayloadLogsBefore", t, func() {
+		UseTestDB(t, &PayloadLog{})
+		var logs []*PayloadLog
+		for i := 0; i < payloadLogDeleteBatchSize*2+10; i++ {
+			logs = append(logs, &PayloadLog{CreatedAt: 100, RequestBody: "body"})
+		}
+		logs = append(logs, &PayloadLog{CreatedAt: 300, RequestBody: "kept"})
+		So(LOG_DB.CreateInBatches(logs, 500).Error, ShouldBeNil)
+
+		count, err := DeletePayloadLogsBefore(200)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, payloadLogDeleteBatchSize*2+10)
+
+		var remaining []PayloadLog
+		So(LOG_DB.Find(&remaining).Error, ShouldBeNil)
+		So(len(remaining), ShouldEqual, 1)
+		So(remaining[0].RequestBody, ShouldEqual, "kept")
+	})
+}
@@ -19,6 +19,7 @@ type PaymentRecord struct {
 	Status        string    `json:"status"`                                              // 支付状态: pending, processing, success, failed
 	OrderID       string    `json:"order_id" gorm:"uniqueIndex:idx_order_id,length:100"` // 订单ID
 	TopUpCode     string    `json:"top_up_code"`                                         // 充值码
+	PlanId        int       `json:"plan_id" gorm:"default:0"`                            // 订阅套餐ID，为0时为额度充值
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
@@ -106,9 +107,11 @@ func GetUserPaymentRecords(userID int, page, pageSize int) ([]PaymentRecord, int
 
 // 处理支付成功
 func HandlePaymentSuccess(orderID string) error {
-	return DB.Transaction(func(tx *gorm.DB) error {
+	var paymentRecord PaymentRecord
+	subscribed := false
+	var subscriptionLog string
+	err := DB.Transaction(func(tx *gorm.DB) error {
 		// 获取支付记录
-		var paymentRecord PaymentRecord
 		err := tx.Where("order_id = ?", orderID).First(&paymentRecord).Error
 		if err != nil {
 			return err
@@ -130,26 +133,67 @@ func HandlePaymentSuccess(orderID string) error {
 			return err
 		}
 
-		// 给用户充值
-		err = IncreaseUserQuota(paymentRecord.UserID, int64(paymentRecord.Amount))
+		// 订阅套餐
+		if paymentRecord.PlanId != 0 {
+			subscribed, subscriptionLog, err = subscribeByPayment(tx, &paymentRecord)
+			if err != nil {
+				return err
+			}
+			if subscribed {
+				return tx.Model(&paymentRecord).Update("status", "success").Error
+			}
+		}
+
+		// 给用户充值，与订单状态在同一事务中提交，失败时一起回滚
+		amount := int64(paymentRecord.Amount)
+		err = tx.Model(&User{}).Where("id = ?", paymentRecord.UserID).Update("quota", gorm.Expr("quota + ?", amount)).Error
 		if err != nil {
-			// 充值失败，回滚状态
-			tx.Model(&paymentRecord).Update("status", "pending")
 			return err
 		}
-
-		// 更新为成功状态
-		err = tx.Model(&paymentRecord).Update("status", "success").Error
+		err = recordLedger(tx, paymentRecord.UserID, amount, LedgerSource{
+			Type:      LedgerTypeTopup,
+			Reference: orderID,
+		})
 		if err != nil {
 			return err
 		}
 
-		// 记录充值日志
+		// 更新为成功状态
+		return tx.Model(&paymentRecord).Update("status", "success").Error
+	})
+	if err != nil {
+		return err
+	}
+
+	// 事务提交后再记录日志，回滚的支付不会留下日志
+	if subscriptionLog != "" {
+		RecordLog(context.TODO(), paymentRecord.UserID, LogTypeTopup, subscriptionLog)
+	}
+	if !subscribed {
 		RecordTopupLog(context.TODO(), paymentRecord.UserID,
 			fmt.Sprintf("在线支付充值 %s", common.LogQuota(int64(paymentRecord.Amount))), int(paymentRecord.Amount))
+	}
+	return nil
+}
 
-		return nil
-	})
+// 开通或续订支付的套餐，套餐无法开通时（已下架或已有其他套餐）返回 false，由调用方将支付金额充值为余额。
+// 返回的日志内容由调用方在事务提交后记录
+func subscribeByPayment(tx *gorm.DB, paymentRecord *PaymentRecord) (bool, string, error) {
+	plan := &SubscriptionPlan{}
+	err := tx.First(plan, "id = ?", paymentRecord.PlanId).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, "", err
+	}
+	if err == nil {
+		subscription, err := subscribe(tx, paymentRecord.UserID, plan)
+		if err == nil {
+			return true, fmt.Sprintf("在线支付订阅套餐 %s，有效期至 %s", plan.Name, time.Unix(subscription.PaidUntil, 0).Format("2006-01-02 15:04:05")), nil
+		}
+		if !errors.Is(err, ErrSubscriptionConflict) && plan.Status == SubscriptionPlanStatusEnabled {
+			return false, "", err
+		}
+	}
+	return false, fmt.Sprintf("订阅套餐 %d 无法开通，支付金额转为余额", paymentRecord.PlanId), nil
 }
 
 // 处理支付失败
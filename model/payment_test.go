@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTopupPayment(t *testing.T) {
+	Convey("TestTopupPayment", t, func() {
+		UseTestDB(t, &User{}, &PaymentRecord{}, &LedgerEntry{}, &Log{})
+		user := &User{Username: "alice", Password: "password", Quota: 1000, Status: UserStatusEnabled, AffCode: "a1", AccessToken: "t1"}
+		So(DB.Create(user).Error, ShouldBeNil)
+		So(RecordLedger(user.Id, 1000, LedgerSource{Type: LedgerTypeOpening}), ShouldBeNil)
+		So(DB.Create(&PaymentRecord{UserID: user.Id, Amount: 500, Status: "pending", OrderID: "order-1"}).Error, ShouldBeNil)
+
+		// credited in the transaction of the order, the test database has a single connection
+		So(HandlePaymentSuccess("order-1"), ShouldBeNil)
+		quota, err := GetUserQuota(user.Id)
+		So(err, ShouldBeNil)
+		So(quota, ShouldEqual, 1500)
+		entries, err := GetLedgerEntries(&LedgerFilter{UserId: user.Id, Type: LedgerTypeTopup}, 0, 10)
+		So(err, ShouldBeNil)
+		So(len(entries), ShouldEqual, 1)
+		So(entries[0].Reference, ShouldEqual, "order-1")
+		report, err := ReconcileLedger()
+		So(err, ShouldBeNil)
+		So(report.Drifts, ShouldBeEmpty)
+
+		So(HandlePaymentSuccess("order-1").Error(), ShouldEqual, "订单已处理")
+		quota, _ = GetUserQuota(user.Id)
+		So(quota, ShouldEqual, 1500)
+	})
+}
@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// PriceVersion is a snapshot of the price catalog, the latest one whose EffectiveAt has passed is in effect.
+type PriceVersion struct {
+	Id          int    `json:"id"`
+	EffectiveAt int64  `json:"effective_at" gorm:"bigint;index"`
+	Prices      string `json:"prices" gorm:"type:text"`
+	Comment     string `json:"comment" gorm:"default:''"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+}
+
+func GetPriceVersions(startIdx int, num int) ([]*PriceVersion, error) {
+	var versions []*PriceVersion
+	err := DB.Order("effective_at desc").Limit(num).Offset(startIdx).Omit("prices").Find(&versions).Error
+	return versions, err
+}
+
+func GetPriceVersionById(id int) (*PriceVersion, error) {
+	version := PriceVersion{}
+	err := DB.First(&version, "id = ?", id).Error
+	return &version, err
+}
+
+// CreatePriceVersion validates the catalog and schedules it, an EffectiveAt of 0 takes effect now.
+func CreatePriceVersion(version *PriceVersion) error {
+	if _, err := billingratio.ParseModelPrices(version.Prices); err != nil {
+		return err
+	}
+	now := helper.GetTimestamp()
+	version.Id = 0
+	version.CreatedAt = now
+	if version.EffectiveAt == 0 {
+		version.EffectiveAt = now
+	}
+	if version.EffectiveAt < now {
+		return errors.New("生效时间不能早于当前时间")
+	}
+	if err := DB.Create(version).Error; err != nil {
+		return err
+	}
+	return LoadPriceVersions()
+}
+
+// DeletePriceVersion cancels a scheduled version, the versions in effect are kept to explain past bills.
+func DeletePriceVersion(id int) error {
+	version, err := GetPriceVersionById(id)
+	if err != nil {
+		return err
+	}
+	if version.EffectiveAt <= helper.GetTimestamp() {
+		return errors.New("已生效的价格版本不能删除")
+	}
+	if err = DB.Delete(version).Error; err != nil {
+		return err
+	}
+	return LoadPriceVersions()
+}
+
+// LoadPriceVersions loads the version in effect and the scheduled ones into the catalog.
+func LoadPriceVersions() error {
+	now := helper.GetTimestamp()
+	var versions []*PriceVersion
+	err := DB.Where("effective_at > ?", now).Find(&versions).Error
+	if err != nil {
+		return err
+	}
+	var active PriceVersion
+	err = DB.Where("effective_at <= ?", now).Order("effective_at desc, id desc").Limit(1).Find(&active).Error
+	if err != nil {
+		return err
+	}
+	if active.Id != 0 {
+		versions = append(versions, &active)
+	}
+	snapshots := make([]billingratio.PriceVersion, 0, len(versions))
+	for _, version := range versions {
+		prices, err := billingratio.ParseModelPrices(version.Prices)
+		if err != nil {
+			logger.SysError("invalid prices of price version: " + err.Error())
+			continue
+		}
+		snapshots = append(snapshots, billingratio.PriceVersion{
+			Id:          version.Id,
+			EffectiveAt: version.EffectiveAt,
+			Prices:      prices,
+		})
+	}
+	billingratio.SetPriceVersions(snapshots)
+	return nil
+}
+
+func SyncPriceVersions(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		logger.SysLog("syncing price versions from database")
+		if err := LoadPriceVersions(); err != nil {
+			logger.SysError("failed to sync price versions: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	QuotaReservationStatusReserved = 1 // don't use 0, 0 is the default value!
+	QuotaReservationStatusSettled  = 2
+	QuotaReservationStatusReleased = 3
+	QuotaReservationStatusExpired  = 4
+)
+
+var (
+	ErrInsufficientUserQuota  = errors.New("用户额度不足")
+	ErrInsufficientTokenQuota = errors.New("令牌额度不足")
+
+	ErrInsufficientEphemeralTokenQuota = errors.New("临时令牌额度不足")
+)
+
+// QuotaReservation holds the estimated quota of a request from the user and the token until the request is settled,
+// the reservations not settled before ExpiresAt are given back by ReclaimQuotaReservations.
+type QuotaReservation struct {
+	Id             string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	TokenId        int    `json:"token_id"`
+	TokenUnlimited bool   `json:"token_unlimited"`
+	RequestId      string `json:"request_id" gorm:"type:varchar(64);default:''"`
+	Quota          int64  `json:"quota" gorm:"bigint"`
+	SettledQuota   int64  `json:"settled_quota" gorm:"bigint;default:0"`
+	Status         int    `json:"status" gorm:"index:idx_reservation_status_expires,priority:1"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt      int64  `json:"expires_at" gorm:"bigint;index:idx_reservation_status_expires,priority:2"`
+	SettledAt      int64  `json:"settled_at" gorm:"bigint;default:0"`
+	// the part of Quota taken from the subscription allowance, the rest is taken from the balance of the user
+	SubscriptionId  int   `json:"subscription_id" gorm:"default:0"`
+	AllowancePeriod int64 `json:"allowance_period" gorm:"bigint;default:0"`
+	AllowanceQuota  int64 `json:"allowance_quota" gorm:"bigint;default:0"`
+	// the spend of the ephemeral token authorizing the request is kept in Redis or in memory, not in the database
+	EphemeralTokenId        string `json:"ephemeral_token_id" gorm:"type:varchar(64);default:''"`
+	EphemeralTokenExpiresAt int64  `json:"ephemeral_token_expires_at" gorm:"bigint;default:0"`
+}
+
+// ReserveQuota takes the quota from the user and the token at once, it fails without changing anything
+// when either of them has not enough quota. The allowance of the subscription of the user is used first,
+// only the overage is taken from the balance. A zero quota is checked against the balances but not stored.
+// Whether the token is unlimited comes from the token loaded by the auth middleware. The quota is also reserved
+// against the limit of the ephemeral token authorizing the request, if any.
+func ReserveQuota(ctx context.Context, userId int, tokenId int, tokenUnlimited bool, ephemeral *EphemeralTokenLimit, quota int64) (*QuotaReservation, error) {
+	if quota < 0 {
+		return nil, errors.New("quota 不能为负数！")
+	}
+	now := helper.GetTimestamp()
+	reservation := &QuotaReservation{
+		UserId:         userId,
+		TokenId:        tokenId,
+		TokenUnlimited: tokenUnlimited,
+		RequestId:      helper.GetRequestID(ctx),
+		Quota:          quota,
+		Status:         QuotaReservationStatusReserved,
+		CreatedAt:      now,
+		ExpiresAt:      now + int64(config.QuotaReservationTimeout),
+	}
+	if ephemeral != nil {
+		reservation.EphemeralTokenId = ephemeral.Id
+		reservation.EphemeralTokenExpiresAt = ephemeral.ExpiresAt
+	}
+	if quota == 0 {
+		userQuota, err := CacheGetUserQuota(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		if userQuota < 0 {
+			return nil, ErrInsufficientUserQuota
+		}
+		if ephemeral != nil && ephemeral.Quota > 0 && GetEphemeralTokenUsedQuota(ephemeral.Id) >= ephemeral.Quota {
+			return nil, ErrInsufficientEphemeralTokenQuota
+		}
+		return reservation, nil
+	}
+	reservation.Id = random.GetUUID()
+	if ephemeral != nil {
+		if err := increaseEphemeralTokenUsedQuota(ephemeral.Id, ephemeral.ExpiresAt, quota, ephemeral.Quota); err != nil {
+			return nil, err
+		}
+	}
+	var balanceQuota, userQuota int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		subscription, allowance, err := takeSubscriptionAllowance(tx, userId, quota)
+		if err != nil {
+			return err
+		}
+		if subscription != nil {
+			reservation.SubscriptionId = subscription.Id
+			reservation.AllowancePeriod = subscription.CurrentPeriodStart
+			reservation.AllowanceQuota = allowance
+		}
+		balanceQuota = quota - allowance
+		if balanceQuota > 0 {
+			result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, balanceQuota).Update("quota", gorm.Expr("quota - ?", balanceQuota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInsufficientUserQuota
+			}
+			// read here for the quota reminder, so that it doesn't query the database again
+			if err := tx.Model(&User{}).Where("id = ?", userId).Select("quota").Scan(&userQuota).Error; err != nil {
+				return err
+			}
+		}
+		if !tokenUnlimited {
+			result := tx.Model(&Token{}).Where("id = ? and remain_quota >= ?", tokenId, quota).Updates(
+				map[string]interface{}{
+					"remain_quota":  gorm.Expr("remain_quota - ?", quota),
+					"used_quota":    gorm.Expr("used_quota + ?", quota),
+					"accessed_time": now,
+				},
+			)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrInsufficientTokenQuota
+			}
+		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, userId, -balanceQuota, reservation.ledgerSource(LedgerTypeConsume))
+	})
+	if err != nil {
+		reservation.adjustEphemeralTokenQuota(ctx, -quota)
+		return nil, err
+	}
+	if balanceQuota > 0 {
+		cacheAdjustUserQuota(ctx, userId, -balanceQuota)
+		if isUserQuotaLow(userQuota+balanceQuota, balanceQuota) {
+			go notifyUserQuotaLow(userId, userQuota+balanceQuota, balanceQuota)
+		}
+	}
+	return reservation, nil
+}
+
+func (reservation *QuotaReservation) ledgerSource(ledgerType string) LedgerSource {
+	return LedgerSource{
+		Type:      ledgerType,
+		Reference: reservation.RequestId,
+		TokenId:   reservation.TokenId,
+	}
+}
+
+// adjustQuota must be called in the transaction which changes the status of the reservation,
+// a positive quota is charged in addition to the reservation and a negative one is refunded.
+// Charges use the subscription allowance first, refunds give back the part taken from the balance first.
+// It returns the change of the balance of the user.
+func (reservation *QuotaReservation) adjustQuota(tx *gorm.DB, quota int64) (int64, error) {
+	if quota == 0 {
+		return 0, nil
+	}
+	balanceQuota := quota
+	if quota > 0 {
+		_, allowance, err := takeSubscriptionAllowance(tx, reservation.UserId, quota)
+		if err != nil {
+			return 0, err
+		}
+		balanceQuota -= allowance
+	} else if refund := -quota; refund > reservation.Quota-reservation.AllowanceQuota {
+		balanceQuota = -(reservation.Quota - reservation.AllowanceQuota)
+		err := returnSubscriptionAllowance(tx, reservation.SubscriptionId, reservation.AllowancePeriod, refund+balanceQuota)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if balanceQuota != 0 {
+		err := tx.Model(&User{}).Where("id = ?", reservation.UserId).Update("quota", gorm.Expr("quota - ?", balanceQuota)).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	if !reservation.TokenUnlimited {
+		err := tx.Model(&Token{}).Where("id = ?", reservation.TokenId).Updates(
+			map[string]interface{}{
+				"remain_quota":  gorm.Expr("remain_quota - ?", quota),
+				"used_quota":    gorm.Expr("used_quota + ?", quota),
+				"accessed_time": helper.GetTimestamp(),
+			},
+		).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	if balanceQuota > 0 {
+		return -balanceQuota, recordLedger(tx, reservation.UserId, -balanceQuota, reservation.ledgerSource(LedgerTypeConsume))
+	}
+	return -balanceQuota, recordLedger(tx, reservation.UserId, -balanceQuota, reservation.ledgerSource(LedgerTypeRefund))
+}
+
+// adjustEphemeralTokenQuota is called once the change of the reservation is committed.
+func (reservation *QuotaReservation) adjustEphemeralTokenQuota(ctx context.Context, quota int64) {
+	err := IncreaseEphemeralTokenUsedQuota(reservation.EphemeralTokenId, reservation.EphemeralTokenExpiresAt, quota)
+	if err != nil {
+		logger.Error(ctx, "error update ephemeral token used quota: "+err.Error())
+	}
+}
+
+// SettleQuotaReservation charges the actual quota of the request, the difference from the reservation is
+// charged or refunded. The whole quota is charged when the reservation has been reclaimed in the meantime.
+func SettleQuotaReservation(ctx context.Context, reservation *QuotaReservation, quota int64) error {
+	var balanceDelta int64
+	reserved := int64(0)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if reservation.Id != "" {
+			result := tx.Model(&QuotaReservation{}).
+				Where("id = ? and status = ?", reservation.Id, QuotaReservationStatusReserved).
+				Updates(map[string]interface{}{
+					"status":        QuotaReservationStatusSettled,
+					"settled_quota": quota,
+					"settled_at":    helper.GetTimestamp(),
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 1 {
+				reserved = reservation.Quota
+			} else {
+				logger.Warnf(ctx, "quota reservation %s is no longer held, charging the whole quota", reservation.Id)
+			}
+		}
+		var err error
+		balanceDelta, err = reservation.adjustQuota(tx, quota-reserved)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	cacheAdjustUserQuota(ctx, reservation.UserId, balanceDelta)
+	reservation.adjustEphemeralTokenQuota(ctx, quota-reserved)
+	return nil
+}
+
+// ReleaseQuotaReservation gives the reserved quota back, for the requests which failed before being charged.
+func ReleaseQuotaReservation(ctx context.Context, reservation *QuotaReservation) error {
+	return releaseQuotaReservation(ctx, reservation, QuotaReservationStatusReleased)
+}
+
+func releaseQuotaReservation(ctx context.Context, reservation *QuotaReservation, status int) error {
+	if reservation.Id == "" {
+		return nil
+	}
+	var balanceDelta int64
+	released := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&QuotaReservation{}).
+			Where("id = ? and status = ?", reservation.Id, QuotaReservationStatusReserved).
+			Update("status", status)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		var err error
+		balanceDelta, err = reservation.adjustQuota(tx, -reservation.Quota)
+		released = err == nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	cacheAdjustUserQuota(ctx, reservation.UserId, balanceDelta)
+	if released {
+		reservation.adjustEphemeralTokenQuota(ctx, -reservation.Quota)
+	}
+	return nil
+}
+
+// ReclaimExpiredQuotaReservations releases the reservations past ExpiresAt, whose requests most likely
+// died before being settled, and returns how many are reclaimed.
+func ReclaimExpiredQuotaReservations() (int, error) {
+	var reservations []*QuotaReservation
+	err := DB.Where("status = ? and expires_at < ?", QuotaReservationStatusReserved, helper.GetTimestamp()).
+		Limit(1000).Find(&reservations).Error
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.Background()
+	for i, reservation := range reservations {
+		if err = releaseQuotaReservation(ctx, reservation, QuotaReservationStatusExpired); err != nil {
+			return i, err
+		}
+	}
+	return len(reservations), nil
+}
+
+const quotaReservationDeleteBatchSize = 1000
+
+// DeleteFinishedQuotaReservationsBefore deletes the settled, released and expired reservations created before the
+// timestamp in batches, the reservations still held are kept.
+func DeleteFinishedQuotaReservationsBefore(timestamp int64) (int64, error) {
+	var total int64
+	for {
+		var ids []string
+		err := DB.Model(&QuotaReservation{}).Where("created_at < ? and status <> ?", timestamp, QuotaReservationStatusReserved).
+			Order("id").Limit(quotaReservationDeleteBatchSize).Pluck("id", &ids).Error
+		if err != nil {
+			return total, err
+		}
+		if len(ids) == 0 {
+			return total, nil
+		}
+		result := DB.Where("id IN ? and status <> ?", ids, QuotaReservationStatusReserved).Delete(&QuotaReservation{})
+		total += result.RowsAffected
+		if result.Error != nil {
+			return total, result.Error
+		}
+		if len(ids) < quotaReservationDeleteBatchSize {
+			return total, nil
+		}
+	}
+}
+
+func ReclaimQuotaReservations(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		count, err := ReclaimExpiredQuotaReservations()
+		if err != nil {
+			logger.SysError("failed to reclaim quota reservations: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			logger.SysLog(fmt.Sprintf("reclaimed %d expired quota reservations", count))
+		}
+		if config.QuotaReservationRetentionDays <= 0 {
+			continue
+		}
+		deleted, err := DeleteFinishedQuotaReservationsBefore(helper.GetTimestamp() - int64(config.QuotaReservationRetentionDays)*24*60*60)
+		if err != nil {
+			logger.SysError("failed to delete finished quota reservations: " + err.Error())
+		} else if deleted > 0 {
+			logger.SysLogf("deleted %d finished quota reservations", deleted)
+		}
+	}
+}
+
+// cacheAdjustUserQuota keeps the cached user quota in step with the database, a negative quota decreases it.
+func cacheAdjustUserQuota(ctx context.Context, userId int, quota int64) {
+	if quota == 0 {
+		return
+	}
+	if err := CacheDecreaseUserQuota(userId, -quota); err != nil {
+		logger.Error(ctx, "error update user quota cache: "+err.Error())
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+func TestReserveQuota(t *testing.T) {
+	Convey("TestReserveQuota", t, func() {
+		UseTestDB(t, &User{}, &Token{}, &QuotaReservation{}, &Subscription{}, &LedgerEntry{})
+		ctx := context.Background()
+		// the reminder would look up the email in the background after the test database is gone
+		previousThreshold := config.QuotaRemindThreshold
+		config.QuotaRemindThreshold = 0
+		defer func() { config.QuotaRemindThreshold = previousThreshold }()
+		user := &User{Username: "alice", Password: "password", Quota: 1000, Status: UserStatusEnabled, AffCode: "a1", AccessToken: "t1"}
+		So(DB.Create(user).Error, ShouldBeNil)
+		token := &Token{UserId: user.Id, Name: "limited", KeyHash: "h1", RemainQuota: 500}
+		So(DB.Create(token).Error, ShouldBeNil)
+		quotaOf := func() (int64, int64) {
+			userQuota, _ := GetUserQuota(user.Id)
+			var remainQuota int64
+			DB.Model(&Token{}).Where("id = ?", token.Id).Select("remain_quota").Scan(&remainQuota)
+			return userQuota, remainQuota
+		}
+
+		Convey("takes the quota from the user and the limited token and settles the difference", func() {
+			reservation, err := ReserveQuota(ctx, user.Id, token.Id, false, nil, 300)
+			So(err, ShouldBeNil)
+			userQuota, remainQuota := quotaOf()
+			So(userQuota, ShouldEqual, 700)
+			So(remainQuota, ShouldEqual, 200)
+
+			So(SettleQuotaReservation(ctx, reservation, 100), ShouldBeNil)
+			userQuota, remainQuota = quotaOf()
+			So(userQuota, ShouldEqual, 900)
+			So(remainQuota, ShouldEqual, 400)
+		})
+
+		Convey("leaves an unlimited token alone without loading it", func() {
+			reservation, err := ReserveQuota(ctx, user.Id, token.Id, true, nil, 800)
+			So(err, ShouldBeNil)
+			So(reservation.TokenUnlimited, ShouldBeTrue)
+			userQuota, remainQuota := quotaOf()
+			So(userQuota, ShouldEqual, 200)
+			So(remainQuota, ShouldEqual, 500)
+
+			So(ReleaseQuotaReservation(ctx, reservation), ShouldBeNil)
+			userQuota, _ = quotaOf()
+			So(userQuota, ShouldEqual, 1000)
+		})
+
+		Convey("fails without changing anything when the token has not enough quota", func() {
+			_, err := ReserveQuota(ctx, user.Id, token.Id, false, nil, 600)
+			So(err, ShouldEqual, ErrInsufficientTokenQuota)
+			_, err = ReserveQuota(ctx, user.Id, token.Id, true, nil, 1200)
+			So(err, ShouldEqual, ErrInsufficientUserQuota)
+			userQuota, remainQuota := quotaOf()
+			So(userQuota, ShouldEqual, 1000)
+			So(remainQuota, ShouldEqual, 500)
+		})
+
+		Convey("reserves against the limit of an ephemeral token", func() {
+			ephemeral := &EphemeralTokenLimit{Id: random.GetUUID(), ExpiresAt: helper.GetTimestamp() + 60, Quota: 100}
+			first, err := ReserveQuota(ctx, user.Id, token.Id, true, ephemeral, 60)
+			So(err, ShouldBeNil)
+			// a concurrent request can't take more than what is left of the limit
+			_, err = ReserveQuota(ctx, user.Id, token.Id, true, ephemeral, 60)
+			So(err, ShouldEqual, ErrInsufficientEphemeralTokenQuota)
+			userQuota, _ := quotaOf()
+			So(userQuota, ShouldEqual, 940)
+
+			So(SettleQuotaReservation(ctx, first, 30), ShouldBeNil)
+			So(GetEphemeralTokenUsedQuota(ephemeral.Id), ShouldEqual, 30)
+			second, err := ReserveQuota(ctx, user.Id, token.Id, true, ephemeral, 60)
+			So(err, ShouldBeNil)
+			So(ReleaseQuotaReservation(ctx, second), ShouldBeNil)
+			So(GetEphemeralTokenUsedQuota(ephemeral.Id), ShouldEqual, 30)
+		})
+
+		Convey("deletes the old finished reservations and keeps the held ones", func() {
+			settled, err := ReserveQuota(ctx, user.Id, token.Id, true, nil, 100)
+			So(err, ShouldBeNil)
+			So(SettleQuotaReservation(ctx, settled, 100), ShouldBeNil)
+			held, err := ReserveQuota(ctx, user.Id, token.Id, true, nil, 100)
+			So(err, ShouldBeNil)
+			recent, err := ReserveQuota(ctx, user.Id, token.Id, true, nil, 100)
+			So(err, ShouldBeNil)
+			So(ReleaseQuotaReservation(ctx, recent), ShouldBeNil)
+			old := helper.GetTimestamp() - 10*24*60*60
+			So(DB.Model(&QuotaReservation{}).Where("id IN ?", []string{settled.Id, held.Id}).Update("created_at", old).Error, ShouldBeNil)
+
+			deleted, err := DeleteFinishedQuotaReservationsBefore(old + 1)
+			So(err, ShouldBeNil)
+			So(deleted, ShouldEqual, 1)
+			var ids []string
+			So(DB.Model(&QuotaReservation{}).Order("created_at").Pluck("id", &ids).Error, ShouldBeNil)
+			So(ids, ShouldResemble, []string{held.Id, recent.Id})
+		})
+	})
+}
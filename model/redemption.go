@@ -77,6 +77,13 @@ func Redeem(ctx context.Context, key string, userId int) (quota int64, err error
 		if err != nil {
 			return err
 		}
+		err = recordLedger(tx, userId, redemption.Quota, LedgerSource{
+			Type:      LedgerTypeRedemption,
+			Reference: fmt.Sprintf("redemption:%d", redemption.Id),
+		})
+		if err != nil {
+			return err
+		}
 		redemption.RedeemedTime = helper.GetTimestamp()
 		redemption.Status = RedemptionCodeStatusUsed
 		err = tx.Save(redemption).Error
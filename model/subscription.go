@@ -0,0 +1,444 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	SubscriptionPlanStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	SubscriptionPlanStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	SubscriptionStatusActive  = 1 // don't use 0, 0 is the default value!
+	SubscriptionStatusExpired = 2 // also don't use 0
+)
+
+const (
+	SubscriptionPeriodDay   = "day"
+	SubscriptionPeriodWeek  = "week"
+	SubscriptionPeriodMonth = "month"
+	SubscriptionPeriodYear  = "year"
+)
+
+var ErrSubscriptionConflict = errors.New("已有生效中的其他订阅套餐，请等待其到期后再订阅")
+
+// SubscriptionPlan is sold through the payment flow, each billing period includes Quota which is used
+// before the prepaid balance of the user, and moves the user to Group while the subscription is active.
+type SubscriptionPlan struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name" gorm:"index"`
+	Description string  `json:"description" gorm:"type:text"`
+	Price       float64 `json:"price"` // per period, in the currency of the payment flow
+	Period      string  `json:"period" gorm:"type:varchar(16);default:'month'"`
+	Quota       int64   `json:"quota" gorm:"bigint;default:0"`
+	Group       string  `json:"group" gorm:"type:varchar(32);default:''"` // empty keeps the group of the user
+	Status      int     `json:"status" gorm:"default:1"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+}
+
+// Subscription is the plan of a user, a user has at most one active subscription.
+// RemainQuota is the allowance left in the current period, it does not roll over.
+type Subscription struct {
+	Id                 int    `json:"id"`
+	UserId             int    `json:"user_id" gorm:"index"`
+	PlanId             int    `json:"plan_id" gorm:"index"`
+	Status             int    `json:"status" gorm:"index:idx_subscription_status_period,priority:1"`
+	PeriodQuota        int64  `json:"period_quota" gorm:"bigint"`
+	RemainQuota        int64  `json:"remain_quota" gorm:"bigint"`
+	Group              string `json:"group" gorm:"type:varchar(32);default:''"`
+	OriginalGroup      string `json:"original_group" gorm:"type:varchar(32);default:''"` // restored on expiration
+	AutoRenew          bool   `json:"auto_renew"`                                        // opted in by the user, renew from the prepaid balance when the paid periods run out
+	CurrentPeriodStart int64  `json:"current_period_start" gorm:"bigint"`
+	CurrentPeriodEnd   int64  `json:"current_period_end" gorm:"bigint;index:idx_subscription_status_period,priority:2"`
+	PaidUntil          int64  `json:"paid_until" gorm:"bigint"`
+	CreatedTime        int64  `json:"created_time" gorm:"bigint"`
+	ExpiredTime        int64  `json:"expired_time" gorm:"bigint;default:0"`
+}
+
+func isValidSubscriptionPeriod(period string) bool {
+	switch period {
+	case SubscriptionPeriodDay, SubscriptionPeriodWeek, SubscriptionPeriodMonth, SubscriptionPeriodYear:
+		return true
+	}
+	return false
+}
+
+// nextPeriodEnd returns the end of the period starting at start
+func nextPeriodEnd(start int64, period string) int64 {
+	t := time.Unix(start, 0)
+	switch period {
+	case SubscriptionPeriodDay:
+		t = t.AddDate(0, 0, 1)
+	case SubscriptionPeriodWeek:
+		t = t.AddDate(0, 0, 7)
+	case SubscriptionPeriodYear:
+		t = t.AddDate(1, 0, 0)
+	default:
+		t = t.AddDate(0, 1, 0)
+	}
+	return t.Unix()
+}
+
+// PriceQuota is the price of one period converted to quota, which is what a renewal from the balance costs
+func (plan *SubscriptionPlan) PriceQuota() int64 {
+	return int64(plan.Price * config.QuotaPerUnit)
+}
+
+func (plan *SubscriptionPlan) Validate() error {
+	if len(plan.Name) == 0 || len(plan.Name) > 32 {
+		return errors.New("套餐名称长度必须在1-32之间")
+	}
+	if plan.Price < 0 {
+		return errors.New("套餐价格不能为负数")
+	}
+	if plan.Quota < 0 {
+		return errors.New("套餐额度不能为负数")
+	}
+	if !isValidSubscriptionPeriod(plan.Period) {
+		return fmt.Errorf("无效的计费周期 %s，可选 day、week、month、year", plan.Period)
+	}
+	return nil
+}
+
+func GetAllSubscriptionPlans(startIdx int, num int) ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	err := DB.Order("id desc").Limit(num).Offset(startIdx).Find(&plans).Error
+	return plans, err
+}
+
+func GetEnabledSubscriptionPlans() ([]*SubscriptionPlan, error) {
+	var plans []*SubscriptionPlan
+	err := DB.Where("status = ?", SubscriptionPlanStatusEnabled).Order("price").Find(&plans).Error
+	return plans, err
+}
+
+func GetSubscriptionPlanById(id int) (*SubscriptionPlan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := SubscriptionPlan{Id: id}
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	return DB.Create(plan).Error
+}
+
+// Update Make sure your plan's fields is completed, the active subscriptions take the new quota from their next period
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "period", "quota", "group", "status").Updates(plan).Error
+}
+
+func DeleteSubscriptionPlanById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	var count int64
+	err := DB.Model(&Subscription{}).Where("plan_id = ? and status = ?", id, SubscriptionStatusActive).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效中的订阅，请先禁用")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+// GetActiveSubscription returns nil when the user has no active subscription
+func GetActiveSubscription(userId int) (*Subscription, error) {
+	var subscriptions []*Subscription
+	err := DB.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Limit(1).Find(&subscriptions).Error
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	return subscriptions[0], nil
+}
+
+func GetAllSubscriptions(userId int, status int, startIdx int, num int) ([]*Subscription, error) {
+	tx := DB
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != 0 {
+		tx = tx.Where("status = ?", status)
+	}
+	var subscriptions []*Subscription
+	err := tx.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func SetSubscriptionAutoRenew(userId int, autoRenew bool) (*Subscription, error) {
+	subscription, err := GetActiveSubscription(userId)
+	if err != nil {
+		return nil, err
+	}
+	if subscription == nil {
+		return nil, errors.New("没有生效中的订阅")
+	}
+	err = DB.Model(subscription).Update("auto_renew", autoRenew).Error
+	return subscription, err
+}
+
+// setUserGroupIf changes the group of the user unless it was changed by someone else in the meantime
+func setUserGroupIf(tx *gorm.DB, userId int, from string, to string) error {
+	if from == to {
+		return nil
+	}
+	groupCol := "`group`"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+	}
+	err := tx.Model(&User{}).Where("id = ? and "+groupCol+" = ?", userId, from).Update("group", to).Error
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		if err := common.RedisDel(fmt.Sprintf("user_group:%d", userId)); err != nil {
+			logger.SysError("Redis delete user group error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// subscribe must be called in the transaction which completes the payment of one period of the plan.
+// A new subscription starts right away without auto renewal, paying for the active plan again adds one more period to it.
+func subscribe(tx *gorm.DB, userId int, plan *SubscriptionPlan) (*Subscription, error) {
+	if plan.Status != SubscriptionPlanStatusEnabled {
+		return nil, errors.New("该套餐已下架")
+	}
+	var subscriptions []*Subscription
+	err := tx.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Limit(1).Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) != 0 {
+		subscription := subscriptions[0]
+		if subscription.PlanId != plan.Id {
+			return nil, ErrSubscriptionConflict
+		}
+		subscription.PaidUntil = nextPeriodEnd(subscription.PaidUntil, plan.Period)
+		return subscription, tx.Model(subscription).Update("paid_until", subscription.PaidUntil).Error
+	}
+	user := &User{}
+	if err = tx.Select("id", "group").First(user, "id = ?", userId).Error; err != nil {
+		return nil, err
+	}
+	now := helper.GetTimestamp()
+	periodEnd := nextPeriodEnd(now, plan.Period)
+	subscription := &Subscription{
+		UserId:             userId,
+		PlanId:             plan.Id,
+		Status:             SubscriptionStatusActive,
+		PeriodQuota:        plan.Quota,
+		RemainQuota:        plan.Quota,
+		Group:              user.Group,
+		OriginalGroup:      user.Group,
+		CurrentPeriodStart: now,
+		CurrentPeriodEnd:   periodEnd,
+		PaidUntil:          periodEnd,
+		CreatedTime:        now,
+	}
+	if plan.Group != "" {
+		subscription.Group = plan.Group
+		if err = setUserGroupIf(tx, userId, user.Group, plan.Group); err != nil {
+			return nil, err
+		}
+	}
+	return subscription, tx.Create(subscription).Error
+}
+
+// takeSubscriptionAllowance takes up to quota from the allowance of the current period of the user,
+// the caller charges the rest to the prepaid balance.
+func takeSubscriptionAllowance(tx *gorm.DB, userId int, quota int64) (*Subscription, int64, error) {
+	if quota <= 0 {
+		return nil, 0, nil
+	}
+	var subscriptions []*Subscription
+	err := tx.Where("user_id = ? and status = ? and remain_quota > 0 and current_period_end > ?",
+		userId, SubscriptionStatusActive, helper.GetTimestamp()).Limit(1).Find(&subscriptions).Error
+	if err != nil || len(subscriptions) == 0 {
+		return nil, 0, err
+	}
+	subscription := subscriptions[0]
+	taken := quota
+	if subscription.RemainQuota < taken {
+		taken = subscription.RemainQuota
+	}
+	result := tx.Model(&Subscription{}).
+		Where("id = ? and current_period_start = ? and remain_quota >= ?", subscription.Id, subscription.CurrentPeriodStart, taken).
+		Update("remain_quota", gorm.Expr("remain_quota - ?", taken))
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// a concurrent request took the allowance first, the balance pays for this one
+		return nil, 0, nil
+	}
+	return subscription, taken, nil
+}
+
+// returnSubscriptionAllowance gives the allowance back only within the period it was taken from
+func returnSubscriptionAllowance(tx *gorm.DB, subscriptionId int, periodStart int64, quota int64) error {
+	if subscriptionId == 0 || quota <= 0 {
+		return nil
+	}
+	return tx.Model(&Subscription{}).
+		Where("id = ? and status = ? and current_period_start = ?", subscriptionId, SubscriptionStatusActive, periodStart).
+		Update("remain_quota", gorm.Expr("remain_quota + ?", quota)).Error
+}
+
+// renewSubscription moves a subscription whose period has ended to its next period. It uses the periods paid
+// through the payment flow first, then renews from the prepaid balance if AutoRenew is set, otherwise it expires.
+func renewSubscription(ctx context.Context, subscription *Subscription) error {
+	plan, err := GetSubscriptionPlanById(subscription.PlanId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		plan = nil
+	}
+	now := helper.GetTimestamp()
+	renewed := false
+	var renewQuota int64
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		periodStart := subscription.CurrentPeriodStart
+		periodEnd := subscription.CurrentPeriodEnd
+		paidUntil := subscription.PaidUntil
+		period := SubscriptionPeriodMonth
+		periodQuota := subscription.PeriodQuota
+		if plan != nil {
+			period = plan.Period
+			periodQuota = plan.Quota
+		}
+		for periodEnd <= now {
+			nextEnd := nextPeriodEnd(periodEnd, period)
+			// only the period which is current now is charged, the ones missed while not processed are skipped
+			if paidUntil < nextEnd && nextEnd > now {
+				if !subscription.AutoRenew || plan == nil || plan.Status != SubscriptionPlanStatusEnabled {
+					break
+				}
+				renewQuota = plan.PriceQuota()
+				result := tx.Model(&User{}).Where("id = ? and quota >= ?", subscription.UserId, renewQuota).
+					Update("quota", gorm.Expr("quota - ?", renewQuota))
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					break
+				}
+				err := recordLedger(tx, subscription.UserId, -renewQuota, LedgerSource{
+					Type:      LedgerTypeSubscription,
+					Reference: fmt.Sprintf("subscription:%d", subscription.Id),
+				})
+				if err != nil {
+					return err
+				}
+				paidUntil = nextEnd
+				renewed = true
+			}
+			periodStart = periodEnd
+			periodEnd = nextEnd
+		}
+		if periodEnd <= now {
+			return expireSubscription(tx, subscription, now)
+		}
+		result := tx.Model(&Subscription{}).
+			Where("id = ? and status = ? and current_period_end = ?", subscription.Id, SubscriptionStatusActive, subscription.CurrentPeriodEnd).
+			Updates(map[string]interface{}{
+				"current_period_start": periodStart,
+				"current_period_end":   periodEnd,
+				"paid_until":           paidUntil,
+				"period_quota":         periodQuota,
+				"remain_quota":         periodQuota,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("subscription changed while renewing")
+		}
+		subscription.CurrentPeriodStart = periodStart
+		subscription.CurrentPeriodEnd = periodEnd
+		subscription.PaidUntil = paidUntil
+		subscription.PeriodQuota = periodQuota
+		subscription.RemainQuota = periodQuota
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if subscription.Status == SubscriptionStatusExpired {
+		RecordLog(ctx, subscription.UserId, LogTypeSystem, "订阅套餐已到期")
+		return nil
+	}
+	if renewed {
+		cacheAdjustUserQuota(ctx, subscription.UserId, -renewQuota)
+		RecordLog(ctx, subscription.UserId, LogTypeSystem, fmt.Sprintf("订阅套餐自动续订，从余额扣除 %s", common.LogQuota(renewQuota)))
+	}
+	return nil
+}
+
+// expireSubscription ends the subscription and moves the user back to the group from before it
+func expireSubscription(tx *gorm.DB, subscription *Subscription, now int64) error {
+	result := tx.Model(&Subscription{}).
+		Where("id = ? and status = ?", subscription.Id, SubscriptionStatusActive).
+		Updates(map[string]interface{}{
+			"status":       SubscriptionStatusExpired,
+			"remain_quota": 0,
+			"expired_time": now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	if err := setUserGroupIf(tx, subscription.UserId, subscription.Group, subscription.OriginalGroup); err != nil {
+		return err
+	}
+	subscription.Status = SubscriptionStatusExpired
+	subscription.RemainQuota = 0
+	subscription.ExpiredTime = now
+	return nil
+}
+
+// ProcessSubscriptions renews or expires the subscriptions whose current period has ended,
+// and returns how many are processed.
+func ProcessSubscriptions() (int, error) {
+	var subscriptions []*Subscription
+	err := DB.Where("status = ? and current_period_end <= ?", SubscriptionStatusActive, helper.GetTimestamp()).
+		Limit(1000).Find(&subscriptions).Error
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.Background()
+	for i, subscription := range subscriptions {
+		if err = renewSubscription(ctx, subscription); err != nil {
+			return i, err
+		}
+	}
+	return len(subscriptions), nil
+}
+
+func ProcessSubscriptionsPeriodically(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		count, err := ProcessSubscriptions()
+		if err != nil {
+			logger.SysError("failed to process subscriptions: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			logger.SysLog(fmt.Sprintf("processed %d subscriptions at the end of their period", count))
+		}
+	}
+}
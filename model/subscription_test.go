@@ -0,0 +1,67 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestSubscriptionPayment(t *testing.T) {
+	Convey("TestSubscriptionPayment", t, func() {
+		UseTestDB(t, &User{}, &PaymentRecord{}, &SubscriptionPlan{}, &Subscription{}, &LedgerEntry{}, &Log{})
+		user := &User{Username: "alice", Password: "password", Quota: 1000000, Status: UserStatusEnabled, AffCode: "a1", AccessToken: "t1"}
+		So(DB.Create(user).Error, ShouldBeNil)
+		plan := &SubscriptionPlan{Name: "basic", Price: 1, Period: SubscriptionPeriodMonth, Quota: 100, Status: SubscriptionPlanStatusEnabled}
+		So(DB.Create(plan).Error, ShouldBeNil)
+		So(DB.Create(&PaymentRecord{UserID: user.Id, Amount: 1, Status: "pending", OrderID: "order-1", PlanId: plan.Id}).Error, ShouldBeNil)
+		So(HandlePaymentSuccess("order-1"), ShouldBeNil)
+
+		subscription, err := GetActiveSubscription(user.Id)
+		So(err, ShouldBeNil)
+		So(subscription, ShouldNotBeNil)
+		// ends the current period with nothing paid after it
+		past := helper.GetTimestamp() - 1
+		So(DB.Model(subscription).Updates(map[string]interface{}{"current_period_end": past, "paid_until": past}).Error, ShouldBeNil)
+
+		Convey("subscribes without auto renewal and logs after the commit", func() {
+			So(subscription.AutoRenew, ShouldBeFalse)
+			record := &PaymentRecord{}
+			So(DB.First(record, "order_id = ?", "order-1").Error, ShouldBeNil)
+			So(record.Status, ShouldEqual, "success")
+
+			var logs []*Log
+			So(DB.Where("user_id = ?", user.Id).Find(&logs).Error, ShouldBeNil)
+			So(len(logs), ShouldEqual, 1)
+			So(logs[0].Type, ShouldEqual, LogTypeTopup)
+			So(logs[0].Content, ShouldStartWith, "在线支付订阅套餐 basic")
+		})
+
+		Convey("expires at the end of the paid periods unless the user opted in", func() {
+			_, err := ProcessSubscriptions()
+			So(err, ShouldBeNil)
+			subscription, err = GetActiveSubscription(user.Id)
+			So(err, ShouldBeNil)
+			So(subscription, ShouldBeNil)
+			quota, err := GetUserQuota(user.Id)
+			So(err, ShouldBeNil)
+			So(quota, ShouldEqual, 1000000)
+		})
+
+		Convey("renews from the balance once the user opted in", func() {
+			_, err := SetSubscriptionAutoRenew(user.Id, true)
+			So(err, ShouldBeNil)
+			_, err = ProcessSubscriptions()
+			So(err, ShouldBeNil)
+			subscription, err = GetActiveSubscription(user.Id)
+			So(err, ShouldBeNil)
+			So(subscription, ShouldNotBeNil)
+			So(subscription.CurrentPeriodEnd, ShouldBeGreaterThan, past)
+			quota, err := GetUserQuota(user.Id)
+			So(err, ShouldBeNil)
+			So(quota, ShouldEqual, 1000000-int64(config.QuotaPerUnit))
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/songquanpeng/one-api/common"
+)
+
+var testDBCount int
+
+// UseTestDB points DB and LOG_DB at a fresh in-memory SQLite database with the given tables until the test ends.
+// It's meant for the tests of this package and of the packages using it.
+func UseTestDB(t testing.TB, models ...any) {
+	testDBCount++
+	dsn := fmt.Sprintf("file:test%d?mode=memory&cache=shared", testDBCount)
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	previousDB, previousLogDB := DB, LOG_DB
+	previousUsingSQLite, previousRedisEnabled := common.UsingSQLite, common.RedisEnabled
+	DB, LOG_DB = db, db
+	common.UsingSQLite = true
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		_ = sqlDB.Close()
+		DB, LOG_DB = previousDB, previousLogDB
+		common.UsingSQLite, common.RedisEnabled = previousUsingSQLite, previousRedisEnabled
+	})
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"gorm.io/gorm"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/network"
+	"github.com/songquanpeng/one-api/common/random"
 )
 
 const (
@@ -23,7 +26,9 @@ const (
 type Token struct {
 	Id             int     `json:"id"`
 	UserId         int     `json:"user_id"`
-	Key            string  `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Key            string  `json:"key,omitempty" gorm:"-"`             // plaintext key, only available right after creation
+	KeyHash        string  `json:"-" gorm:"type:char(64);uniqueIndex"` // salted hash of the key, used for lookup
+	KeyPrefix      string  `json:"key_prefix" gorm:"type:varchar(16)"` // leading characters of the key, for display
 	Status         int     `json:"status" gorm:"default:1"`
 	Name           string  `json:"name" gorm:"index" `
 	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
@@ -34,6 +39,16 @@ type Token struct {
 	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
 	Models         *string `json:"models" gorm:"type:text"`            // allowed models
 	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	// comma separated patterns, "*" is a wildcard, e.g. https://*.example.com
+	AllowedOrigins  *string `json:"allowed_origins" gorm:"type:text"`
+	AllowedReferers *string `json:"allowed_referers" gorm:"type:text"`
+	// the key replaced by the last rotation, valid until PreviousKeyExpiredTime
+	PreviousKeyHash         string `json:"-" gorm:"type:char(64);index"`
+	PreviousKeyPrefix       string `json:"previous_key_prefix" gorm:"type:varchar(16)"`
+	PreviousKeyExpiredTime  int64  `json:"previous_key_expired_time" gorm:"bigint;default:0"`
+	PreviousKeyAccessedTime int64  `json:"previous_key_accessed_time" gorm:"bigint;default:0"`
+
+	usingPreviousKey bool
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token, error) {
@@ -71,13 +86,52 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		}
 		return nil, errors.New("令牌验证失败")
 	}
+	if err = validateTokenStatus(token); err != nil {
+		return nil, err
+	}
+	if token.usingPreviousKey {
+		token.recordPreviousKeyAccess()
+	}
+	return token, nil
+}
+
+// getTokenByKeyHash also accepts the previous key of a rotated token during its grace period.
+func getTokenByKeyHash(keyHash string) (*Token, error) {
+	token := &Token{}
+	err := DB.Where("key_hash = ?", keyHash).First(token).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return token, err
+	}
+	err = DB.Where("previous_key_hash = ? AND previous_key_expired_time > ?", keyHash, helper.GetTimestamp()).First(token).Error
+	if err != nil {
+		return token, err
+	}
+	token.usingPreviousKey = true
+	return token, nil
+}
+
+const previousKeyAccessRecordInterval = 60 // unit is second
+
+func (t *Token) recordPreviousKeyAccess() {
+	now := helper.GetTimestamp()
+	if now-t.PreviousKeyAccessedTime < previousKeyAccessRecordInterval {
+		return
+	}
+	t.PreviousKeyAccessedTime = now
+	err := DB.Model(t).Update("previous_key_accessed_time", now).Error
+	if err != nil {
+		logger.SysError("failed to update previous key accessed time: " + err.Error())
+	}
+}
+
+func validateTokenStatus(token *Token) error {
 	if token.Status == TokenStatusExhausted {
-		return nil, fmt.Errorf("令牌 %s（#%d）额度已用尽", token.Name, token.Id)
+		return fmt.Errorf("令牌 %s（#%d）额度已用尽", token.Name, token.Id)
 	} else if token.Status == TokenStatusExpired {
-		return nil, errors.New("该令牌已过期")
+		return errors.New("该令牌已过期")
 	}
 	if token.Status != TokenStatusEnabled {
-		return nil, errors.New("该令牌状态不可用")
+		return errors.New("该令牌状态不可用")
 	}
 	if token.ExpiredTime != -1 && token.ExpiredTime < helper.GetTimestamp() {
 		if !common.RedisEnabled {
@@ -87,7 +141,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 				logger.SysError("failed to update token status" + err.Error())
 			}
 		}
-		return nil, errors.New("该令牌已过期")
+		return errors.New("该令牌已过期")
 	}
 	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
 		if !common.RedisEnabled {
@@ -98,9 +152,9 @@ func ValidateUserToken(key string) (token *Token, err error) {
 				logger.SysError("failed to update token status" + err.Error())
 			}
 		}
-		return nil, errors.New("该令牌额度已用尽")
+		return errors.New("该令牌额度已用尽")
 	}
-	return token, nil
+	return nil
 }
 
 func GetTokenByIds(id int, userId int) (*Token, error) {
@@ -123,8 +177,23 @@ func GetTokenById(id int) (*Token, error) {
 	return &token, err
 }
 
+const tokenKeyPrefixLength = 8
+
+// SetKey fills in the hash and display prefix for a plaintext key.
+func (t *Token) SetKey(key string) {
+	t.Key = key
+	t.KeyHash = common.TokenKey2Hash(key)
+	t.KeyPrefix = key
+	if len(key) > tokenKeyPrefixLength {
+		t.KeyPrefix = key[:tokenKeyPrefixLength]
+	}
+}
+
 func (t *Token) Insert() error {
 	var err error
+	if t.Key != "" && t.KeyHash == "" {
+		t.SetKey(t.Key)
+	}
 	err = DB.Create(t).Error
 	return err
 }
@@ -132,7 +201,7 @@ func (t *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (t *Token) Update() error {
 	var err error
-	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(t).Error
+	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "allowed_origins", "allowed_referers").Updates(t).Error
 	return err
 }
 
@@ -141,6 +210,30 @@ func (t *Token) SelectUpdate() error {
 	return DB.Model(t).Select("accessed_time", "status").Updates(t).Error
 }
 
+// Rotate replaces the key of the token, the old key stays valid for gracePeriod seconds.
+// A previous key still in its grace period is invalidated immediately.
+func (t *Token) Rotate(gracePeriod int64) error {
+	oldKeyHash := t.KeyHash
+	t.PreviousKeyHash = t.KeyHash
+	t.PreviousKeyPrefix = t.KeyPrefix
+	t.PreviousKeyExpiredTime = helper.GetTimestamp() + gracePeriod
+	// until now every access was made with the old key
+	t.PreviousKeyAccessedTime = t.AccessedTime
+	t.SetKey(random.GenerateKey())
+	err := DB.Model(t).Select("key_hash", "key_prefix", "previous_key_hash", "previous_key_prefix", "previous_key_expired_time", "previous_key_accessed_time").Updates(t).Error
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		// drop the cached entry, so the old key is looked up again with its grace period
+		err = common.RedisDel(fmt.Sprintf("token:%s", oldKeyHash))
+		if err != nil {
+			logger.SysError("Redis delete token error: " + err.Error())
+		}
+	}
+	return nil
+}
+
 func (t *Token) Delete() error {
 	var err error
 	err = DB.Delete(t).Error
@@ -157,6 +250,50 @@ func (t *Token) GetModels() string {
 	return *t.Models
 }
 
+func (t *Token) GetAllowedOrigins() string {
+	if t == nil || t.AllowedOrigins == nil {
+		return ""
+	}
+	return *t.AllowedOrigins
+}
+
+func (t *Token) GetAllowedReferers() string {
+	if t == nil || t.AllowedReferers == nil {
+		return ""
+	}
+	return *t.AllowedReferers
+}
+
+// IsRequestSourceAllowed checks the Origin and Referer headers of a request against the allowlists of the token.
+// Tokens without allowlists accept requests from anywhere, otherwise one of the headers must be present.
+func (t *Token) IsRequestSourceAllowed(origin string, referer string) bool {
+	allowedOrigins := t.GetAllowedOrigins()
+	allowedReferers := t.GetAllowedReferers()
+	if allowedOrigins != "" {
+		requestOrigin := origin
+		if requestOrigin == "" {
+			requestOrigin = network.GetOrigin(referer)
+		}
+		if !network.MatchPatterns(requestOrigin, allowedOrigins) {
+			return false
+		}
+	}
+	if allowedReferers != "" {
+		if referer == "" {
+			// the origin has been checked already, the referer may be stripped by the referrer policy
+			return allowedOrigins != ""
+		}
+		return network.MatchPatterns(referer, allowedReferers)
+	}
+	return true
+}
+
+func GetAllTokenAllowedOrigins() ([]string, error) {
+	var allowedOrigins []string
+	err := DB.Model(&Token{}).Where("status = ? AND allowed_origins IS NOT NULL AND allowed_origins <> ''", TokenStatusEnabled).Distinct().Pluck("allowed_origins", &allowedOrigins).Error
+	return allowedOrigins, err
+}
+
 func DeleteTokenById(id int, userId int) (err error) {
 	// Why we need userId here? In case user want to delete other's token.
 	if id == 0 || userId == 0 {
@@ -170,6 +307,53 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
+// hasLegacyKeyColumn compares the column names exactly, the HasColumn of sqlite matches the name anywhere in the table DDL
+func hasLegacyKeyColumn() (bool, error) {
+	columnTypes, err := DB.Migrator().ColumnTypes(&Token{})
+	if err != nil {
+		return false, err
+	}
+	for _, columnType := range columnTypes {
+		if strings.EqualFold(columnType.Name(), "key") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// migrateTokenKeys hashes the plaintext keys stored by older versions and clears them afterwards.
+func migrateTokenKeys() error {
+	hasKeyColumn, err := hasLegacyKeyColumn()
+	if err != nil || !hasKeyColumn {
+		return err
+	}
+	keyCol := "`key`"
+	if common.UsingPostgreSQL {
+		keyCol = `"key"`
+	}
+	var legacyTokens []struct {
+		Id  int
+		Key string
+	}
+	err = DB.Table("tokens").Select("id, " + keyCol).Where(keyCol + " IS NOT NULL AND " + keyCol + " <> ''").Find(&legacyTokens).Error
+	if err != nil {
+		return err
+	}
+	if len(legacyTokens) == 0 {
+		return nil
+	}
+	logger.SysLogf("hashing %d plaintext token keys", len(legacyTokens))
+	for _, legacyToken := range legacyTokens {
+		token := Token{}
+		token.SetKey(legacyToken.Key)
+		err = DB.Exec("UPDATE tokens SET key_hash = ?, key_prefix = ?, "+keyCol+" = NULL WHERE id = ?", token.KeyHash, token.KeyPrefix, legacyToken.Id).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func IncreaseTokenQuota(id int, quota int64) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -214,90 +398,45 @@ func decreaseTokenQuota(id int, quota int64) (err error) {
 	return err
 }
 
-func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
-	if quota < 0 {
-		return errors.New("quota 不能为负数！")
-	}
-	token, err := GetTokenById(tokenId)
-	if err != nil {
-		return err
-	}
-	if !token.UnlimitedQuota && token.RemainQuota < quota {
-		return errors.New("令牌额度不足")
-	}
-	userQuota, err := GetUserQuota(token.UserId)
-	if err != nil {
-		return err
-	}
-	if userQuota < quota {
-		return errors.New("用户额度不足")
-	}
+// isUserQuotaLow tells whether the quota consumed takes the balance below the remind threshold or uses it up
+func isUserQuotaLow(userQuota int64, quota int64) bool {
 	quotaTooLow := userQuota >= config.QuotaRemindThreshold && userQuota-quota < config.QuotaRemindThreshold
 	noMoreQuota := userQuota-quota <= 0
-	if quotaTooLow || noMoreQuota {
-		go func() {
-			email, err := GetUserEmail(token.UserId)
-			if err != nil {
-				logger.SysError("failed to fetch user email: " + err.Error())
-			}
-			prompt := "额度提醒"
-			var contentText string
-			if noMoreQuota {
-				contentText = "您的额度已用尽"
-			} else {
-				contentText = "您的额度即将用尽"
-			}
-			if email != "" {
-				topUpLink := fmt.Sprintf("%s/topup", config.ServerAddress)
-				content := message.EmailTemplate(
-					prompt,
-					fmt.Sprintf(`
-						<p>您好！</p>
-						<p>%s，当前剩余额度为 <strong>%d</strong>。</p>
-						<p>为了不影响您的使用，请及时充值。</p>
-						<p style="text-align: center; margin: 30px 0;">
-							<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">立即充值</a>
-						</p>
-						<p style="color: #666;">如果按钮无法点击，请复制以下链接到浏览器中打开：</p>
-						<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px; word-break: break-all;">%s</p>
-					`, contentText, userQuota, topUpLink, topUpLink),
-				)
-				err = message.SendEmail(prompt, email, content)
-				if err != nil {
-					logger.SysError("failed to send email: " + err.Error())
-				}
-			}
-		}()
-	}
-	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
-		if err != nil {
-			return err
-		}
-	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	return quotaTooLow || noMoreQuota
 }
 
-func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
-	token, err := GetTokenById(tokenId)
+// notifyUserQuotaLow emails the user, it's called when isUserQuotaLow
+func notifyUserQuotaLow(userId int, userQuota int64, quota int64) {
+	noMoreQuota := userQuota-quota <= 0
+	email, err := GetUserEmail(userId)
 	if err != nil {
-		return err
+		logger.SysError("failed to fetch user email: " + err.Error())
 	}
-	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
+	prompt := "额度提醒"
+	var contentText string
+	if noMoreQuota {
+		contentText = "您的额度已用尽"
 	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
+		contentText = "您的额度即将用尽"
 	}
-	if !token.UnlimitedQuota {
-		if quota > 0 {
-			err = DecreaseTokenQuota(tokenId, quota)
-		} else {
-			err = IncreaseTokenQuota(tokenId, -quota)
-		}
+	if email != "" {
+		topUpLink := fmt.Sprintf("%s/topup", config.ServerAddress)
+		content := message.EmailTemplate(
+			prompt,
+			fmt.Sprintf(`
+				<p>您好！</p>
+				<p>%s，当前剩余额度为 <strong>%d</strong>。</p>
+				<p>为了不影响您的使用，请及时充值。</p>
+				<p style="text-align: center; margin: 30px 0;">
+					<a href="%s" style="background-color: #007bff; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">立即充值</a>
+				</p>
+				<p style="color: #666;">如果按钮无法点击，请复制以下链接到浏览器中打开：</p>
+				<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px; word-break: break-all;">%s</p>
+			`, contentText, userQuota, topUpLink, topUpLink),
+		)
+		err = message.SendEmail(prompt, email, content)
 		if err != nil {
-			return err
+			logger.SysError("failed to send email: " + err.Error())
 		}
 	}
-	return nil
 }
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common"
+)
+
+func TestMigrateTokenKeys(t *testing.T) {
+	Convey("TestMigrateTokenKeys", t, func() {
+		Convey("fresh database without the legacy key column", func() {
+			UseTestDB(t, &Token{})
+			So(migrateTokenKeys(), ShouldBeNil)
+		})
+
+		Convey("plaintext keys are hashed and cleared", func() {
+			UseTestDB(t, &Token{})
+			So(DB.Exec("ALTER TABLE tokens ADD COLUMN `key` char(48)").Error, ShouldBeNil)
+			So(DB.Exec("INSERT INTO tokens (id, user_id, `key`, name) VALUES (1, 1, 'abcdefghijklmnop', 'legacy'), (2, 1, '', 'empty')").Error, ShouldBeNil)
+
+			So(migrateTokenKeys(), ShouldBeNil)
+
+			token := Token{}
+			So(DB.First(&token, 1).Error, ShouldBeNil)
+			So(token.KeyHash, ShouldEqual, common.TokenKey2Hash("abcdefghijklmnop"))
+			So(token.KeyPrefix, ShouldEqual, "abcdefgh")
+			var legacyKey *string
+			So(DB.Table("tokens").Select("`key`").Where("id = ?", 1).Row().Scan(&legacyKey), ShouldBeNil)
+			So(legacyKey, ShouldBeNil)
+
+			found, err := getTokenByKeyHash(common.TokenKey2Hash("abcdefghijklmnop"))
+			So(err, ShouldBeNil)
+			So(found.Id, ShouldEqual, 1)
+
+			// running it again is a no-op
+			So(migrateTokenKeys(), ShouldBeNil)
+			So(DB.First(&token, 1).Error, ShouldBeNil)
+			So(token.KeyHash, ShouldEqual, common.TokenKey2Hash("abcdefghijklmnop"))
+		})
+	})
+}
+
+func TestRotateToken(t *testing.T) {
+	Convey("TestRotateToken", t, func() {
+		UseTestDB(t, &Token{})
+		token := &Token{UserId: 1, Name: "rotated", Status: TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true, AccessedTime: 100}
+		token.SetKey("oldkey1234567890")
+		So(DB.Create(token).Error, ShouldBeNil)
+
+		Convey("both keys are accepted during the grace period", func() {
+			So(token.Rotate(3600), ShouldBeNil)
+			So(token.Key, ShouldNotEqual, "oldkey1234567890")
+			So(token.PreviousKeyPrefix, ShouldEqual, "oldkey12")
+			So(token.PreviousKeyAccessedTime, ShouldEqual, 100)
+
+			validated, err := ValidateUserToken(token.Key)
+			So(err, ShouldBeNil)
+			So(validated.Id, ShouldEqual, token.Id)
+			So(validated.usingPreviousKey, ShouldBeFalse)
+
+			validated, err = ValidateUserToken("oldkey1234567890")
+			So(err, ShouldBeNil)
+			So(validated.Id, ShouldEqual, token.Id)
+			So(validated.usingPreviousKey, ShouldBeTrue)
+
+			stored := Token{}
+			So(DB.First(&stored, token.Id).Error, ShouldBeNil)
+			So(stored.PreviousKeyAccessedTime, ShouldBeGreaterThan, 100)
+		})
+
+		Convey("the previous key is rejected after the grace period", func() {
+			So(token.Rotate(0), ShouldBeNil)
+			_, err := ValidateUserToken("oldkey1234567890")
+			So(err, ShouldNotBeNil)
+			_, err = ValidateUserToken(token.Key)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("only the latest previous key is kept", func() {
+			So(token.Rotate(3600), ShouldBeNil)
+			secondKey := token.Key
+			So(token.Rotate(3600), ShouldBeNil)
+			_, err := ValidateUserToken(secondKey)
+			So(err, ShouldBeNil)
+			_, err = ValidateUserToken("oldkey1234567890")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestIsRequestSourceAllowed(t *testing.T) {
+	Convey("TestIsRequestSourceAllowed", t, func() {
+		So((&Token{}).IsRequestSourceAllowed("", ""), ShouldBeTrue)
+
+		allowedOrigins := "https://app.example.com"
+		token := &Token{AllowedOrigins: &allowedOrigins}
+		So(token.IsRequestSourceAllowed("https://app.example.com", ""), ShouldBeTrue)
+		So(token.IsRequestSourceAllowed("", "https://app.example.com/chat"), ShouldBeTrue)
+		So(token.IsRequestSourceAllowed("https://evil.example.org", ""), ShouldBeFalse)
+		So(token.IsRequestSourceAllowed("", ""), ShouldBeFalse)
+
+		allowedReferers := "https://app.example.com/chat/*"
+		token.AllowedReferers = &allowedReferers
+		So(token.IsRequestSourceAllowed("https://app.example.com", "https://app.example.com/chat/1"), ShouldBeTrue)
+		So(token.IsRequestSourceAllowed("https://app.example.com", "https://app.example.com/admin"), ShouldBeFalse)
+		// the referer may be stripped by the referrer policy
+		So(token.IsRequestSourceAllowed("https://app.example.com", ""), ShouldBeTrue)
+
+		token.AllowedOrigins = nil
+		So(token.IsRequestSourceAllowed("", ""), ShouldBeFalse)
+		So(token.IsRequestSourceAllowed("", "https://evil.example.org/"), ShouldBeFalse)
+	})
+}
+
+func TestCacheIsOriginAllowedByAnyToken(t *testing.T) {
+	Convey("TestCacheIsOriginAllowedByAnyToken", t, func() {
+		UseTestDB(t, &Token{})
+		allowedOrigins := "https://app.example.com"
+		token := &Token{UserId: 1, Status: TokenStatusEnabled, AllowedOrigins: &allowedOrigins}
+		token.SetKey("origintoken12345")
+		So(DB.Create(token).Error, ShouldBeNil)
+		defer func() { tokenAllowedOrigins, tokenAllowedOriginsSyncTime = nil, 0 }()
+
+		tokenAllowedOrigins, tokenAllowedOriginsSyncTime = nil, 0
+		So(CacheIsOriginAllowedByAnyToken("https://app.example.com"), ShouldBeTrue)
+
+		Convey("serves the stale origins while refreshing them in the background", func() {
+			tokenAllowedOrigins, tokenAllowedOriginsSyncTime = []string{"https://stale.example.com"}, 1
+			So(CacheIsOriginAllowedByAnyToken("https://stale.example.com"), ShouldBeTrue)
+			// wait for the refresh to finish
+			tokenAllowedOriginsRefreshLock.Lock()
+			tokenAllowedOriginsRefreshLock.Unlock()
+			So(CacheIsOriginAllowedByAnyToken("https://stale.example.com"), ShouldBeFalse)
+			So(CacheIsOriginAllowedByAnyToken("https://app.example.com"), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,368 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RollupGranularityHour = "hour"
+	RollupGranularityDay  = "day"
+)
+
+var rollupTables = map[string]string{
+	RollupGranularityHour: "usage_hourly_rollups",
+	RollupGranularityDay:  "usage_daily_rollups",
+}
+
+// rollupLocation is the timezone the days of the rollups start in, the hours are counted from the start of the day.
+var rollupLocation = time.Local
+
+// InitUsageRollupLocation loads the timezone of USAGE_ROLLUP_TIMEZONE, the local timezone is used when it's empty.
+func InitUsageRollupLocation() {
+	if config.UsageRollupTimezone == "" {
+		return
+	}
+	location, err := time.LoadLocation(config.UsageRollupTimezone)
+	if err != nil {
+		logger.FatalLog("failed to load USAGE_ROLLUP_TIMEZONE: " + err.Error())
+		return
+	}
+	rollupLocation = location
+}
+
+// getRollupDay returns the start of the day of the timestamp in rollupLocation
+func getRollupDay(timestamp int64) int64 {
+	year, month, day := time.Unix(timestamp, 0).In(rollupLocation).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, rollupLocation).Unix()
+}
+
+// getNextRollupDay returns the start of the next day, days are not always 24 hours long
+func getNextRollupDay(day int64) int64 {
+	year, month, date := time.Unix(day, 0).In(rollupLocation).Date()
+	return time.Date(year, month, date+1, 0, 0, 0, 0, rollupLocation).Unix()
+}
+
+func getRollupBucket(granularity string, timestamp int64) int64 {
+	day := getRollupDay(timestamp)
+	if granularity == RollupGranularityDay {
+		return day
+	}
+	return timestamp - (timestamp-day)%(60*60)
+}
+
+// UsageRollup is a row of the hourly and daily rollup tables, aggregated from consume and error logs.
+// Days start at midnight in the timezone of USAGE_ROLLUP_TIMEZONE.
+type UsageRollup struct {
+	Id               int    `json:"-"`
+	Bucket           int64  `json:"bucket" gorm:"bigint;uniqueIndex:,composite:rollup_key,priority:1"`
+	UserId           int    `json:"user_id" gorm:"uniqueIndex:,composite:rollup_key,priority:2"`
+	TokenId          int    `json:"token_id" gorm:"uniqueIndex:,composite:rollup_key,priority:3"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:,composite:rollup_key,priority:4"`
+	ChannelId        int    `json:"channel" gorm:"uniqueIndex:,composite:rollup_key,priority:5"`
+	GroupName        string `json:"group" gorm:"type:varchar(64);uniqueIndex:,composite:rollup_key,priority:6"`
+	RequestCount     int64  `json:"request_count" gorm:"default:0"`
+	ErrorCount       int64  `json:"error_count" gorm:"default:0"`
+	Quota            int64  `json:"quota" gorm:"default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"default:0"`
+	ElapsedTime      int64  `json:"elapsed_time" gorm:"default:0"` // sum of the elapsed time, unit is ms
+	UpstreamCost     int64  `json:"upstream_cost" gorm:"default:0"`
+}
+
+func migrateUsageRollups(db *gorm.DB) error {
+	for _, table := range rollupTables {
+		if err := db.Table(table).AutoMigrate(&UsageRollup{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type rollupKey struct {
+	bucket    int64
+	userId    int
+	tokenId   int
+	modelName string
+	channelId int
+	groupName string
+}
+
+var rollupLock sync.Mutex
+var rollupBuffers = map[string]map[rollupKey]*UsageRollup{
+	RollupGranularityHour: {},
+	RollupGranularityDay:  {},
+}
+
+// addUsageRollup accumulates a consume or error log in memory, SyncUsageRollups writes them to the rollup tables.
+func addUsageRollup(log *Log) {
+	if log.Type != LogTypeConsume && log.Type != LogTypeError {
+		return
+	}
+	group, err := CacheGetUserGroup(log.UserId)
+	if err != nil {
+		logger.SysError("failed to get user group for usage rollup: " + err.Error())
+	}
+	rollupLock.Lock()
+	defer rollupLock.Unlock()
+	for granularity, buffer := range rollupBuffers {
+		accumulateRollup(buffer, granularity, log, group)
+	}
+}
+
+func accumulateRollup(buffer map[rollupKey]*UsageRollup, granularity string, log *Log, group string) {
+	key := rollupKey{
+		bucket:    getRollupBucket(granularity, log.CreatedAt),
+		userId:    log.UserId,
+		tokenId:   log.TokenId,
+		modelName: log.ModelName,
+		channelId: log.ChannelId,
+		groupName: group,
+	}
+	rollup, ok := buffer[key]
+	if !ok {
+		rollup = &UsageRollup{
+			Bucket:    key.bucket,
+			UserId:    key.userId,
+			TokenId:   key.tokenId,
+			ModelName: key.modelName,
+			ChannelId: key.channelId,
+			GroupName: key.groupName,
+		}
+		buffer[key] = rollup
+	}
+	if log.Type == LogTypeError {
+		rollup.ErrorCount++
+		return
+	}
+	rollup.RequestCount++
+	rollup.Quota += int64(log.Quota)
+	rollup.PromptTokens += int64(log.PromptTokens)
+	rollup.CompletionTokens += int64(log.CompletionTokens)
+	rollup.ElapsedTime += log.ElapsedTime
+	rollup.UpstreamCost += int64(log.UpstreamCost)
+}
+
+// rollupFlushLock makes the flush on shutdown wait for the periodic one being written
+var rollupFlushLock sync.Mutex
+
+// FlushUsageRollups upserts the accumulated rollups, increasing the existing rows.
+func FlushUsageRollups() {
+	rollupFlushLock.Lock()
+	defer rollupFlushLock.Unlock()
+	rollupLock.Lock()
+	buffers := rollupBuffers
+	rollupBuffers = map[string]map[rollupKey]*UsageRollup{
+		RollupGranularityHour: {},
+		RollupGranularityDay:  {},
+	}
+	rollupLock.Unlock()
+	for granularity, buffer := range buffers {
+		if len(buffer) == 0 {
+			continue
+		}
+		rollups := make([]*UsageRollup, 0, len(buffer))
+		for _, rollup := range buffer {
+			rollups = append(rollups, rollup)
+		}
+		err := LOG_DB.Table(rollupTables[granularity]).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "bucket"}, {Name: "user_id"}, {Name: "token_id"}, {Name: "model_name"}, {Name: "channel_id"}, {Name: "group_name"}},
+			DoUpdates: clause.Assignments(rollupIncrements(rollupTables[granularity])),
+		}).CreateInBatches(rollups, 100).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to flush %s usage rollups: %s", granularity, err.Error()))
+		}
+	}
+}
+
+var rollupCounters = []string{"request_count", "error_count", "quota", "prompt_tokens", "completion_tokens", "elapsed_time", "upstream_cost"}
+
+// rollupIncrements adds the inserted values to the existing row on conflict
+func rollupIncrements(table string) map[string]any {
+	assignments := make(map[string]any, len(rollupCounters))
+	for _, column := range rollupCounters {
+		if common.UsingMySQL {
+			assignments[column] = gorm.Expr(fmt.Sprintf("%s + VALUES(%s)", column, column))
+		} else {
+			// qualified to avoid the ambiguity with excluded on PostgreSQL
+			assignments[column] = gorm.Expr(fmt.Sprintf("%s.%s + excluded.%s", table, column, column))
+		}
+	}
+	return assignments
+}
+
+func SyncUsageRollups(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushUsageRollups()
+	}
+}
+
+var rollupBackfillRunning atomic.Bool
+
+// BackfillUsageRollups rebuilds the rollups of the days overlapping [start, end) from the logs, e.g. the logs
+// written before the rollups existed or the rollups lost by a crash before they were flushed. The rows of each day
+// are replaced, so it can be run again, but the current day is skipped since it's still being flushed, the quota
+// sums read it from the logs anyway.
+func BackfillUsageRollups(start int64, end int64) error {
+	if !rollupBackfillRunning.CompareAndSwap(false, true) {
+		return errors.New("usage rollup backfill is already running")
+	}
+	defer rollupBackfillRunning.Store(false)
+	today := getRollupDay(helper.GetTimestamp())
+	if end > today {
+		end = today
+	}
+	groups := make(map[int]string)
+	for day := getRollupDay(start); day < end; day = getNextRollupDay(day) {
+		nextDay := getNextRollupDay(day)
+		hourly := make(map[rollupKey]*UsageRollup)
+		daily := make(map[rollupKey]*UsageRollup)
+		// an hour at a time to bound the memory used by the logs
+		for hour := day; hour < nextDay; hour += 60 * 60 {
+			logs, err := getLogStore().Find(&LogFilter{StartTimestamp: hour, EndTimestamp: hour + 60*60 - 1}, 0, 0)
+			if err != nil {
+				return err
+			}
+			for _, log := range logs {
+				if log.Type != LogTypeConsume && log.Type != LogTypeError {
+					continue
+				}
+				group, ok := groups[log.UserId]
+				if !ok {
+					group, _ = CacheGetUserGroup(log.UserId)
+					groups[log.UserId] = group
+				}
+				accumulateRollup(hourly, RollupGranularityHour, log, group)
+				accumulateRollup(daily, RollupGranularityDay, log, group)
+			}
+		}
+		err := LOG_DB.Transaction(func(tx *gorm.DB) error {
+			if err := replaceRollups(tx, RollupGranularityHour, day, nextDay, hourly); err != nil {
+				return err
+			}
+			return replaceRollups(tx, RollupGranularityDay, day, nextDay, daily)
+		})
+		if err != nil {
+			return err
+		}
+		logger.SysLogf("usage rollups of %s rebuilt", time.Unix(day, 0).In(rollupLocation).Format("2006-01-02"))
+	}
+	return nil
+}
+
+func replaceRollups(tx *gorm.DB, granularity string, start int64, end int64, buffer map[rollupKey]*UsageRollup) error {
+	table := rollupTables[granularity]
+	err := tx.Table(table).Where("bucket >= ? AND bucket < ?", start, end).Delete(&UsageRollup{}).Error
+	if err != nil || len(buffer) == 0 {
+		return err
+	}
+	rollups := make([]*UsageRollup, 0, len(buffer))
+	for _, rollup := range buffer {
+		rollups = append(rollups, rollup)
+	}
+	return tx.Table(table).CreateInBatches(rollups, 100).Error
+}
+
+var rollupGroupByColumns = map[string]string{
+	"user":    "user_id",
+	"token":   "token_id",
+	"model":   "model_name",
+	"channel": "channel_id",
+	"group":   "group_name",
+}
+
+type UsageQuery struct {
+	Granularity    string
+	StartTimestamp int64
+	EndTimestamp   int64
+	GroupBy        []string // keys of rollupGroupByColumns
+	Series         bool     // group by bucket as well
+	UserId         int
+	TokenId        int
+	TokenIds       []int // matches any of them when set
+	ModelName      string
+	ChannelId      int
+	Group          string
+}
+
+type UsageStat struct {
+	Bucket           int64  `json:"bucket,omitempty"`
+	UserId           int    `json:"user_id,omitempty"`
+	TokenId          int    `json:"token_id,omitempty"`
+	ModelName        string `json:"model_name,omitempty"`
+	ChannelId        int    `json:"channel,omitempty"`
+	GroupName        string `json:"group,omitempty"`
+	RequestCount     int64  `json:"request_count"`
+	ErrorCount       int64  `json:"error_count"`
+	Quota            int64  `json:"quota"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	ElapsedTime      int64  `json:"elapsed_time"`
+	UpstreamCost     int64  `json:"upstream_cost"`
+}
+
+func GetUsageStats(query *UsageQuery) ([]*UsageStat, error) {
+	table, ok := rollupTables[query.Granularity]
+	if !ok {
+		return nil, fmt.Errorf("invalid granularity: %s", query.Granularity)
+	}
+	var columns []string
+	if query.Series {
+		columns = append(columns, "bucket")
+	}
+	for _, key := range query.GroupBy {
+		column, ok := rollupGroupByColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("invalid group by: %s", key)
+		}
+		columns = append(columns, column)
+	}
+	selects := append([]string{}, columns...)
+	for _, column := range rollupCounters {
+		// no rows sum to NULL
+		selects = append(selects, fmt.Sprintf("COALESCE(sum(%s),0) as %s", column, column))
+	}
+	tx := LOG_DB.Table(table).Select(strings.Join(selects, ", "))
+	if query.StartTimestamp != 0 {
+		tx = tx.Where("bucket >= ?", getRollupBucket(query.Granularity, query.StartTimestamp))
+	}
+	if query.EndTimestamp != 0 {
+		tx = tx.Where("bucket <= ?", query.EndTimestamp)
+	}
+	if query.UserId != 0 {
+		tx = tx.Where("user_id = ?", query.UserId)
+	}
+	if query.TokenId != 0 {
+		tx = tx.Where("token_id = ?", query.TokenId)
+	}
+	if len(query.TokenIds) != 0 {
+		tx = tx.Where("token_id IN ?", query.TokenIds)
+	}
+	if query.ModelName != "" {
+		tx = tx.Where("model_name = ?", query.ModelName)
+	}
+	if query.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", query.ChannelId)
+	}
+	if query.Group != "" {
+		tx = tx.Where("group_name = ?", query.Group)
+	}
+	if len(columns) != 0 {
+		tx = tx.Group(strings.Join(columns, ", ")).Order(strings.Join(columns, ", "))
+	}
+	var stats []*UsageStat
+	err := tx.Scan(&stats).Error
+	return stats, err
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestUsageRollups(t *testing.T) {
+	Convey("TestUsageRollups", t, func() {
+		UseTestDB(t, &User{}, &Token{}, &Log{})
+		So(migrateUsageRollups(LOG_DB), ShouldBeNil)
+		previousLocation := rollupLocation
+		rollupLocation = time.UTC
+		defer func() { rollupLocation = previousLocation }()
+		So(DB.Create(&User{Id: 1, Username: "alice", Group: "vip", AffCode: "a", AccessToken: "a"}).Error, ShouldBeNil)
+		So(DB.Create(&User{Id: 2, Username: "bob", Group: "default", AffCode: "b", AccessToken: "b"}).Error, ShouldBeNil)
+		So(DB.Create(&Token{Id: 10, UserId: 1, Name: "web", KeyHash: "hash-10"}).Error, ShouldBeNil)
+		So(DB.Create(&Token{Id: 11, UserId: 1, Name: "cli", KeyHash: "hash-11"}).Error, ShouldBeNil)
+
+		const day = int64(100 * 24 * 60 * 60)
+		logs := []*Log{
+			{Type: LogTypeConsume, CreatedAt: day + 10, UserId: 1, Username: "alice", TokenId: 10, ModelName: "gpt-4o", ChannelId: 1, Quota: 100, PromptTokens: 10, CompletionTokens: 20},
+			{Type: LogTypeConsume, CreatedAt: day + 3700, UserId: 1, Username: "alice", TokenId: 11, ModelName: "gpt-4o", ChannelId: 2, Quota: 200, PromptTokens: 1, CompletionTokens: 2},
+			{Type: LogTypeError, CreatedAt: day + 3800, UserId: 1, Username: "alice", TokenId: 11, ModelName: "claude-3", ChannelId: 2},
+			{Type: LogTypeTopup, CreatedAt: day + 3900, UserId: 1, Username: "alice", Quota: 5000},
+			{Type: LogTypeConsume, CreatedAt: day + 86400 + 10, UserId: 2, Username: "bob", ModelName: "gpt-4o", ChannelId: 1, Quota: 400},
+		}
+		So(LOG_DB.Create(logs).Error, ShouldBeNil)
+
+		// the rows of the days are replaced, running it twice doesn't count the logs twice
+		So(BackfillUsageRollups(day+100, day+86400+100), ShouldBeNil)
+		So(BackfillUsageRollups(day, day+2*86400), ShouldBeNil)
+
+		Convey("rebuilds the hourly and daily rollups", func() {
+			stats, err := GetUsageStats(&UsageQuery{Granularity: RollupGranularityHour, Series: true, GroupBy: []string{"group"}})
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 3)
+			So(stats[0].Bucket, ShouldEqual, day)
+			So(stats[0].GroupName, ShouldEqual, "vip")
+			So(stats[1].Bucket, ShouldEqual, day+3600)
+			So(stats[1].Quota, ShouldEqual, 200)
+			So(stats[1].ErrorCount, ShouldEqual, 1)
+
+			stats, err = GetUsageStats(&UsageQuery{Granularity: RollupGranularityDay, Series: true})
+			So(err, ShouldBeNil)
+			So(len(stats), ShouldEqual, 2)
+			So(stats[0].RequestCount, ShouldEqual, 2)
+			So(stats[0].Quota, ShouldEqual, 300)
+			So(stats[1].Quota, ShouldEqual, 400)
+		})
+
+		Convey("sums the used quota by username and token name", func() {
+			So(SumUsedQuota(LogTypeConsume, day, day+2*86400, "", "", "", 0), ShouldEqual, 700)
+			So(SumUsedQuota(LogTypeConsume, day, day+2*86400, "", "alice", "", 0), ShouldEqual, 300)
+			So(SumUsedQuota(LogTypeConsume, day, day+2*86400, "", "alice", "cli", 0), ShouldEqual, 200)
+			So(SumUsedQuota(LogTypeConsume, day, day+2*86400, "", "", "", 1), ShouldEqual, 500)
+			So(SumUsedQuota(LogTypeConsume, day+86400, 0, "", "", "", 0), ShouldEqual, 400)
+			So(SumUsedQuota(LogTypeConsume, 0, 0, "", "nobody", "", 0), ShouldEqual, 0)
+			So(SumUsedQuota(LogTypeConsume, 0, 0, "", "", "none", 0), ShouldEqual, 0)
+			So(SumUsedToken(LogTypeConsume, 0, 0, "", "alice", ""), ShouldEqual, 33)
+		})
+
+		Convey("groups the usage of a user by day and model", func() {
+			statistics, err := SearchLogsByDayAndModel(1, int(day), int(day+2*86400))
+			So(err, ShouldBeNil)
+			// the error-only rollup of claude-3 is left out
+			So(len(statistics), ShouldEqual, 1)
+			So(*statistics[0], ShouldResemble, LogStatistic{Day: "1970-04-11", ModelName: "gpt-4o", RequestCount: 2, Quota: 300, PromptTokens: 11, CompletionTokens: 22})
+		})
+
+		Convey("adds the logs recorded later to the rollups", func() {
+			addUsageRollup(&Log{Type: LogTypeConsume, CreatedAt: day + 20, UserId: 2, ModelName: "gpt-4o", Quota: 1000})
+			FlushUsageRollups()
+			So(SumUsedQuota(LogTypeConsume, day, day+86399, "", "", "", 0), ShouldEqual, 1300)
+		})
+
+		Convey("reads the current day from the logs before the rollups are flushed", func() {
+			now := helper.GetTimestamp()
+			So(LOG_DB.Create(&Log{Type: LogTypeConsume, CreatedAt: now, UserId: 1, Username: "alice", TokenName: "web", ModelName: "gpt-4o", Quota: 50, PromptTokens: 5}).Error, ShouldBeNil)
+			So(SumUsedQuota(LogTypeConsume, 0, 0, "", "alice", "", 0), ShouldEqual, 350)
+			So(SumUsedQuota(LogTypeConsume, now, now, "", "alice", "web", 0), ShouldEqual, 50)
+			So(SumUsedToken(LogTypeConsume, 0, 0, "", "alice", ""), ShouldEqual, 38)
+
+			statistics, err := SearchLogsByDayAndModel(1, int(day), int(now))
+			So(err, ShouldBeNil)
+			So(len(statistics), ShouldEqual, 2)
+			So(*statistics[1], ShouldResemble, LogStatistic{Day: time.Unix(now, 0).UTC().Format("2006-01-02"), ModelName: "gpt-4o", RequestCount: 1, Quota: 50, PromptTokens: 5})
+		})
+
+		Convey("starts the days in the configured timezone", func() {
+			rollupLocation = time.FixedZone("UTC+8", 8*60*60)
+			So(getRollupBucket(RollupGranularityDay, day+10), ShouldEqual, day-8*60*60)
+			So(getRollupBucket(RollupGranularityDay, day+16*60*60), ShouldEqual, day+16*60*60)
+			So(getRollupBucket(RollupGranularityHour, day+3700), ShouldEqual, day+3600)
+
+			So(BackfillUsageRollups(day, day+2*86400), ShouldBeNil)
+			statistics, err := SearchLogsByDayAndModel(1, int(day), int(day+2*86400))
+			So(err, ShouldBeNil)
+			So(len(statistics), ShouldEqual, 1)
+			So(statistics[0].Day, ShouldEqual, "1970-04-11")
+			stats, err := GetUsageStats(&UsageQuery{Granularity: RollupGranularityDay, Series: true})
+			So(err, ShouldBeNil)
+			So(stats[0].Bucket, ShouldEqual, day-8*60*60)
+		})
+	})
+}
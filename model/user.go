@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/blacklist"
@@ -132,16 +133,19 @@ func (user *User) Insert(ctx context.Context, inviterId int) error {
 	if result.Error != nil {
 		return result.Error
 	}
+	if err = RecordLedger(user.Id, user.Quota, LedgerSource{Type: LedgerTypeSignupBonus}); err != nil {
+		logger.SysError("failed to record signup bonus in ledger: " + err.Error())
+	}
 	if config.QuotaForNewUser > 0 {
 		RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(config.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if config.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
+			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee, LedgerSource{Type: LedgerTypeInviteBonus, Reference: fmt.Sprintf("inviter:%d", inviterId)})
 			RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(config.QuotaForInvitee)))
 		}
 		if config.QuotaForInviter > 0 {
-			_ = IncreaseUserQuota(inviterId, config.QuotaForInviter)
+			_ = IncreaseUserQuota(inviterId, config.QuotaForInviter, LedgerSource{Type: LedgerTypeInviteBonus, Reference: fmt.Sprintf("invitee:%d", user.Id)})
 			RecordLog(ctx, inviterId, LogTypeSystem, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(config.QuotaForInviter)))
 		}
 	}
@@ -164,8 +168,7 @@ func (user *User) Insert(ctx context.Context, inviterId int) error {
 	return nil
 }
 
-func (user *User) Update(updatePassword bool) error {
-	var err error
+func (user *User) prepareUpdate(updatePassword bool) (err error) {
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
 		if err != nil {
@@ -177,8 +180,36 @@ func (user *User) Update(updatePassword bool) error {
 	} else if user.Status == UserStatusEnabled {
 		blacklist.UnbanUser(user.Id)
 	}
-	err = DB.Model(user).Updates(user).Error
-	return err
+	return nil
+}
+
+func (user *User) Update(updatePassword bool) error {
+	if err := user.prepareUpdate(updatePassword); err != nil {
+		return err
+	}
+	return DB.Model(user).Updates(user).Error
+}
+
+// UpdateWithLedger updates the user like Update and records the change of the quota in the ledger in the same
+// transaction, the change is taken from the locked row before and after the update so concurrent consumption
+// is not counted as an adjustment. It returns the quota before and after the update.
+func (user *User) UpdateWithLedger(updatePassword bool, source LedgerSource) (oldQuota int64, newQuota int64, err error) {
+	if err = user.prepareUpdate(updatePassword); err != nil {
+		return 0, 0, err
+	}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User{}).Where("id = ?", user.Id).Select("quota").Scan(&oldQuota).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(user).Updates(user).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", user.Id).Select("quota").Scan(&newQuota).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, user.Id, newQuota-oldQuota, source)
+	})
+	return oldQuota, newQuota, err
 }
 
 func (user *User) Delete() error {
@@ -371,15 +402,22 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
-func IncreaseUserQuota(id int, quota int64) (err error) {
+// IncreaseUserQuota credits the user, the change is recorded in the ledger with the source
+func IncreaseUserQuota(id int, quota int64, source LedgerSource) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if config.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addLedgerRecord(id, quota, source)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, id, quota, source)
+	})
 }
 
 func increaseUserQuota(id int, quota int64) (err error) {
@@ -387,20 +425,21 @@ func increaseUserQuota(id int, quota int64) (err error) {
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int64) (err error) {
+func DecreaseUserQuota(id int, quota int64, source LedgerSource) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
 	if config.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+		addLedgerRecord(id, -quota, source)
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
-}
-
-func decreaseUserQuota(id int, quota int64) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	return err
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, id, -quota, source)
+	})
 }
 
 func GetRootUserEmail() (email string) {
@@ -74,5 +74,6 @@ func batchUpdate() {
 			}
 		}
 	}
+	flushLedgerBuffer()
 	logger.SysLog("batch update finished")
 }
@@ -2,6 +2,7 @@ package monitor
 
 import (
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/metrics"
 )
 
 var store = make(map[int][]bool)
@@ -66,6 +67,7 @@ func init() {
 }
 
 func Emit(channelId int, success bool) {
+	metrics.ObserveChannelResult(channelId, success)
 	if !config.EnableMetric {
 		return
 	}
@@ -11,3 +11,6 @@ var ModelList = []string{
 	"claude-3-5-sonnet-20241022",
 	"claude-3-5-sonnet-latest",
 }
+
+// minThinkingBudget is the smallest budget_tokens accepted for extended thinking
+const minThinkingBudget = 1024
@@ -11,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -49,6 +50,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 					Properties: params["properties"],
 					Required:   params["required"],
 				},
+				CacheControl: tool.CacheControl,
 			})
 		}
 	}
@@ -82,6 +84,20 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 	if claudeRequest.MaxTokens == 0 {
 		claudeRequest.MaxTokens = 4096
 	}
+	if budget, ok := textRequest.GetThinkingBudget(); ok && budget > 0 {
+		if budget < minThinkingBudget {
+			budget = minThinkingBudget
+		}
+		claudeRequest.Thinking = &model.Thinking{Type: "enabled", BudgetTokens: budget}
+		// max_tokens includes the thinking budget
+		if claudeRequest.MaxTokens <= budget {
+			claudeRequest.MaxTokens += budget
+		}
+		// temperature, top_p and top_k are not compatible with thinking
+		claudeRequest.Temperature = nil
+		claudeRequest.TopP = nil
+		claudeRequest.TopK = 0
+	}
 	// legacy model name mapping
 	if claudeRequest.Model == "claude-instant-1" {
 		claudeRequest.Model = "claude-instant-1.1"
@@ -89,8 +105,8 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 		claudeRequest.Model = "claude-2.1"
 	}
 	for _, message := range textRequest.Messages {
-		if message.Role == "system" && claudeRequest.System == "" {
-			claudeRequest.System = message.StringContent()
+		if message.Role == "system" && claudeRequest.System == nil {
+			claudeRequest.System = convertSystem(message)
 			continue
 		}
 		claudeMessage := Message{
@@ -124,7 +140,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 		var contents []Content
 		openaiContent := message.ParseContent()
 		for _, part := range openaiContent {
-			var content Content
+			content := Content{CacheControl: part.CacheControl}
 			if part.Type == model.ContentTypeText {
 				content.Type = "text"
 				content.Text = part.Text
@@ -145,10 +161,78 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 	return &claudeRequest
 }
 
+// convertSystem keeps the system prompt as text contents when they carry cache_control markers
+func convertSystem(message model.Message) any {
+	if message.IsStringContent() {
+		return message.StringContent()
+	}
+	var contents []Content
+	cached := false
+	for _, part := range message.ParseContent() {
+		if part.Type != model.ContentTypeText {
+			continue
+		}
+		contents = append(contents, Content{Type: "text", Text: part.Text, CacheControl: part.CacheControl})
+		if part.CacheControl != nil {
+			cached = true
+		}
+	}
+	if !cached {
+		return message.StringContent()
+	}
+	return contents
+}
+
+// SetReasoningTokens estimates the thinking tokens, which Anthropic counts into the output tokens without a breakdown.
+func SetReasoningTokens(usage *model.Usage, reasoningText string, modelName string) {
+	if reasoningText == "" {
+		return
+	}
+	reasoningTokens := openai.CountTokenText(reasoningText, modelName)
+	if reasoningTokens > usage.CompletionTokens {
+		reasoningTokens = usage.CompletionTokens
+	}
+	usage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: reasoningTokens}
+}
+
+// ResponseUsage2OpenAI counts the cache reads and writes into the prompt tokens like OpenAI does.
+func ResponseUsage2OpenAI(claudeUsage *Usage) *model.Usage {
+	promptTokens := claudeUsage.InputTokens + claudeUsage.CacheReadInputTokens + claudeUsage.CacheCreationInputTokens
+	usage := &model.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: claudeUsage.OutputTokens,
+		TotalTokens:      promptTokens + claudeUsage.OutputTokens,
+	}
+	if claudeUsage.CacheReadInputTokens > 0 || claudeUsage.CacheCreationInputTokens > 0 {
+		usage.PromptTokensDetails = &model.PromptTokensDetails{
+			CachedTokens:        claudeUsage.CacheReadInputTokens,
+			CacheCreationTokens: claudeUsage.CacheCreationInputTokens,
+		}
+	}
+	return usage
+}
+
+// MergeStreamUsage merges the usage of message_start and message_delta events, their counts are cumulative.
+func MergeStreamUsage(usage *Usage, delta *Usage) {
+	if delta.InputTokens > usage.InputTokens {
+		usage.InputTokens = delta.InputTokens
+	}
+	if delta.OutputTokens > usage.OutputTokens {
+		usage.OutputTokens = delta.OutputTokens
+	}
+	if delta.CacheCreationInputTokens > usage.CacheCreationInputTokens {
+		usage.CacheCreationInputTokens = delta.CacheCreationInputTokens
+	}
+	if delta.CacheReadInputTokens > usage.CacheReadInputTokens {
+		usage.CacheReadInputTokens = delta.CacheReadInputTokens
+	}
+}
+
 // https://docs.anthropic.com/claude/reference/messages-streaming
 func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCompletionsStreamResponse, *Response) {
 	var response *Response
 	var responseText string
+	var reasoningText string
 	var stopReason string
 	tools := make([]model.Tool, 0)
 
@@ -158,6 +242,7 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	case "content_block_start":
 		if claudeResponse.ContentBlock != nil {
 			responseText = claudeResponse.ContentBlock.Text
+			reasoningText = claudeResponse.ContentBlock.Thinking
 			if claudeResponse.ContentBlock.Type == "tool_use" {
 				tools = append(tools, model.Tool{
 					Id:   claudeResponse.ContentBlock.Id,
@@ -172,6 +257,7 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	case "content_block_delta":
 		if claudeResponse.Delta != nil {
 			responseText = claudeResponse.Delta.Text
+			reasoningText = claudeResponse.Delta.Thinking
 			if claudeResponse.Delta.Type == "input_json_delta" {
 				tools = append(tools, model.Tool{
 					Function: model.Function{
@@ -192,6 +278,10 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	}
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = responseText
+	if reasoningText != "" {
+		choice.Delta.Content = nil
+		choice.Delta.ReasoningContent = reasoningText
+	}
 	if len(tools) > 0 {
 		choice.Delta.Content = nil // compatible with other OpenAI derivative applications, like LobeOpenAICompatibleFactory ...
 		choice.Delta.ToolCalls = tools
@@ -209,12 +299,15 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 
 func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 	var responseText string
-	if len(claudeResponse.Content) > 0 {
-		responseText = claudeResponse.Content[0].Text
-	}
+	var reasoningText string
 	tools := make([]model.Tool, 0)
 	for _, v := range claudeResponse.Content {
-		if v.Type == "tool_use" {
+		switch v.Type {
+		case "text":
+			responseText += v.Text
+		case "thinking":
+			reasoningText += v.Thinking
+		case "tool_use":
 			args, _ := json.Marshal(v.Input)
 			tools = append(tools, model.Tool{
 				Id:   v.Id,
@@ -236,6 +329,9 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 		},
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
+	if reasoningText != "" {
+		choice.Message.ReasoningContent = reasoningText
+	}
 	fullTextResponse := openai.TextResponse{
 		Id:      fmt.Sprintf("chatcmpl-%s", claudeResponse.Id),
 		Model:   claudeResponse.Model,
@@ -264,10 +360,11 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	common.SetEventStreamHeaders(c)
 
-	var usage model.Usage
+	var claudeUsage Usage
 	var modelName string
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
+	var reasoningText string
 
 	for scanner.Scan() {
 		data := scanner.Text()
@@ -286,8 +383,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 		response, meta := StreamResponseClaude2OpenAI(&claudeResponse)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
+			MergeStreamUsage(&claudeUsage, &meta.Usage)
 			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 				modelName = meta.Model
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
@@ -315,6 +411,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			if len(choice.Delta.ToolCalls) > 0 {
 				lastToolCallChoice = choice
 			}
+			reasoningText += conv.AsString(choice.Delta.ReasoningContent)
 		}
 		err = render.ObjectData(c, response)
 		if err != nil {
@@ -332,7 +429,9 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	if err != nil {
 		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
-	return nil, &usage
+	usage := ResponseUsage2OpenAI(&claudeUsage)
+	SetReasoningTokens(usage, reasoningText, modelName)
+	return nil, usage
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -362,12 +461,9 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse)
 	fullTextResponse.Model = modelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	fullTextResponse.Usage = usage
+	usage := ResponseUsage2OpenAI(&claudeResponse.Usage)
+	SetReasoningTokens(usage, conv.AsString(fullTextResponse.Choices[0].Message.ReasoningContent), modelName)
+	fullTextResponse.Usage = *usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -375,5 +471,5 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(jsonResponse)
-	return nil, &usage
+	return nil, usage
 }
@@ -0,0 +1,66 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestResponseUsage2OpenAI(t *testing.T) {
+	Convey("TestResponseUsage2OpenAI", t, func() {
+		Convey("counts the cache reads and writes into the prompt tokens", func() {
+			usage := ResponseUsage2OpenAI(&Usage{InputTokens: 100, OutputTokens: 50, CacheReadInputTokens: 1000, CacheCreationInputTokens: 200})
+			So(usage.PromptTokens, ShouldEqual, 1300)
+			So(usage.CompletionTokens, ShouldEqual, 50)
+			So(usage.TotalTokens, ShouldEqual, 1350)
+			So(usage.GetCachedTokens(), ShouldEqual, 1000)
+			So(usage.GetCacheCreationTokens(), ShouldEqual, 200)
+		})
+
+		Convey("leaves out the details without caching", func() {
+			usage := ResponseUsage2OpenAI(&Usage{InputTokens: 100, OutputTokens: 50})
+			So(usage.PromptTokensDetails, ShouldBeNil)
+			So(usage.TotalTokens, ShouldEqual, 150)
+		})
+	})
+}
+
+func TestMergeStreamUsage(t *testing.T) {
+	Convey("TestMergeStreamUsage", t, func() {
+		// message_start carries the input and the cache counts, message_delta the cumulative output
+		usage := &Usage{}
+		MergeStreamUsage(usage, &Usage{InputTokens: 100, OutputTokens: 1, CacheReadInputTokens: 1000, CacheCreationInputTokens: 200})
+		MergeStreamUsage(usage, &Usage{OutputTokens: 30})
+		MergeStreamUsage(usage, &Usage{OutputTokens: 50})
+		So(*usage, ShouldResemble, Usage{InputTokens: 100, OutputTokens: 50, CacheReadInputTokens: 1000, CacheCreationInputTokens: 200})
+	})
+}
+
+func TestConvertRequestCacheControl(t *testing.T) {
+	Convey("TestConvertRequestCacheControl", t, func() {
+		var request model.GeneralOpenAIRequest
+		So(json.Unmarshal([]byte(`{
+			"model": "claude-3-5-sonnet-20241022",
+			"messages": [
+				{"role": "system", "content": [{"type": "text", "text": "long instructions", "cache_control": {"type": "ephemeral"}}]},
+				{"role": "user", "content": [{"type": "text", "text": "long document", "cache_control": {"type": "ephemeral"}}, {"type": "text", "text": "question"}]}
+			]
+		}`), &request), ShouldBeNil)
+
+		claudeRequest := ConvertRequest(request)
+		system, ok := claudeRequest.System.([]Content)
+		So(ok, ShouldBeTrue)
+		So(system[0].Text, ShouldEqual, "long instructions")
+		So(system[0].CacheControl, ShouldResemble, map[string]any{"type": "ephemeral"})
+		So(claudeRequest.Messages[0].Content[0].CacheControl, ShouldResemble, map[string]any{"type": "ephemeral"})
+		So(claudeRequest.Messages[0].Content[1].CacheControl, ShouldBeNil)
+
+		Convey("keeps a plain system prompt without markers", func() {
+			request.Messages[0].Content = "short instructions"
+			So(ConvertRequest(request).System, ShouldEqual, "short instructions")
+		})
+	})
+}
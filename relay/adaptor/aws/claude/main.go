@@ -15,6 +15,7 @@ import (
 	"github.com/jinzhu/copier"
 	"github.com/pkg/errors"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -88,15 +89,12 @@ func Handler(c *gin.Context, awsCli *bedrockruntime.Client, modelName string) (*
 
 	openaiResp := anthropic.ResponseClaude2OpenAI(claudeResponse)
 	openaiResp.Model = modelName
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	openaiResp.Usage = usage
+	usage := anthropic.ResponseUsage2OpenAI(&claudeResponse.Usage)
+	anthropic.SetReasoningTokens(usage, conv.AsString(openaiResp.Choices[0].Message.ReasoningContent), modelName)
+	openaiResp.Usage = *usage
 
 	c.JSON(http.StatusOK, openaiResp)
-	return nil, &usage
+	return nil, usage
 }
 
 func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
@@ -137,9 +135,10 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 	defer stream.Close()
 
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	var usage relaymodel.Usage
+	var claudeUsage anthropic.Usage
 	var id string
 	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
+	var reasoningText string
 
 	c.Stream(func(w io.Writer) bool {
 		event, ok := <-stream.Events()
@@ -159,8 +158,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 
 			response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp)
 			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
+				anthropic.MergeStreamUsage(&claudeUsage, &meta.Usage)
 				if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 					id = fmt.Sprintf("chatcmpl-%s", meta.Id)
 					return true
@@ -186,6 +184,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 				if len(choice.Delta.ToolCalls) > 0 {
 					lastToolCallChoice = choice
 				}
+				reasoningText += conv.AsString(choice.Delta.ReasoningContent)
 			}
 			jsonStr, err := json.Marshal(response)
 			if err != nil {
@@ -203,5 +202,7 @@ func StreamHandler(c *gin.Context, awsCli *bedrockruntime.Client) (*relaymodel.E
 		}
 	})
 
-	return nil, &usage
+	usage := anthropic.ResponseUsage2OpenAI(&claudeUsage)
+	anthropic.SetReasoningTokens(usage, reasoningText, c.GetString(ctxkey.RequestModel))
+	return nil, usage
 }
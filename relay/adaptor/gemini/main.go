@@ -12,6 +12,7 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -66,6 +67,12 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 			MaxOutputTokens: textRequest.MaxTokens,
 		},
 	}
+	if budget, ok := textRequest.GetThinkingBudget(); ok {
+		geminiRequest.GenerationConfig.ThinkingConfig = &ThinkingConfig{
+			ThinkingBudget:  &budget,
+			IncludeThoughts: budget > 0,
+		}
+	}
 	if textRequest.ResponseFormat != nil {
 		if mimeType, ok := mimeTypeMap[textRequest.ResponseFormat.Type]; ok {
 			geminiRequest.GenerationConfig.ResponseMimeType = mimeType
@@ -187,18 +194,98 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *BatchEmbedding
 type ChatResponse struct {
 	Candidates     []ChatCandidate    `json:"candidates"`
 	PromptFeedback ChatPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *UsageMetadata     `json:"usageMetadata,omitempty"`
+}
+
+// UsageMetadata counts the cached content into the prompt tokens, and the thoughts apart from the candidates.
+type UsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+
+	PromptTokensDetails     []ModalityTokenCount `json:"promptTokensDetails,omitempty"`
+	CandidatesTokensDetails []ModalityTokenCount `json:"candidatesTokensDetails,omitempty"`
+}
+
+type ModalityTokenCount struct {
+	Modality   string `json:"modality"` // TEXT, IMAGE, AUDIO or VIDEO
+	TokenCount int    `json:"tokenCount"`
+}
+
+func countModalityTokens(details []ModalityTokenCount, modality string) int {
+	count := 0
+	for _, detail := range details {
+		if detail.Modality == modality {
+			count += detail.TokenCount
+		}
+	}
+	return count
+}
+
+// toUsage returns nil when the response doesn't report the usage.
+func (u *UsageMetadata) toUsage() *model.Usage {
+	if u == nil || u.PromptTokenCount == 0 {
+		return nil
+	}
+	completionTokens := u.CandidatesTokenCount + u.ThoughtsTokenCount
+	usage := &model.Usage{
+		PromptTokens:     u.PromptTokenCount,
+		CompletionTokens: completionTokens,
+		TotalTokens:      u.PromptTokenCount + completionTokens,
+	}
+	promptDetails := model.PromptTokensDetails{
+		CachedTokens: u.CachedContentTokenCount,
+		AudioTokens:  countModalityTokens(u.PromptTokensDetails, "AUDIO"),
+		// video is billed as images
+		ImageTokens: countModalityTokens(u.PromptTokensDetails, "IMAGE") + countModalityTokens(u.PromptTokensDetails, "VIDEO"),
+	}
+	if promptDetails != (model.PromptTokensDetails{}) {
+		usage.PromptTokensDetails = &promptDetails
+	}
+	completionDetails := model.CompletionTokensDetails{
+		ReasoningTokens: u.ThoughtsTokenCount,
+		AudioTokens:     countModalityTokens(u.CandidatesTokensDetails, "AUDIO"),
+		ImageTokens:     countModalityTokens(u.CandidatesTokensDetails, "IMAGE"),
+	}
+	if completionDetails != (model.CompletionTokensDetails{}) {
+		usage.CompletionTokensDetails = &completionDetails
+	}
+	return usage
 }
 
 func (g *ChatResponse) GetResponseText() string {
 	if g == nil {
 		return ""
 	}
-	if len(g.Candidates) > 0 && len(g.Candidates[0].Content.Parts) > 0 {
-		return g.Candidates[0].Content.Parts[0].Text
+	if len(g.Candidates) > 0 {
+		_, text := splitThoughtParts(g.Candidates[0].Content.Parts)
+		return text
 	}
 	return ""
 }
 
+func (g *ChatResponse) GetReasoningText() string {
+	if g == nil || len(g.Candidates) == 0 {
+		return ""
+	}
+	reasoning, _ := splitThoughtParts(g.Candidates[0].Content.Parts)
+	return reasoning
+}
+
+// splitThoughtParts returns the text of the thought parts and of the other parts.
+func splitThoughtParts(parts []Part) (reasoning string, text string) {
+	for _, part := range parts {
+		if part.Thought {
+			reasoning += part.Text
+		} else {
+			text += part.Text
+		}
+	}
+	return reasoning, text
+}
+
 type ChatCandidate struct {
 	Content       ChatContent        `json:"content"`
 	FinishReason  string             `json:"finishReason"`
@@ -254,6 +341,17 @@ func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 			},
 			FinishReason: constant.StopFinishReason,
 		}
+		reasoning, _ := splitThoughtParts(candidate.Content.Parts)
+		if reasoning != "" {
+			choice.Message.ReasoningContent = reasoning
+			parts := make([]Part, 0, len(candidate.Content.Parts))
+			for _, part := range candidate.Content.Parts {
+				if !part.Thought {
+					parts = append(parts, part)
+				}
+			}
+			candidate.Content.Parts = parts
+		}
 		if len(candidate.Content.Parts) > 0 {
 			if candidate.Content.Parts[0].FunctionCall != nil {
 				choice.Message.ToolCalls = getToolCalls(&candidate)
@@ -279,6 +377,9 @@ func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 func streamResponseGeminiChat2OpenAI(geminiResponse *ChatResponse) *openai.ChatCompletionsStreamResponse {
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = geminiResponse.GetResponseText()
+	if reasoning := geminiResponse.GetReasoningText(); reasoning != "" {
+		choice.Delta.ReasoningContent = reasoning
+	}
 	//choice.FinishReason = &constant.StopFinishReason
 	var response openai.ChatCompletionsStreamResponse
 	response.Id = fmt.Sprintf("chatcmpl-%s", random.GetUUID())
@@ -306,8 +407,10 @@ func embeddingResponseGemini2OpenAI(response *EmbeddingResponse) *openai.Embeddi
 	return &openAIEmbeddingResponse
 }
 
-func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
+// StreamHandler returns the usage of the last chunk, or nil when the chunks don't report it.
+func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string, *model.Usage) {
 	responseText := ""
+	var usage *model.Usage
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 
@@ -328,13 +431,16 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			logger.SysError("error unmarshalling stream response: " + err.Error())
 			continue
 		}
+		if chunkUsage := geminiResponse.UsageMetadata.toUsage(); chunkUsage != nil {
+			usage = chunkUsage
+		}
 
 		response := streamResponseGeminiChat2OpenAI(&geminiResponse)
 		if response == nil {
 			continue
 		}
 
-		responseText += response.Choices[0].Delta.StringContent()
+		responseText += response.Choices[0].Delta.StringContent() + conv.AsString(response.Choices[0].Delta.ReasoningContent)
 
 		err = render.ObjectData(c, response)
 		if err != nil {
@@ -350,10 +456,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	err := resp.Body.Close()
 	if err != nil {
-		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), ""
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
 
-	return nil, responseText
+	return nil, responseText, usage
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -383,13 +489,16 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(&geminiResponse)
 	fullTextResponse.Model = modelName
-	completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
-	usage := model.Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
+	usage := geminiResponse.UsageMetadata.toUsage()
+	if usage == nil {
+		completionTokens := openai.CountTokenText(geminiResponse.GetReasoningText()+geminiResponse.GetResponseText(), modelName)
+		usage = &model.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
 	}
-	fullTextResponse.Usage = usage
+	fullTextResponse.Usage = *usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -397,7 +506,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(jsonResponse)
-	return nil, &usage
+	return nil, usage
 }
 
 func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
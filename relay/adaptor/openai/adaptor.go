@@ -92,6 +92,7 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.G
 		}
 		request.StreamOptions.IncludeUsage = true
 	}
+	convertReasoningRequest(a.ChannelType, request)
 	return request, nil
 }
 
@@ -111,7 +112,12 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 		var responseText string
 		err, responseText, usage = StreamHandler(c, resp, meta.Mode)
 		if usage == nil || usage.TotalTokens == 0 {
+			serviceTier := ""
+			if usage != nil {
+				serviceTier = usage.ServiceTier
+			}
 			usage = ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+			usage.ServiceTier = serviceTier
 		}
 		if usage.TotalTokens != 0 && usage.PromptTokens == 0 { // some channels don't return prompt tokens & completion tokens
 			usage.PromptTokens = meta.PromptTokens
@@ -29,10 +29,12 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 	var usage *model.Usage
+	serviceTier := ""
+	thinkParser := newThinkTagParser()
+	var lastStreamResponse *ChatCompletionsStreamResponse
 
 	common.SetEventStreamHeaders(c)
 
-	doneRendered := false
 	for scanner.Scan() {
 		data := scanner.Text()
 		if len(data) < dataPrefixLength { // ignore blank line or wrong format
@@ -42,8 +44,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 			continue
 		}
 		if strings.HasPrefix(data[dataPrefixLength:], done) {
-			render.StringData(c, data)
-			doneRendered = true
+			// rendered after the text held back by the reasoning parser
 			continue
 		}
 		switch relayMode {
@@ -59,13 +60,22 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 				// but for empty choice and no usage, we should not pass it to client, this is for azure
 				continue // just ignore empty choice
 			}
-			render.StringData(c, data)
+			normalized := normalizeStreamReasoning(thinkParser, &streamResponse)
 			for _, choice := range streamResponse.Choices {
-				responseText += conv.AsString(choice.Delta.Content)
+				responseText += conv.AsString(choice.Delta.Content) + conv.AsString(choice.Delta.ReasoningContent)
 			}
 			if streamResponse.Usage != nil {
 				usage = streamResponse.Usage
 			}
+			if streamResponse.ServiceTier != "" {
+				serviceTier = streamResponse.ServiceTier
+			}
+			lastStreamResponse = &streamResponse
+			if normalized {
+				renderStreamResponse(c, &streamResponse)
+			} else {
+				render.StringData(c, data)
+			}
 		case relaymode.Completions:
 			render.StringData(c, data)
 			var streamResponse CompletionsStreamResponse
@@ -84,15 +94,27 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 		logger.SysError("error reading stream: " + err.Error())
 	}
 
-	if !doneRendered {
-		render.Done(c)
+	if lastStreamResponse != nil {
+		if streamResponse := flushStreamReasoning(thinkParser, lastStreamResponse); streamResponse != nil {
+			for _, choice := range streamResponse.Choices {
+				responseText += conv.AsString(choice.Delta.Content) + conv.AsString(choice.Delta.ReasoningContent)
+			}
+			renderStreamResponse(c, streamResponse)
+		}
 	}
+	render.Done(c)
 
 	err := resp.Body.Close()
 	if err != nil {
 		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
 
+	if serviceTier != "" {
+		if usage == nil {
+			usage = &model.Usage{}
+		}
+		usage.ServiceTier = serviceTier
+	}
 	return nil, responseText, usage
 }
 
@@ -116,6 +138,10 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 			StatusCode: resp.StatusCode,
 		}, nil
 	}
+	if normalizedBody, modified := normalizeResponseReasoning(responseBody); modified {
+		responseBody = normalizedBody
+		resp.Header.Del("Content-Length")
+	}
 	// Reset response body
 	resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 
@@ -147,5 +173,13 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 			TotalTokens:      promptTokens + completionTokens,
 		}
 	}
+	textResponse.Usage.ServiceTier = textResponse.ServiceTier
 	return nil, &textResponse.Usage
 }
+
+func renderStreamResponse(c *gin.Context, streamResponse *ChatCompletionsStreamResponse) {
+	err := render.ObjectData(c, streamResponse)
+	if err != nil {
+		logger.SysError(err.Error())
+	}
+}
@@ -0,0 +1,45 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func newUpstreamResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+}
+
+func TestServiceTierOfResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	Convey("TestServiceTierOfResponse", t, func() {
+		Convey("reads the service tier of a response", func() {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			body := `{"choices":[{"index":0,"message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":5,"completion_tokens":1,"total_tokens":6},"service_tier":"flex"}`
+			err, usage := Handler(c, newUpstreamResponse(body), 5, "o3")
+			So(err, ShouldBeNil)
+			So(usage.ServiceTier, ShouldEqual, "flex")
+		})
+
+		Convey("reads the service tier of a stream and passes it through", func() {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			body := "data: {\"id\":\"1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"<think>a</think>b\"}}],\"service_tier\":\"priority\"}\n\n" +
+				"data: {\"id\":\"1\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":1,\"total_tokens\":6},\"service_tier\":\"priority\"}\n\n" +
+				"data: [DONE]\n\n"
+			err, _, usage := StreamHandler(c, newUpstreamResponse(body), relaymode.ChatCompletions)
+			So(err, ShouldBeNil)
+			So(usage.ServiceTier, ShouldEqual, "priority")
+			So(usage.TotalTokens, ShouldEqual, 6)
+			So(recorder.Body.String(), ShouldContainSubstring, `"reasoning_content":"a"`)
+			So(strings.Count(recorder.Body.String(), `"service_tier":"priority"`), ShouldEqual, 2)
+		})
+	})
+}
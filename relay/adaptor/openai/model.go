@@ -80,6 +80,7 @@ type SlimTextResponse struct {
 	Choices     []TextResponseChoice `json:"choices"`
 	model.Usage `json:"usage"`
 	Error       model.Error `json:"error"`
+	ServiceTier string      `json:"service_tier,omitempty"`
 }
 
 type TextResponseChoice struct {
@@ -95,6 +96,7 @@ type TextResponse struct {
 	Created     int64                `json:"created"`
 	Choices     []TextResponseChoice `json:"choices"`
 	model.Usage `json:"usage"`
+	ServiceTier string `json:"service_tier,omitempty"`
 }
 
 type EmbeddingResponseItem struct {
@@ -129,12 +131,13 @@ type ChatCompletionsStreamResponseChoice struct {
 }
 
 type ChatCompletionsStreamResponse struct {
-	Id      string                                `json:"id"`
-	Object  string                                `json:"object"`
-	Created int64                                 `json:"created"`
-	Model   string                                `json:"model"`
-	Choices []ChatCompletionsStreamResponseChoice `json:"choices"`
-	Usage   *model.Usage                          `json:"usage,omitempty"`
+	Id          string                                `json:"id"`
+	Object      string                                `json:"object"`
+	Created     int64                                 `json:"created"`
+	Model       string                                `json:"model"`
+	Choices     []ChatCompletionsStreamResponseChoice `json:"choices"`
+	Usage       *model.Usage                          `json:"usage,omitempty"`
+	ServiceTier string                                `json:"service_tier,omitempty"`
 }
 
 type CompletionsStreamResponse struct {
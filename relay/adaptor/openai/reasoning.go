@@ -0,0 +1,251 @@
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+const (
+	thinkStartTag = "<think>"
+	thinkEndTag   = "</think>"
+)
+
+const (
+	thinkPhaseDetecting = iota // waiting for the first non-blank text
+	thinkPhaseInside
+	thinkPhaseDone
+)
+
+type thinkState struct {
+	phase  int
+	buffer string
+}
+
+// thinkTagParser moves the <think> block leading the content of DeepSeek-R1 style models into the reasoning,
+// it keeps a state per choice since the tags may be split across chunks.
+type thinkTagParser struct {
+	states map[int]*thinkState
+}
+
+func newThinkTagParser() *thinkTagParser {
+	return &thinkTagParser{states: make(map[int]*thinkState)}
+}
+
+// Process returns the reasoning and the content of a delta, text which may be part of a tag is held back.
+func (p *thinkTagParser) Process(index int, delta string) (reasoning string, content string) {
+	state, ok := p.states[index]
+	if !ok {
+		state = &thinkState{}
+		p.states[index] = state
+	}
+	switch state.phase {
+	case thinkPhaseDetecting:
+		state.buffer += delta
+		trimmed := strings.TrimLeft(state.buffer, " \t\r\n")
+		if trimmed == "" || strings.HasPrefix(thinkStartTag, trimmed) {
+			return "", ""
+		}
+		if !strings.HasPrefix(trimmed, thinkStartTag) {
+			state.phase = thinkPhaseDone
+			content, state.buffer = state.buffer, ""
+			return "", content
+		}
+		state.phase = thinkPhaseInside
+		state.buffer = ""
+		return p.Process(index, strings.TrimPrefix(trimmed, thinkStartTag))
+	case thinkPhaseInside:
+		state.buffer += delta
+		if i := strings.Index(state.buffer, thinkEndTag); i >= 0 {
+			state.phase = thinkPhaseDone
+			reasoning = state.buffer[:i]
+			content = strings.TrimLeft(state.buffer[i+len(thinkEndTag):], "\r\n")
+			state.buffer = ""
+			return reasoning, content
+		}
+		held := partialSuffixLength(state.buffer, thinkEndTag)
+		reasoning = state.buffer[:len(state.buffer)-held]
+		state.buffer = state.buffer[len(state.buffer)-held:]
+		return reasoning, ""
+	}
+	return "", delta
+}
+
+// Flush returns the text held back for a finished choice.
+func (p *thinkTagParser) Flush(index int) (reasoning string, content string) {
+	state, ok := p.states[index]
+	if !ok {
+		return "", ""
+	}
+	delete(p.states, index)
+	if state.phase == thinkPhaseInside {
+		return state.buffer, ""
+	}
+	return "", state.buffer
+}
+
+// partialSuffixLength returns the length of the longest suffix of text which is a prefix of tag.
+func partialSuffixLength(text string, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// normalizeStreamReasoning moves the reasoning of a chunk into reasoning_content, it returns true when the chunk is changed.
+func normalizeStreamReasoning(parser *thinkTagParser, streamResponse *ChatCompletionsStreamResponse) bool {
+	modified := false
+	for i := range streamResponse.Choices {
+		choice := &streamResponse.Choices[i]
+		if moveReasoningField(&choice.Delta) {
+			modified = true
+		}
+		if !choice.Delta.IsStringContent() && choice.Delta.Content != nil {
+			continue
+		}
+		delta := choice.Delta.StringContent()
+		reasoning, content := parser.Process(choice.Index, delta)
+		if choice.FinishReason != nil {
+			flushedReasoning, flushedContent := parser.Flush(choice.Index)
+			reasoning += flushedReasoning
+			content += flushedContent
+		}
+		if reasoning == "" && content == delta {
+			continue
+		}
+		modified = true
+		choice.Delta.Content = content
+		if reasoning != "" {
+			choice.Delta.ReasoningContent = conv.AsString(choice.Delta.ReasoningContent) + reasoning
+		}
+	}
+	return modified
+}
+
+// normalizeResponseReasoning moves the reasoning of a non-stream response body into reasoning_content,
+// the original body is returned when nothing is changed.
+func normalizeResponseReasoning(responseBody []byte) ([]byte, bool) {
+	if !strings.Contains(string(responseBody), thinkStartTag) && !strings.Contains(string(responseBody), `"reasoning"`) {
+		return responseBody, false
+	}
+	var textResponse TextResponse
+	err := json.Unmarshal(responseBody, &textResponse)
+	if err != nil {
+		return responseBody, false
+	}
+	modified := false
+	for i := range textResponse.Choices {
+		message := &textResponse.Choices[i].Message
+		if moveReasoningField(message) {
+			modified = true
+		}
+		if !message.IsStringContent() {
+			continue
+		}
+		parser := newThinkTagParser()
+		reasoning, content := parser.Process(0, message.StringContent())
+		flushedReasoning, flushedContent := parser.Flush(0)
+		reasoning += flushedReasoning
+		content += flushedContent
+		if reasoning == "" {
+			continue
+		}
+		modified = true
+		message.Content = content
+		message.ReasoningContent = conv.AsString(message.ReasoningContent) + reasoning
+	}
+	if !modified {
+		return responseBody, false
+	}
+	jsonResponse, err := json.Marshal(textResponse)
+	if err != nil {
+		return responseBody, false
+	}
+	return jsonResponse, true
+}
+
+func moveReasoningField(message *model.Message) bool {
+	if message.Reasoning == nil {
+		return false
+	}
+	if message.ReasoningContent == nil && *message.Reasoning != "" {
+		message.ReasoningContent = *message.Reasoning
+	}
+	message.Reasoning = nil
+	return true
+}
+
+// flushStreamReasoning returns a chunk with the text held back for choices without a finish reason, or nil.
+func flushStreamReasoning(parser *thinkTagParser, lastStreamResponse *ChatCompletionsStreamResponse) *ChatCompletionsStreamResponse {
+	var choices []ChatCompletionsStreamResponseChoice
+	for index := range parser.states {
+		reasoning, content := parser.Flush(index)
+		if reasoning == "" && content == "" {
+			continue
+		}
+		choice := ChatCompletionsStreamResponseChoice{
+			Index: index,
+			Delta: model.Message{Content: content},
+		}
+		if reasoning != "" {
+			choice.Delta.ReasoningContent = reasoning
+		}
+		choices = append(choices, choice)
+	}
+	if len(choices) == 0 {
+		return nil
+	}
+	return &ChatCompletionsStreamResponse{
+		Id:      lastStreamResponse.Id,
+		Object:  lastStreamResponse.Object,
+		Created: lastStreamResponse.Created,
+		Model:   lastStreamResponse.Model,
+		Choices: choices,
+	}
+}
+
+// isReasoningModel reports whether an OpenAI model takes reasoning_effort, the other models reject the field.
+func isReasoningModel(modelName string) bool {
+	name := strings.ToLower(modelName)
+	if strings.HasPrefix(name, "gpt-5") {
+		return !strings.HasPrefix(name, "gpt-5-chat")
+	}
+	// o1, o3-mini, o4-mini and so on
+	return len(name) >= 2 && name[0] == 'o' && name[1] >= '1' && name[1] <= '9'
+}
+
+// convertReasoningRequest maps the thinking budget and the OpenRouter reasoning config to the format of the channel,
+// other OpenAI compatible channels receive the fields as is.
+func convertReasoningRequest(channelType int, request *model.GeneralOpenAIRequest) {
+	if request.Thinking == nil && request.Reasoning == nil && request.ReasoningEffort == nil {
+		return
+	}
+	switch channelType {
+	case channeltype.OpenAI, channeltype.Azure:
+		effort := request.GetReasoningEffort()
+		request.ReasoningEffort = nil
+		if effort != "" && effort != model.ReasoningEffortNone && isReasoningModel(request.Model) {
+			request.ReasoningEffort = &effort
+		}
+		request.Thinking = nil
+		request.Reasoning = nil
+	case channeltype.OpenRouter:
+		if request.Reasoning == nil {
+			budget, _ := request.GetThinkingBudget()
+			switch {
+			case budget == 0: // thinking disabled
+			case request.Thinking != nil:
+				request.Reasoning = &model.Reasoning{MaxTokens: budget}
+			default:
+				request.Reasoning = &model.Reasoning{Effort: request.GetReasoningEffort()}
+			}
+		}
+		request.Thinking = nil
+		request.ReasoningEffort = nil
+	}
+}
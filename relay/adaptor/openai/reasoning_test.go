@@ -0,0 +1,158 @@
+package openai
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+type thinkDelta struct {
+	index int
+	text  string
+}
+
+func TestThinkTagParser(t *testing.T) {
+	tests := []struct {
+		name      string
+		deltas    []thinkDelta
+		reasoning map[int]string
+		content   map[int]string
+	}{
+		{
+			name:      "whole tags",
+			deltas:    []thinkDelta{{0, "<think>plan</think>\n\nanswer"}},
+			reasoning: map[int]string{0: "plan"},
+			content:   map[int]string{0: "answer"},
+		},
+		{
+			name:      "tags split across chunks",
+			deltas:    []thinkDelta{{0, "\n<th"}, {0, "ink>pl"}, {0, "an</thi"}, {0, "nk>\nans"}, {0, "wer"}},
+			reasoning: map[int]string{0: "plan"},
+			content:   map[int]string{0: "answer"},
+		},
+		{
+			name:      "content without tags",
+			deltas:    []thinkDelta{{0, "<th"}, {0, "e answer"}},
+			reasoning: map[int]string{},
+			content:   map[int]string{0: "<the answer"},
+		},
+		{
+			name:      "tags in the middle of the content",
+			deltas:    []thinkDelta{{0, "answer <think>not reasoning</think>"}},
+			reasoning: map[int]string{},
+			content:   map[int]string{0: "answer <think>not reasoning</think>"},
+		},
+		{
+			name:      "multiple choices",
+			deltas:    []thinkDelta{{0, "<think>a"}, {1, "<thi"}, {1, "nk>b</think>y"}, {0, "</think>x"}},
+			reasoning: map[int]string{0: "a", 1: "b"},
+			content:   map[int]string{0: "x", 1: "y"},
+		},
+		{
+			name:      "flush of an unterminated reasoning",
+			deltas:    []thinkDelta{{0, "<think>cut off</th"}},
+			reasoning: map[int]string{0: "cut off</th"},
+			content:   map[int]string{},
+		},
+		{
+			name:      "flush of a partial start tag",
+			deltas:    []thinkDelta{{0, " <thin"}},
+			reasoning: map[int]string{},
+			content:   map[int]string{0: " <thin"},
+		},
+	}
+
+	Convey("TestThinkTagParser", t, func() {
+		for _, test := range tests {
+			Convey(test.name, func() {
+				parser := newThinkTagParser()
+				reasoning := map[int]string{}
+				content := map[int]string{}
+				collect := func(index int, r string, c string) {
+					if r != "" {
+						reasoning[index] += r
+					}
+					if c != "" {
+						content[index] += c
+					}
+				}
+				indexes := map[int]bool{}
+				for _, delta := range test.deltas {
+					r, c := parser.Process(delta.index, delta.text)
+					collect(delta.index, r, c)
+					indexes[delta.index] = true
+				}
+				for index := range indexes {
+					r, c := parser.Flush(index)
+					collect(index, r, c)
+				}
+				So(reasoning, ShouldResemble, test.reasoning)
+				So(content, ShouldResemble, test.content)
+				So(parser.states, ShouldBeEmpty)
+			})
+		}
+	})
+}
+
+func TestNormalizeStreamReasoning(t *testing.T) {
+	Convey("TestNormalizeStreamReasoning", t, func() {
+		stop := "stop"
+		parser := newThinkTagParser()
+		first := &ChatCompletionsStreamResponse{Choices: []ChatCompletionsStreamResponseChoice{{Delta: model.Message{Content: "<think>plan</th"}}}}
+		So(normalizeStreamReasoning(parser, first), ShouldBeTrue)
+		So(first.Choices[0].Delta.ReasoningContent, ShouldEqual, "plan")
+		So(first.Choices[0].Delta.Content, ShouldEqual, "")
+
+		Convey("flushes the held back text on the finish reason", func() {
+			last := &ChatCompletionsStreamResponse{Choices: []ChatCompletionsStreamResponseChoice{{Delta: model.Message{Content: "ink"}, FinishReason: &stop}}}
+			So(normalizeStreamReasoning(parser, last), ShouldBeTrue)
+			So(last.Choices[0].Delta.ReasoningContent, ShouldEqual, "</think")
+			So(flushStreamReasoning(parser, last), ShouldBeNil)
+		})
+
+		Convey("flushes the held back text at the end of the stream", func() {
+			flushed := flushStreamReasoning(parser, first)
+			So(flushed, ShouldNotBeNil)
+			So(flushed.Choices[0].Delta.ReasoningContent, ShouldEqual, "</th")
+		})
+	})
+}
+
+func TestConvertReasoningRequest(t *testing.T) {
+	effort := func(e string) *string { return &e }
+	Convey("TestConvertReasoningRequest", t, func() {
+		Convey("keeps reasoning_effort for reasoning models", func() {
+			for _, modelName := range []string{"o1", "o3-mini", "o4-mini-2025-04-16", "gpt-5"} {
+				request := &model.GeneralOpenAIRequest{Model: modelName, ReasoningEffort: effort("high")}
+				convertReasoningRequest(channeltype.OpenAI, request)
+				So(request.ReasoningEffort, ShouldNotBeNil)
+				So(*request.ReasoningEffort, ShouldEqual, "high")
+			}
+		})
+
+		Convey("drops reasoning_effort for other models", func() {
+			for _, modelName := range []string{"gpt-4o", "gpt-5-chat-latest", "omni-moderation-latest"} {
+				request := &model.GeneralOpenAIRequest{Model: modelName, ReasoningEffort: effort("high")}
+				convertReasoningRequest(channeltype.Azure, request)
+				So(request.ReasoningEffort, ShouldBeNil)
+			}
+		})
+
+		Convey("maps a thinking budget to an effort", func() {
+			request := &model.GeneralOpenAIRequest{Model: "o3", Thinking: &model.Thinking{Type: "enabled", BudgetTokens: 2048}}
+			convertReasoningRequest(channeltype.OpenAI, request)
+			So(request.Thinking, ShouldBeNil)
+			So(*request.ReasoningEffort, ShouldEqual, model.ReasoningEffortLow)
+		})
+
+		Convey("maps the effort to the reasoning config of OpenRouter", func() {
+			request := &model.GeneralOpenAIRequest{Model: "deepseek/deepseek-r1", ReasoningEffort: effort("medium")}
+			convertReasoningRequest(channeltype.OpenRouter, request)
+			So(request.ReasoningEffort, ShouldBeNil)
+			So(request.Reasoning, ShouldResemble, &model.Reasoning{Effort: "medium"})
+		})
+	})
+}
@@ -43,6 +43,7 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.G
 		TopK:        claudeReq.TopK,
 		Stream:      claudeReq.Stream,
 		Tools:       claudeReq.Tools,
+		Thinking:    claudeReq.Thinking,
 	}
 
 	c.Set(ctxkey.RequestModel, request.Model)
@@ -1,13 +1,16 @@
 package vertexai
 
-import "github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+import (
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/model"
+)
 
 type Request struct {
 	// AnthropicVersion must be "vertex-2023-10-16"
 	AnthropicVersion string `json:"anthropic_version"`
 	// Model            string              `json:"model"`
 	Messages      []anthropic.Message `json:"messages"`
-	System        string              `json:"system,omitempty"`
+	System        any                 `json:"system,omitempty"`
 	MaxTokens     int                 `json:"max_tokens,omitempty"`
 	StopSequences []string            `json:"stop_sequences,omitempty"`
 	Stream        bool                `json:"stream,omitempty"`
@@ -16,4 +19,5 @@ type Request struct {
 	TopK          int                 `json:"top_k,omitempty"`
 	Tools         []anthropic.Tool    `json:"tools,omitempty"`
 	ToolChoice    any                 `json:"tool_choice,omitempty"`
+	Thinking      *model.Thinking     `json:"thinking,omitempty"`
 }
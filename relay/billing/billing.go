@@ -3,16 +3,17 @@ package billing
 import (
 	"context"
 	"fmt"
+	"math"
 
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 )
 
-func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId int) {
-	if preConsumedQuota != 0 {
+func ReturnPreConsumedQuota(ctx context.Context, reservation *model.QuotaReservation) {
+	if reservation.Quota != 0 {
 		go func(ctx context.Context) {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
+			err := model.ReleaseQuotaReservation(ctx, reservation)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
@@ -20,9 +21,21 @@ func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId
 	}
 }
 
-func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
-	// quotaDelta is remaining quota to be consumed
-	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
+// EstimateUpstreamCost is for the requests not priced by tokens, which are assumed to cost us
+// the catalog price with the channel discount.
+func EstimateUpstreamCost(quota int64, groupRatio float64, discount float64) int64 {
+	if groupRatio == 0 {
+		return 0
+	}
+	if discount <= 0 {
+		discount = 1
+	}
+	return int64(math.Ceil(float64(quota) / groupRatio * discount))
+}
+
+func PostConsumeQuota(ctx context.Context, reservation *model.QuotaReservation, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string, retryChain string, upstreamCost int64) {
+	tokenId := reservation.TokenId
+	err := model.SettleQuotaReservation(ctx, reservation, totalQuota)
 	if err != nil {
 		logger.SysError("error consuming token remain quota: " + err.Error())
 	}
@@ -40,8 +53,11 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 			CompletionTokens: 0,
 			ModelName:        modelName,
 			TokenName:        tokenName,
+			TokenId:          tokenId,
 			Quota:            int(totalQuota),
 			Content:          logContent,
+			RetryChain:       retryChain,
+			UpstreamCost:     int(upstreamCost),
 		})
 		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
 		model.UpdateChannelUsedQuota(channelId, totalQuota)
@@ -0,0 +1,178 @@
+package billing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+const (
+	CostItemTextInput   = "text_input"
+	CostItemCacheRead   = "cache_read"
+	CostItemCacheWrite  = "cache_write"
+	CostItemAudioInput  = "audio_input"
+	CostItemImageInput  = "image_input"
+	CostItemTextOutput  = "text_output"
+	CostItemAudioOutput = "audio_output"
+	CostItemImageOutput = "image_output"
+)
+
+// CostItem prices one dimension of the usage, Ratio is relative to the text input price of the model.
+type CostItem struct {
+	Name   string  `json:"name"`
+	Tokens int     `json:"tokens"`
+	Ratio  float64 `json:"ratio"`
+	Quota  float64 `json:"quota"`
+}
+
+// Cost is the itemized cost of a request, Quota = ceil(sum(tokens × ratio) × model ratio × group ratio × service tier ratio).
+type Cost struct {
+	ModelRatio       float64    `json:"model_ratio"`
+	GroupRatio       float64    `json:"group_ratio"`
+	CompletionRatio  float64    `json:"completion_ratio"`
+	Tier             int        `json:"tier,omitempty"` // min prompt tokens of the long context tier
+	ServiceTier      string     `json:"service_tier,omitempty"`
+	ServiceTierRatio float64    `json:"service_tier_ratio"`
+	PriceVersionId   int        `json:"price_version_id,omitempty"` // only when the catalog prices the model
+	Items            []CostItem `json:"items"`
+	Quota            int64      `json:"quota"`
+}
+
+// CalculateCost breaks the usage down by tier, modality and cache, the reasoning tokens are billed as text output.
+// The service tier is the one reported by the upstream in the usage, the prices are the ones in effect at the
+// timestamp, which is taken once per request so the pre-consumption and the billing agree.
+func CalculateCost(modelName string, channelType int, usage *relaymodel.Usage, groupRatio float64, timestamp int64) *Cost {
+	modelRatio := billingratio.GetModelRatioAt(modelName, channelType, timestamp)
+	completionRatio := billingratio.GetCompletionRatioAt(modelName, channelType, timestamp)
+	cost := calculateCost(modelName, channelType, usage, modelRatio, completionRatio, groupRatio)
+	cost.PriceVersionId = billingratio.GetPriceVersionIdAt(modelName, channelType, timestamp)
+	return cost
+}
+
+// CalculateUpstreamCost is what the channel charges us for the usage, priced by its upstream price of the model
+// or by the catalog without one, and discounted in place of the group ratio.
+func CalculateUpstreamCost(modelName string, channelType int, usage *relaymodel.Usage, upstreamPrices map[string]billingratio.ModelPrice, discount float64, timestamp int64) *Cost {
+	modelRatio := billingratio.GetModelRatioAt(modelName, channelType, timestamp)
+	completionRatio := billingratio.GetCompletionRatioAt(modelName, channelType, timestamp)
+	if price, ok := upstreamPrices[modelName]; ok {
+		modelRatio = price.ModelRatio()
+		completionRatio = price.CompletionRatio()
+	}
+	if discount <= 0 {
+		discount = 1
+	}
+	return calculateCost(modelName, channelType, usage, modelRatio, completionRatio, discount)
+}
+
+func calculateCost(modelName string, channelType int, usage *relaymodel.Usage, modelRatio float64, completionRatio float64, groupRatio float64) *Cost {
+	pricing := billingratio.GetModelPricing(modelName, channelType)
+	tier := pricing.GetTier(usage.PromptTokens)
+	cost := &Cost{
+		ModelRatio:       modelRatio,
+		GroupRatio:       groupRatio,
+		CompletionRatio:  completionRatio,
+		Tier:             tier.MinPromptTokens,
+		ServiceTier:      usage.ServiceTier,
+		ServiceTierRatio: pricing.GetServiceTierRatio(usage.ServiceTier),
+	}
+	cachedTokens := usage.GetCachedTokens()
+	cacheCreationTokens := usage.GetCacheCreationTokens()
+	// the cached tokens of a modality are billed as cache reads only
+	audioInputTokens := usage.GetPromptAudioTokens() - usage.GetCachedPromptAudioTokens()
+	if audioInputTokens < 0 {
+		audioInputTokens = 0
+	}
+	imageInputTokens := usage.GetPromptImageTokens() - usage.GetCachedPromptImageTokens()
+	if imageInputTokens < 0 {
+		imageInputTokens = 0
+	}
+	textInputTokens := usage.PromptTokens - cachedTokens - cacheCreationTokens - audioInputTokens - imageInputTokens
+	if textInputTokens < 0 {
+		textInputTokens = 0
+	}
+	audioOutputTokens := usage.GetCompletionAudioTokens()
+	imageOutputTokens := usage.GetCompletionImageTokens()
+	textOutputTokens := usage.CompletionTokens - audioOutputTokens - imageOutputTokens
+	if textOutputTokens < 0 {
+		textOutputTokens = 0
+	}
+	outputRatio := cost.CompletionRatio * tier.CompletionRatio
+	cost.add(CostItemTextInput, textInputTokens, tier.PromptRatio)
+	cost.add(CostItemCacheRead, cachedTokens, tier.PromptRatio*billingratio.GetCacheReadRatio(modelName, channelType))
+	cost.add(CostItemCacheWrite, cacheCreationTokens, tier.PromptRatio*billingratio.GetCacheWriteRatio(modelName, channelType))
+	cost.add(CostItemAudioInput, audioInputTokens, tier.PromptRatio*pricing.AudioInput())
+	cost.add(CostItemImageInput, imageInputTokens, tier.PromptRatio*pricing.ImageInput())
+	cost.add(CostItemTextOutput, textOutputTokens, outputRatio)
+	cost.add(CostItemAudioOutput, audioOutputTokens, outputRatio*pricing.AudioOutput())
+	cost.add(CostItemImageOutput, imageOutputTokens, outputRatio*pricing.ImageOutput())
+
+	var billedTokens float64
+	for _, item := range cost.Items {
+		billedTokens += item.Quota
+	}
+	ratio := cost.ModelRatio * cost.GroupRatio * cost.ServiceTierRatio
+	cost.Quota = int64(math.Ceil(billedTokens * ratio))
+	if ratio != 0 && cost.Quota <= 0 {
+		cost.Quota = 1
+	}
+	if usage.PromptTokens+usage.CompletionTokens == 0 {
+		// in this case, must be some error happened
+		cost.Quota = 0
+	}
+	for i := range cost.Items {
+		cost.Items[i].Quota *= ratio
+	}
+	return cost
+}
+
+func (c *Cost) add(name string, tokens int, ratio float64) {
+	if tokens == 0 {
+		return
+	}
+	c.Items = append(c.Items, CostItem{
+		Name:   name,
+		Tokens: tokens,
+		Ratio:  ratio,
+		Quota:  float64(tokens) * ratio,
+	})
+}
+
+// Describe is the human-readable summary written into the log content.
+func (c *Cost) Describe() string {
+	content := fmt.Sprintf("倍率：%.2f × %.2f × %.2f", c.ModelRatio, c.GroupRatio, c.CompletionRatio)
+	if c.Tier > 0 {
+		content += fmt.Sprintf("，长上下文（> %d）", c.Tier)
+	}
+	if c.ServiceTierRatio != 1 {
+		content += fmt.Sprintf("，服务等级 %s × %.2f", c.ServiceTier, c.ServiceTierRatio)
+	}
+	for _, item := range c.Items {
+		if item.Name == CostItemTextInput || item.Name == CostItemTextOutput {
+			continue
+		}
+		content += fmt.Sprintf("，%s %d × %.2f", costItemNames[item.Name], item.Tokens, item.Ratio)
+	}
+	return content
+}
+
+var costItemNames = map[string]string{
+	CostItemCacheRead:   "缓存读取",
+	CostItemCacheWrite:  "缓存写入",
+	CostItemAudioInput:  "音频输入",
+	CostItemImageInput:  "图像输入",
+	CostItemAudioOutput: "音频输出",
+	CostItemImageOutput: "图像输出",
+}
+
+func (c *Cost) JSONString() string {
+	jsonBytes, err := json.Marshal(c)
+	if err != nil {
+		logger.SysError("error marshalling cost: " + err.Error())
+		return ""
+	}
+	return string(jsonBytes)
+}
@@ -0,0 +1,160 @@
+package billing
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/helper"
+
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func costItem(cost *Cost, name string) *CostItem {
+	for i := range cost.Items {
+		if cost.Items[i].Name == name {
+			return &cost.Items[i]
+		}
+	}
+	return nil
+}
+
+func TestCalculateCostPromptCache(t *testing.T) {
+	Convey("TestCalculateCostPromptCache", t, func() {
+		Convey("bills the cache reads and writes of Anthropic apart from the text input", func() {
+			usage := &relaymodel.Usage{
+				PromptTokens:        1000,
+				CompletionTokens:    100,
+				PromptTokensDetails: &relaymodel.PromptTokensDetails{CachedTokens: 600, CacheCreationTokens: 200},
+			}
+			cost := calculateCost("claude-3-5-sonnet-20241022", 0, usage, 1, 5, 1)
+			So(costItem(cost, CostItemTextInput).Tokens, ShouldEqual, 200)
+			So(costItem(cost, CostItemCacheRead).Ratio, ShouldEqual, 0.1)
+			So(costItem(cost, CostItemCacheWrite).Ratio, ShouldEqual, 1.25)
+			// 200 + 600 × 0.1 + 200 × 1.25 + 100 × 5
+			So(cost.Quota, ShouldEqual, 1010)
+		})
+
+		Convey("reads the cache hits of DeepSeek", func() {
+			usage := &relaymodel.Usage{PromptTokens: 1000, CompletionTokens: 10, PromptCacheHitTokens: 800}
+			cost := calculateCost("deepseek-chat", 0, usage, 2, 2, 1)
+			So(costItem(cost, CostItemCacheRead).Tokens, ShouldEqual, 800)
+			// (200 + 800 × 0.1 + 10 × 2) × 2
+			So(cost.Quota, ShouldEqual, 600)
+		})
+
+		Convey("uses the configured ratios of the channel type first", func() {
+			So(billingratio.UpdateCacheReadRatioByJSONString(`{"gpt-4o": 0.5, "gpt-4o(3)": 0.25}`), ShouldBeNil)
+			defer func() { _ = billingratio.UpdateCacheReadRatioByJSONString(`{}`) }()
+			usage := &relaymodel.Usage{
+				PromptTokens:        1000,
+				PromptTokensDetails: &relaymodel.PromptTokensDetails{CachedTokens: 1000},
+			}
+			So(calculateCost("gpt-4o", 1, usage, 1, 4, 1).Quota, ShouldEqual, 500)
+			So(calculateCost("gpt-4o", 3, usage, 1, 4, 1).Quota, ShouldEqual, 250)
+		})
+	})
+}
+
+func TestCalculateCostModalities(t *testing.T) {
+	Convey("TestCalculateCostModalities", t, func() {
+		defaultPricing := billingratio.ModelsPricing2JSONString()
+		So(billingratio.UpdateModelsPricingByJSONString(`{
+			"audio-model": {"audio_input_ratio": 10, "audio_output_ratio": 4, "image_input_ratio": 2},
+			"long-model": {"tiers": [{"min_prompt_tokens": 1000, "prompt_ratio": 2, "completion_ratio": 3}]},
+			"flex-model": {"service_tier_ratios": {"flex": 0.5, "priority": 2}}
+		}`), ShouldBeNil)
+		defer func() { _ = billingratio.UpdateModelsPricingByJSONString(defaultPricing) }()
+
+		Convey("bills the cached audio and image tokens once as cache reads", func() {
+			usage := &relaymodel.Usage{
+				PromptTokens:     1000,
+				CompletionTokens: 100,
+				PromptTokensDetails: &relaymodel.PromptTokensDetails{
+					CachedTokens:        500,
+					AudioTokens:         300,
+					ImageTokens:         200,
+					CachedTokensDetails: &relaymodel.CachedTokensDetails{AudioTokens: 200, ImageTokens: 100},
+				},
+				CompletionTokensDetails: &relaymodel.CompletionTokensDetails{AudioTokens: 40},
+			}
+			cost := calculateCost("audio-model", 0, usage, 1, 2, 1)
+			So(costItem(cost, CostItemCacheRead).Tokens, ShouldEqual, 500)
+			So(costItem(cost, CostItemAudioInput).Tokens, ShouldEqual, 100)
+			So(costItem(cost, CostItemImageInput).Tokens, ShouldEqual, 100)
+			So(costItem(cost, CostItemTextInput).Tokens, ShouldEqual, 300)
+			So(costItem(cost, CostItemTextOutput).Tokens, ShouldEqual, 60)
+			So(costItem(cost, CostItemAudioOutput).Ratio, ShouldEqual, 8)
+			// 300 + 500 × 0.5 + 100 × 10 + 100 × 2 + 60 × 2 + 40 × 8
+			So(cost.Quota, ShouldEqual, 2190)
+		})
+
+		Convey("reprices the whole request in the long context tier", func() {
+			usage := &relaymodel.Usage{PromptTokens: 1001, CompletionTokens: 10}
+			cost := calculateCost("long-model", 0, usage, 1, 2, 1)
+			So(cost.Tier, ShouldEqual, 1000)
+			So(cost.Quota, ShouldEqual, 1001*2+10*2*3)
+			So(calculateCost("long-model", 0, &relaymodel.Usage{PromptTokens: 1000}, 1, 2, 1).Quota, ShouldEqual, 1000)
+		})
+
+		Convey("prices the service tier reported by the upstream for the models declaring it", func() {
+			usage := &relaymodel.Usage{PromptTokens: 1000, ServiceTier: "flex"}
+			So(calculateCost("flex-model", 0, usage, 1, 1, 1).Quota, ShouldEqual, 500)
+			usage.ServiceTier = "priority"
+			So(calculateCost("flex-model", 0, usage, 1, 1, 1).Quota, ShouldEqual, 2000)
+			usage.ServiceTier = "default"
+			So(calculateCost("flex-model", 0, usage, 1, 1, 1).Quota, ShouldEqual, 1000)
+			usage.ServiceTier = "flex"
+			So(calculateCost("long-model", 0, usage, 1, 1, 1).Quota, ShouldEqual, 1000)
+		})
+
+		Convey("applies the group ratio and charges at least 1", func() {
+			So(calculateCost("gpt-4o", 0, &relaymodel.Usage{PromptTokens: 100}, 1, 1, 3).Quota, ShouldEqual, 300)
+			So(calculateCost("gpt-4o", 0, &relaymodel.Usage{PromptTokens: 1}, 0.001, 1, 1).Quota, ShouldEqual, 1)
+			So(calculateCost("gpt-4o", 0, &relaymodel.Usage{}, 1, 1, 1).Quota, ShouldEqual, 0)
+		})
+	})
+}
+
+func TestCalculateUpstreamCost(t *testing.T) {
+	Convey("TestCalculateUpstreamCost", t, func() {
+		usage := &relaymodel.Usage{PromptTokens: 1000, CompletionTokens: 100}
+		prices := map[string]billingratio.ModelPrice{"gpt-4o": {Input: 1, Output: 4}}
+		cost := CalculateUpstreamCost("gpt-4o", 0, usage, prices, 0.8, helper.GetTimestamp())
+		So(cost.ModelRatio, ShouldEqual, prices["gpt-4o"].ModelRatio())
+		So(cost.GroupRatio, ShouldEqual, 0.8)
+		now := helper.GetTimestamp()
+		So(CalculateUpstreamCost("gpt-4o", 0, usage, nil, 0, now).Quota, ShouldEqual, CalculateCost("gpt-4o", 0, usage, 1, now).Quota)
+	})
+}
+
+func TestCalculateCostPriceVersion(t *testing.T) {
+	Convey("TestCalculateCostPriceVersion", t, func() {
+		now := helper.GetTimestamp()
+		billingratio.SetPriceVersions([]billingratio.PriceVersion{
+			{Id: 1, EffectiveAt: now - 100, Prices: map[string]billingratio.ModelPrice{"gpt-4o": {Input: 2, Output: 8}}},
+			{Id: 2, EffectiveAt: now + 100, Prices: map[string]billingratio.ModelPrice{"gpt-4o": {Input: 4, Output: 8}}},
+		})
+		defer billingratio.SetPriceVersions(nil)
+		usage := &relaymodel.Usage{PromptTokens: 1000}
+
+		Convey("prices a request by the version in effect at its timestamp", func() {
+			cost := CalculateCost("gpt-4o", 0, usage, 1, now)
+			So(cost.PriceVersionId, ShouldEqual, 1)
+			So(cost.ModelRatio, ShouldEqual, 1)
+			So(cost.CompletionRatio, ShouldEqual, 4)
+
+			// a request started before the next version is billed by the previous one after it takes effect
+			cost = CalculateCost("gpt-4o", 0, usage, 1, now+99)
+			So(cost.PriceVersionId, ShouldEqual, 1)
+			cost = CalculateCost("gpt-4o", 0, usage, 1, now+100)
+			So(cost.PriceVersionId, ShouldEqual, 2)
+			So(cost.ModelRatio, ShouldEqual, 2)
+		})
+
+		Convey("records no version for the models priced by ratios", func() {
+			So(CalculateCost("gpt-4o-mini", 0, usage, 1, now).PriceVersionId, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,96 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var cacheRatioLock sync.RWMutex
+
+// CacheReadRatio and CacheWriteRatio price the prompt tokens read from and written to the prompt cache,
+// relative to the input price of the model.
+// https://openai.com/api/pricing
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching#pricing
+// https://api-docs.deepseek.com/quick_start/pricing
+var CacheReadRatio = map[string]float64{}
+var CacheWriteRatio = map[string]float64{}
+
+func CacheReadRatio2JSONString() string {
+	cacheRatioLock.RLock()
+	defer cacheRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(CacheReadRatio)
+	if err != nil {
+		logger.SysError("error marshalling cache read ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheReadRatioByJSONString(jsonStr string) error {
+	newRatio := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &newRatio); err != nil {
+		return err
+	}
+	cacheRatioLock.Lock()
+	defer cacheRatioLock.Unlock()
+	CacheReadRatio = newRatio
+	return nil
+}
+
+func CacheWriteRatio2JSONString() string {
+	cacheRatioLock.RLock()
+	defer cacheRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(CacheWriteRatio)
+	if err != nil {
+		logger.SysError("error marshalling cache write ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheWriteRatioByJSONString(jsonStr string) error {
+	newRatio := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &newRatio); err != nil {
+		return err
+	}
+	cacheRatioLock.Lock()
+	defer cacheRatioLock.Unlock()
+	CacheWriteRatio = newRatio
+	return nil
+}
+
+func GetCacheReadRatio(name string, channelType int) float64 {
+	cacheRatioLock.RLock()
+	defer cacheRatioLock.RUnlock()
+	if ratio, ok := CacheReadRatio[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return ratio
+	}
+	if ratio, ok := CacheReadRatio[name]; ok {
+		return ratio
+	}
+	switch {
+	case strings.HasPrefix(name, "claude-"), strings.HasPrefix(name, "deepseek-"):
+		return 0.1
+	case strings.HasPrefix(name, "gemini-"):
+		return 0.25
+	}
+	return 0.5
+}
+
+func GetCacheWriteRatio(name string, channelType int) float64 {
+	cacheRatioLock.RLock()
+	defer cacheRatioLock.RUnlock()
+	if ratio, ok := CacheWriteRatio[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return ratio
+	}
+	if ratio, ok := CacheWriteRatio[name]; ok {
+		return ratio
+	}
+	if strings.HasPrefix(name, "claude-") {
+		// 5-minute cache writes
+		return 1.25
+	}
+	return 1
+}
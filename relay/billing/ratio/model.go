@@ -6,6 +6,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
@@ -683,15 +684,28 @@ func UpdateModelRatioByJSONString(jsonStr string) error {
 	return json.Unmarshal([]byte(jsonStr), &ModelRatio)
 }
 
-func GetModelRatio(name string, channelType int) float64 {
-	modelRatioLock.RLock()
-	defer modelRatioLock.RUnlock()
+func trimInternetSuffix(name string) string {
 	if strings.HasPrefix(name, "qwen-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
 	if strings.HasPrefix(name, "command-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
+	return name
+}
+
+func GetModelRatio(name string, channelType int) float64 {
+	return GetModelRatioAt(name, channelType, helper.GetTimestamp())
+}
+
+// GetModelRatioAt prices the model by the catalog in effect at the timestamp.
+func GetModelRatioAt(name string, channelType int, timestamp int64) float64 {
+	modelRatioLock.RLock()
+	defer modelRatioLock.RUnlock()
+	name = trimInternetSuffix(name)
+	if price, _, ok := getModelPrice(name, channelType, timestamp); ok {
+		return price.ModelRatio()
+	}
 	model := fmt.Sprintf("%s(%d)", name, channelType)
 	if ratio, ok := ModelRatio[model]; ok {
 		return ratio
@@ -723,9 +737,17 @@ func UpdateCompletionRatioByJSONString(jsonStr string) error {
 }
 
 func GetCompletionRatio(name string, channelType int) float64 {
+	return GetCompletionRatioAt(name, channelType, helper.GetTimestamp())
+}
+
+// GetCompletionRatioAt prices the model by the catalog in effect at the timestamp.
+func GetCompletionRatioAt(name string, channelType int, timestamp int64) float64 {
 	if strings.HasPrefix(name, "qwen-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
+	if price, _, ok := getModelPrice(name, channelType, timestamp); ok {
+		return price.CompletionRatio()
+	}
 	model := fmt.Sprintf("%s(%d)", name, channelType)
 	if ratio, ok := CompletionRatio[model]; ok {
 		return ratio
@@ -0,0 +1,289 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	CurrencyUSD = "USD"
+	CurrencyCNY = "CNY"
+)
+
+const (
+	PriceSourceCatalog = "catalog"
+	PriceSourceRatio   = "ratio"
+)
+
+var modelPriceLock sync.RWMutex
+
+// ModelPrice is the absolute price of a model per 1M tokens, the model and completion ratios are derived from it.
+type ModelPrice struct {
+	Input    float64 `json:"input"`
+	Output   float64 `json:"output"`
+	Currency string  `json:"currency,omitempty"` // USD by default
+}
+
+// ModelPrices is the price catalog keyed like ModelRatio, it takes precedence over ModelRatio and CompletionRatio.
+var ModelPrices = map[string]ModelPrice{}
+
+// ModelRatio converts the input price, 1 === $2 / 1M tokens.
+func (p ModelPrice) ModelRatio() float64 {
+	if p.Currency == CurrencyCNY {
+		return p.Input / 1000 * RMB
+	}
+	return p.Input * MILLI_USD
+}
+
+func (p ModelPrice) CompletionRatio() float64 {
+	if p.Input == 0 {
+		return 1
+	}
+	return p.Output / p.Input
+}
+
+func (p ModelPrice) validate() error {
+	if p.Currency != "" && p.Currency != CurrencyUSD && p.Currency != CurrencyCNY {
+		return fmt.Errorf("unsupported currency %s", p.Currency)
+	}
+	if p.Input < 0 || p.Output < 0 {
+		return fmt.Errorf("price must not be negative")
+	}
+	if p.Input == 0 && p.Output > 0 {
+		return fmt.Errorf("input price must be positive when output price is set")
+	}
+	return nil
+}
+
+func ModelPrices2JSONString() string {
+	modelPriceLock.RLock()
+	defer modelPriceLock.RUnlock()
+	jsonBytes, err := json.Marshal(ModelPrices)
+	if err != nil {
+		logger.SysError("error marshalling model prices: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ParseModelPrices parses and validates a price catalog in JSON.
+func ParseModelPrices(jsonStr string) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice)
+	if err := json.Unmarshal([]byte(jsonStr), &prices); err != nil {
+		return nil, err
+	}
+	for name, price := range prices {
+		if err := price.validate(); err != nil {
+			return nil, fmt.Errorf("invalid price of model %s: %s", name, err.Error())
+		}
+	}
+	return prices, nil
+}
+
+func UpdateModelPricesByJSONString(jsonStr string) error {
+	prices, err := ParseModelPrices(jsonStr)
+	if err != nil {
+		return err
+	}
+	modelPriceLock.Lock()
+	defer modelPriceLock.Unlock()
+	ModelPrices = prices
+	return nil
+}
+
+// PriceVersion is a snapshot of the price catalog which takes effect at EffectiveAt.
+type PriceVersion struct {
+	Id          int
+	EffectiveAt int64
+	Prices      map[string]ModelPrice
+}
+
+// priceVersions are sorted by EffectiveAt, the latest effective one replaces ModelPrices.
+var priceVersions []PriceVersion
+
+func SetPriceVersions(versions []PriceVersion) {
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].EffectiveAt == versions[j].EffectiveAt {
+			return versions[i].Id < versions[j].Id
+		}
+		return versions[i].EffectiveAt < versions[j].EffectiveAt
+	})
+	modelPriceLock.Lock()
+	defer modelPriceLock.Unlock()
+	priceVersions = versions
+}
+
+// activePrices must be called with modelPriceLock held, the version id is 0 when no version is effective.
+func activePrices(now int64) (map[string]ModelPrice, int) {
+	for i := len(priceVersions) - 1; i >= 0; i-- {
+		if priceVersions[i].EffectiveAt <= now {
+			return priceVersions[i].Prices, priceVersions[i].Id
+		}
+	}
+	return ModelPrices, 0
+}
+
+// GetActivePrices returns the catalog in effect at the given time and the id of its version.
+func GetActivePrices(now int64) (map[string]ModelPrice, int) {
+	modelPriceLock.RLock()
+	defer modelPriceLock.RUnlock()
+	prices, versionId := activePrices(now)
+	copied := make(map[string]ModelPrice, len(prices))
+	for name, price := range prices {
+		copied[name] = price
+	}
+	return copied, versionId
+}
+
+// GetActivePriceVersionId returns the id of the price version in effect, 0 for the unversioned catalog.
+func GetActivePriceVersionId() int {
+	modelPriceLock.RLock()
+	defer modelPriceLock.RUnlock()
+	_, versionId := activePrices(helper.GetTimestamp())
+	return versionId
+}
+
+// getModelPrice returns the price of the model in the catalog in effect at the timestamp and the id of its version.
+func getModelPrice(name string, channelType int, timestamp int64) (ModelPrice, int, bool) {
+	modelPriceLock.RLock()
+	defer modelPriceLock.RUnlock()
+	prices, versionId := activePrices(timestamp)
+	if price, ok := prices[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return price, versionId, true
+	}
+	price, ok := prices[name]
+	return price, versionId, ok
+}
+
+// GetPriceVersionIdAt returns the id of the price version pricing the model at the timestamp,
+// 0 for the unversioned catalog and for the models priced by ratios.
+func GetPriceVersionIdAt(name string, channelType int, timestamp int64) int {
+	_, versionId, ok := getModelPrice(trimInternetSuffix(name), channelType, timestamp)
+	if !ok {
+		return 0
+	}
+	return versionId
+}
+
+// PriceChange is a difference between two catalogs, a nil price means the model is added or removed.
+type PriceChange struct {
+	Model    string      `json:"model"`
+	OldPrice *ModelPrice `json:"old_price"`
+	NewPrice *ModelPrice `json:"new_price"`
+}
+
+func DiffModelPrices(oldPrices map[string]ModelPrice, newPrices map[string]ModelPrice) []PriceChange {
+	changes := make([]PriceChange, 0)
+	for name, oldPrice := range oldPrices {
+		oldPrice := oldPrice
+		newPrice, ok := newPrices[name]
+		if !ok {
+			changes = append(changes, PriceChange{Model: name, OldPrice: &oldPrice})
+			continue
+		}
+		if newPrice != oldPrice {
+			changes = append(changes, PriceChange{Model: name, OldPrice: &oldPrice, NewPrice: &newPrice})
+		}
+	}
+	for name, newPrice := range newPrices {
+		newPrice := newPrice
+		if _, ok := oldPrices[name]; !ok {
+			changes = append(changes, PriceChange{Model: name, NewPrice: &newPrice})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Model < changes[j].Model
+	})
+	return changes
+}
+
+// GetPriceSource tells whether a model is priced by the catalog, by a ratio or not at all.
+func GetPriceSource(name string) string {
+	if _, _, ok := getModelPrice(name, 0, helper.GetTimestamp()); ok {
+		return PriceSourceCatalog
+	}
+	modelRatioLock.RLock()
+	defer modelRatioLock.RUnlock()
+	if _, ok := ModelRatio[name]; ok {
+		return PriceSourceRatio
+	}
+	if _, ok := DefaultModelRatio[name]; ok {
+		return PriceSourceRatio
+	}
+	return ""
+}
+
+var channelTypeSuffix = regexp.MustCompile(`^(.+)\((\d+)\)$`)
+
+// splitChannelType splits a key like gpt-4o(3) into the model name and the channel type, 0 without one.
+func splitChannelType(key string) (string, int) {
+	if matches := channelTypeSuffix.FindStringSubmatch(key); matches != nil {
+		channelType, _ := strconv.Atoi(matches[2])
+		return matches[1], channelType
+	}
+	return key, 0
+}
+
+// GetCatalogPricedRatioChanges returns the keys whose ratio differs between two ModelRatio or CompletionRatio
+// options while the catalog prices the model, the ratios of those models are derived from the catalog and
+// such a change would take no effect.
+func GetCatalogPricedRatioChanges(oldJSON string, newJSON string) ([]string, error) {
+	newRatios := make(map[string]float64)
+	if err := json.Unmarshal([]byte(newJSON), &newRatios); err != nil {
+		return nil, err
+	}
+	oldRatios := make(map[string]float64)
+	// the saved option may be empty
+	_ = json.Unmarshal([]byte(oldJSON), &oldRatios)
+	changed := make(map[string]bool)
+	for key, ratio := range newRatios {
+		if oldRatio, ok := oldRatios[key]; !ok || oldRatio != ratio {
+			changed[key] = true
+		}
+	}
+	for key := range oldRatios {
+		if _, ok := newRatios[key]; !ok {
+			changed[key] = true
+		}
+	}
+	keys := make([]string, 0)
+	for key := range changed {
+		name, channelType := splitChannelType(key)
+		if _, _, ok := getModelPrice(name, channelType, helper.GetTimestamp()); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ConvertRatiosToPrices converts a ModelRatio option to a price catalog in USD,
+// the completion ratios come from CompletionRatio.
+func ConvertRatiosToPrices(modelRatioJSON string) (map[string]ModelPrice, error) {
+	modelRatios := make(map[string]float64)
+	if err := json.Unmarshal([]byte(modelRatioJSON), &modelRatios); err != nil {
+		return nil, err
+	}
+	prices := make(map[string]ModelPrice, len(modelRatios))
+	for key, modelRatio := range modelRatios {
+		name, channelType := splitChannelType(key)
+		input := roundPrice(modelRatio / MILLI_USD)
+		prices[key] = ModelPrice{
+			Input:    input,
+			Output:   roundPrice(input * GetCompletionRatio(name, channelType)),
+			Currency: CurrencyUSD,
+		}
+	}
+	return prices, nil
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*1e6) / 1e6
+}
@@ -0,0 +1,51 @@
+package ratio
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGetCatalogPricedRatioChanges(t *testing.T) {
+	Convey("TestGetCatalogPricedRatioChanges", t, func() {
+		So(UpdateModelPricesByJSONString(`{"gpt-4o": {"input": 2.5, "output": 10}, "claude-3-5-haiku(14)": {"input": 0.8, "output": 4}}`), ShouldBeNil)
+		defer func() { _ = UpdateModelPricesByJSONString(`{}`) }()
+		oldRatios := `{"gpt-4o": 1.25, "gpt-4o-mini": 0.075, "claude-3-5-haiku": 0.4}`
+
+		Convey("rejects the changes of the models priced by the catalog", func() {
+			keys, err := GetCatalogPricedRatioChanges(oldRatios, `{"gpt-4o": 1, "gpt-4o(3)": 1, "gpt-4o-mini": 0.075, "claude-3-5-haiku(14)": 0.5}`)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"claude-3-5-haiku(14)", "gpt-4o", "gpt-4o(3)"})
+
+			keys, _ = GetCatalogPricedRatioChanges(oldRatios, `{"gpt-4o-mini": 0.075, "claude-3-5-haiku": 0.4}`)
+			So(keys, ShouldResemble, []string{"gpt-4o"})
+		})
+
+		Convey("allows the changes of the other models", func() {
+			keys, err := GetCatalogPricedRatioChanges(oldRatios, `{"gpt-4o": 1.25, "gpt-4o-mini": 0.1, "claude-3-5-haiku": 0.5, "o3": 1}`)
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+
+		Convey("rejects invalid json", func() {
+			_, err := GetCatalogPricedRatioChanges(oldRatios, `{`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestModelPrice(t *testing.T) {
+	Convey("TestModelPrice", t, func() {
+		So(UpdateModelPricesByJSONString(`{"gpt-4o": {"input": 2.5, "output": 10}}`), ShouldBeNil)
+		defer func() { _ = UpdateModelPricesByJSONString(`{}`) }()
+		So(GetModelRatio("gpt-4o", 0), ShouldEqual, 1.25)
+		So(GetCompletionRatio("gpt-4o", 0), ShouldEqual, 4)
+		So(GetPriceSource("gpt-4o"), ShouldEqual, PriceSourceCatalog)
+		So(GetPriceSource("gpt-4o-mini"), ShouldEqual, PriceSourceRatio)
+
+		_, err := ParseModelPrices(`{"gpt-4o": {"input": 0, "output": 10}}`)
+		So(err, ShouldNotBeNil)
+		_, err = ParseModelPrices(`{"gpt-4o": {"input": 1, "currency": "EUR"}}`)
+		So(err, ShouldNotBeNil)
+	})
+}
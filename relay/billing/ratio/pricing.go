@@ -0,0 +1,141 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var modelPricingLock sync.RWMutex
+
+// PricingTier reprices the whole request once its prompt tokens exceed MinPromptTokens,
+// PromptRatio and CompletionRatio are relative to the base input and output prices of the model.
+type PricingTier struct {
+	MinPromptTokens int     `json:"min_prompt_tokens"`
+	PromptRatio     float64 `json:"prompt_ratio"`
+	CompletionRatio float64 `json:"completion_ratio"`
+}
+
+// ModelPricing holds the prices beyond ModelRatio and CompletionRatio,
+// the audio and image input ratios are relative to the text input price,
+// the audio and image output ratios to the text output price, zero means the same price as text.
+type ModelPricing struct {
+	Tiers             []PricingTier      `json:"tiers,omitempty"`
+	AudioInputRatio   float64            `json:"audio_input_ratio,omitempty"`
+	AudioOutputRatio  float64            `json:"audio_output_ratio,omitempty"`
+	ImageInputRatio   float64            `json:"image_input_ratio,omitempty"`
+	ImageOutputRatio  float64            `json:"image_output_ratio,omitempty"`
+	ServiceTierRatios map[string]float64 `json:"service_tier_ratios,omitempty"`
+}
+
+var longContext128K = []PricingTier{{MinPromptTokens: 128000, PromptRatio: 2, CompletionRatio: 2}}
+
+// ModelsPricing
+// https://ai.google.dev/pricing
+// https://platform.openai.com/docs/pricing
+var ModelsPricing = map[string]ModelPricing{
+	"gemini-1.5-pro":              {Tiers: longContext128K},
+	"gemini-1.5-pro-001":          {Tiers: longContext128K},
+	"gemini-1.5-pro-experimental": {Tiers: longContext128K},
+	"gemini-1.5-flash":            {Tiers: longContext128K},
+	"gemini-1.5-flash-001":        {Tiers: longContext128K},
+	"gemini-1.5-flash-8b":         {Tiers: longContext128K},
+	"gemini-2.0-flash":            {AudioInputRatio: 0.7 / 0.1},
+	"gemini-2.0-flash-001":        {AudioInputRatio: 0.7 / 0.1},
+	// text $2.5 / $10, audio $40 / $80 per 1M tokens
+	"gpt-4o-audio-preview": {AudioInputRatio: 40 / 2.5, AudioOutputRatio: 80.0 / 10},
+	// text $0.15 / $0.6, audio $10 / $20 per 1M tokens
+	"gpt-4o-mini-audio-preview": {AudioInputRatio: 10 / 0.15, AudioOutputRatio: 20 / 0.6},
+}
+
+func ModelsPricing2JSONString() string {
+	modelPricingLock.RLock()
+	defer modelPricingLock.RUnlock()
+	jsonBytes, err := json.Marshal(ModelsPricing)
+	if err != nil {
+		logger.SysError("error marshalling models pricing: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelsPricingByJSONString(jsonStr string) error {
+	newPricing := make(map[string]ModelPricing)
+	if err := json.Unmarshal([]byte(jsonStr), &newPricing); err != nil {
+		return err
+	}
+	for name, pricing := range newPricing {
+		for serviceTier, ratio := range pricing.ServiceTierRatios {
+			if ratio < 0 {
+				return fmt.Errorf("invalid ratio of service tier %s of model %s", serviceTier, name)
+			}
+		}
+		for i, tier := range pricing.Tiers {
+			if tier.MinPromptTokens <= 0 || tier.PromptRatio < 0 || tier.CompletionRatio < 0 {
+				return fmt.Errorf("invalid pricing tier %d of model %s", i, name)
+			}
+			if i > 0 && tier.MinPromptTokens <= pricing.Tiers[i-1].MinPromptTokens {
+				return fmt.Errorf("pricing tiers of model %s must be in ascending order", name)
+			}
+		}
+	}
+	modelPricingLock.Lock()
+	defer modelPricingLock.Unlock()
+	ModelsPricing = newPricing
+	return nil
+}
+
+func GetModelPricing(name string, channelType int) ModelPricing {
+	modelPricingLock.RLock()
+	defer modelPricingLock.RUnlock()
+	if pricing, ok := ModelsPricing[fmt.Sprintf("%s(%d)", name, channelType)]; ok {
+		return pricing
+	}
+	return ModelsPricing[name]
+}
+
+// GetTier returns the tier the prompt tokens fall into, the base tier prices everything at 1.
+func (p ModelPricing) GetTier(promptTokens int) PricingTier {
+	tier := PricingTier{PromptRatio: 1, CompletionRatio: 1}
+	for _, t := range p.Tiers {
+		if promptTokens > t.MinPromptTokens {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// GetServiceTierRatio returns 1 for the default tier and for the tiers the model doesn't price,
+// the tiers are priced per model since the providers don't offer them for every model.
+// https://platform.openai.com/docs/guides/flex-processing
+func (p ModelPricing) GetServiceTierRatio(serviceTier string) float64 {
+	if ratio, ok := p.ServiceTierRatios[serviceTier]; ok && serviceTier != "" {
+		return ratio
+	}
+	return 1
+}
+
+// AudioInput and the other modality getters fall back to the text price.
+func (p ModelPricing) AudioInput() float64 {
+	return ratioOrOne(p.AudioInputRatio)
+}
+
+func (p ModelPricing) AudioOutput() float64 {
+	return ratioOrOne(p.AudioOutputRatio)
+}
+
+func (p ModelPricing) ImageInput() float64 {
+	return ratioOrOne(p.ImageInputRatio)
+}
+
+func (p ModelPricing) ImageOutput() float64 {
+	return ratioOrOne(p.ImageOutputRatio)
+}
+
+func ratioOrOne(ratio float64) float64 {
+	if ratio == 0 {
+		return 1
+	}
+	return ratio
+}
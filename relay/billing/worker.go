@@ -0,0 +1,28 @@
+package billing
+
+import (
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+var postConsumeSemaphore = make(chan struct{}, config.PostConsumeConcurrency)
+var postConsumeWaitGroup sync.WaitGroup
+
+// Go runs f in a goroutine, it blocks while PostConsumeConcurrency goroutines are running.
+func Go(f func()) {
+	postConsumeSemaphore <- struct{}{}
+	postConsumeWaitGroup.Add(1)
+	go func() {
+		defer func() {
+			<-postConsumeSemaphore
+			postConsumeWaitGroup.Done()
+		}()
+		f()
+	}()
+}
+
+// Wait waits for the running goroutines started by Go.
+func Wait() {
+	postConsumeWaitGroup.Wait()
+}
@@ -0,0 +1,337 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+)
+
+const (
+	guardrailDataPrefix          = "data:"
+	guardrailDone                = "[DONE]"
+	finishReasonContentFilter    = "content_filter"
+	guardrailEventStreamMimeType = "text/event-stream"
+)
+
+// guardrailWriter applies the response rules at the handler boundary, so that they cover every adaptor:
+// all of them render OpenAI compatible chat or text completions. The events of a stream are rewritten
+// as they are written, a non-stream body is buffered and rewritten when the handler returns.
+type guardrailWriter struct {
+	gin.ResponseWriter
+	ctx            context.Context
+	guard          *guardrail.Guard
+	contentGuard   *guardrail.StreamGuard
+	reasoningGuard *guardrail.StreamGuard
+	isStream       bool
+	modeDetected   bool
+	buffer         bytes.Buffer   // the incomplete line of a stream, or the whole non-stream body
+	lastEvent      map[string]any // envelope of the text held back at the end of a stream
+	closed         bool           // the stream has been blocked or terminated
+}
+
+// newGuardrailWriter returns nil when the guard has no response rules.
+func newGuardrailWriter(c *gin.Context, guard *guardrail.Guard) *guardrailWriter {
+	contentGuard := guard.NewStream()
+	if contentGuard == nil {
+		return nil
+	}
+	return &guardrailWriter{
+		ResponseWriter: c.Writer,
+		ctx:            c.Request.Context(),
+		guard:          guard,
+		contentGuard:   contentGuard,
+		reasoningGuard: guard.NewStream(),
+	}
+}
+
+func (w *guardrailWriter) detectMode() {
+	if w.modeDetected {
+		return
+	}
+	w.modeDetected = true
+	w.isStream = strings.HasPrefix(w.Header().Get("Content-Type"), guardrailEventStreamMimeType)
+	// the length of the body changes with redaction
+	w.Header().Del("Content-Length")
+}
+
+func (w *guardrailWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *guardrailWriter) WriteHeaderNow() {
+	w.detectMode()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *guardrailWriter) Write(p []byte) (int, error) {
+	w.detectMode()
+	if w.closed {
+		return len(p), nil
+	}
+	w.buffer.Write(p)
+	if !w.isStream {
+		return len(p), nil
+	}
+	for {
+		line, err := w.buffer.ReadBytes('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			rest := append([]byte(nil), line...)
+			w.buffer.Reset()
+			w.buffer.Write(rest)
+			break
+		}
+		if err = w.writeLine(line); err != nil {
+			return len(p), err
+		}
+		if w.closed {
+			w.buffer.Reset()
+			break
+		}
+	}
+	return len(p), nil
+}
+
+func (w *guardrailWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// writeLine rewrites a complete line of a stream, the lines other than data events are passed through.
+func (w *guardrailWriter) writeLine(line []byte) error {
+	data := strings.TrimSpace(string(line))
+	if !strings.HasPrefix(data, guardrailDataPrefix) {
+		_, err := w.ResponseWriter.Write(line)
+		return err
+	}
+	data = strings.TrimSpace(strings.TrimPrefix(data, guardrailDataPrefix))
+	if data == guardrailDone {
+		// the text held back must be sent before the end of the stream
+		w.flushStream()
+		w.closed = true
+		_, err := w.ResponseWriter.Write([]byte(guardrailDataPrefix + " " + guardrailDone + "\n\n"))
+		return err
+	}
+	var event map[string]any
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		_, err = w.ResponseWriter.Write(line)
+		return err
+	}
+	choices, _ := event["choices"].([]any)
+	if len(choices) == 0 {
+		_, err := w.ResponseWriter.Write(line)
+		return err
+	}
+	w.lastEvent = event
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := choiceIndex(choice)
+		finished := choice["finish_reason"] != nil
+		if delta, ok := choice["delta"].(map[string]any); ok {
+			guardStreamField(delta, "content", w.contentGuard, index, finished)
+			guardStreamField(delta, "reasoning_content", w.reasoningGuard, index, finished)
+		} else {
+			guardStreamField(choice, "text", w.contentGuard, index, finished)
+		}
+	}
+	if blockedBy := w.blockedBy(); blockedBy != nil {
+		logger.Warnf(w.ctx, "stream blocked by guardrail rule: %s", blockedBy.Name)
+		w.writeBlockedEvent(event)
+		return nil
+	}
+	return w.writeEvent(event)
+}
+
+// guardStreamField replaces the delta of a field with the text which is safe to send,
+// the text held back is released when the choice is finished.
+func guardStreamField(object map[string]any, field string, streamGuard *guardrail.StreamGuard, index int, finished bool) {
+	text, ok := object[field].(string)
+	checked := ""
+	if ok {
+		checked = streamGuard.Process(index, text)
+	}
+	if finished {
+		checked += streamGuard.FlushChoice(index)
+	}
+	if ok || checked != "" {
+		object[field] = checked
+	}
+}
+
+func (w *guardrailWriter) blockedBy() *guardrail.Rule {
+	if w.contentGuard.BlockedBy != nil {
+		return w.contentGuard.BlockedBy
+	}
+	return w.reasoningGuard.BlockedBy
+}
+
+// writeBlockedEvent finishes every choice with the content_filter reason and terminates the stream.
+func (w *guardrailWriter) writeBlockedEvent(event map[string]any) {
+	choices, _ := event["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if _, ok := choice["delta"]; ok {
+			choice["delta"] = map[string]any{"content": ""}
+		} else {
+			choice["text"] = ""
+		}
+		choice["finish_reason"] = finishReasonContentFilter
+	}
+	delete(event, "usage")
+	if err := w.writeEvent(event); err != nil {
+		logger.SysError("error writing guarded event: " + err.Error())
+	}
+	if _, err := w.ResponseWriter.Write([]byte("\n" + guardrailDataPrefix + " " + guardrailDone + "\n\n")); err != nil {
+		logger.SysError("error writing guarded event: " + err.Error())
+	}
+	w.closed = true
+}
+
+func (w *guardrailWriter) writeEvent(event map[string]any) error {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = w.ResponseWriter.Write([]byte(guardrailDataPrefix + " " + string(jsonData) + "\n"))
+	return err
+}
+
+// flushStream sends the text held back for the choices without a finish reason.
+func (w *guardrailWriter) flushStream() {
+	if w.lastEvent == nil {
+		return
+	}
+	isChat := false
+	if choices, _ := w.lastEvent["choices"].([]any); len(choices) != 0 {
+		if choice, ok := choices[0].(map[string]any); ok {
+			_, isChat = choice["delta"]
+		}
+	}
+	reasoning := w.reasoningGuard.Flush()
+	for index, text := range w.contentGuard.Flush() {
+		choice := map[string]any{"index": index, "finish_reason": nil}
+		if isChat {
+			delta := map[string]any{"content": text}
+			if reasoningText, ok := reasoning[index]; ok {
+				delta["reasoning_content"] = reasoningText
+				delete(reasoning, index)
+			}
+			choice["delta"] = delta
+		} else {
+			choice["text"] = text
+		}
+		w.writeFlushedChoice(choice)
+	}
+	for index, text := range reasoning {
+		w.writeFlushedChoice(map[string]any{"index": index, "finish_reason": nil, "delta": map[string]any{"reasoning_content": text}})
+	}
+}
+
+func (w *guardrailWriter) writeFlushedChoice(choice map[string]any) {
+	event := make(map[string]any)
+	for _, key := range []string{"id", "object", "created", "model"} {
+		if value, ok := w.lastEvent[key]; ok {
+			event[key] = value
+		}
+	}
+	event["choices"] = []any{choice}
+	err := w.writeEvent(event)
+	if err == nil {
+		_, err = w.ResponseWriter.Write([]byte("\n"))
+	}
+	if err != nil {
+		logger.SysError("error writing guarded event: " + err.Error())
+	}
+}
+
+// finish writes what is left once the handler returns: the held back text of a stream
+// terminated without [DONE], or the guarded non-stream body.
+func (w *guardrailWriter) finish() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	if w.isStream {
+		if w.buffer.Len() != 0 {
+			_, _ = w.ResponseWriter.Write(w.buffer.Bytes())
+		}
+		w.flushStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	if !w.modeDetected {
+		return
+	}
+	body := w.buffer.Bytes()
+	if guardedBody, modified := w.guardBody(body); modified {
+		body = guardedBody
+	}
+	if _, err := w.ResponseWriter.Write(body); err != nil {
+		logger.SysError("error writing guarded response: " + err.Error())
+	}
+}
+
+// guardBody applies the response rules to the choices of a chat or text completion,
+// the original body is returned when nothing is changed.
+func (w *guardrailWriter) guardBody(body []byte) ([]byte, bool) {
+	var response map[string]any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body, false
+	}
+	choices, _ := response["choices"].([]any)
+	modified := false
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		target, fields := choice, []string{"text"}
+		if message, ok := choice["message"].(map[string]any); ok {
+			target, fields = message, []string{"content", "reasoning_content"}
+		}
+		for _, field := range fields {
+			text, ok := target[field].(string)
+			if !ok {
+				continue
+			}
+			checked, blockedBy := w.guard.CheckResponse(text)
+			if blockedBy != nil {
+				logger.Warnf(w.ctx, "response blocked by guardrail rule: %s", blockedBy.Name)
+				target[fields[0]] = ""
+				delete(target, "reasoning_content")
+				choice["finish_reason"] = finishReasonContentFilter
+				modified = true
+				break
+			}
+			if checked != text {
+				target[field] = checked
+				modified = true
+			}
+		}
+	}
+	if !modified {
+		return body, false
+	}
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		return body, false
+	}
+	return jsonData, true
+}
+
+func choiceIndex(choice map[string]any) int {
+	index, _ := choice["index"].(float64)
+	return int(index)
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+)
+
+func newGuardedContext(t *testing.T) (*gin.Context, *httptest.ResponseRecorder, *guardrailWriter) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	writer := newGuardrailWriter(c, guardrail.New("default", 1))
+	if writer == nil {
+		t.Fatal("expected response rules")
+	}
+	c.Writer = writer
+	return c, recorder, writer
+}
+
+// streamEvents returns the data of the events written to the client
+func streamEvents(body string) []string {
+	var events []string
+	for _, event := range strings.Split(body, "\n\n") {
+		event = strings.TrimSpace(event)
+		if event != "" {
+			events = append(events, strings.TrimPrefix(event, "data: "))
+		}
+	}
+	return events
+}
+
+// streamText joins the content and the reasoning of the chunks of a stream
+func streamText(events []string) (content string, reasoning string, finishReason string) {
+	for _, event := range events {
+		if event == "[DONE]" {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Text  string `json:"text"`
+				Delta struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		So(json.Unmarshal([]byte(event), &chunk), ShouldBeNil)
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content + choice.Text
+			reasoning += choice.Delta.ReasoningContent
+			if choice.FinishReason != nil {
+				finishReason = *choice.FinishReason
+			}
+		}
+	}
+	return
+}
+
+func chatChunk(content string, reasoning string, finishReason string) map[string]any {
+	delta := map[string]any{}
+	if content != "" {
+		delta["content"] = content
+	}
+	if reasoning != "" {
+		delta["reasoning_content"] = reasoning
+	}
+	choice := map[string]any{"index": 0, "delta": delta, "finish_reason": nil}
+	if finishReason != "" {
+		choice["finish_reason"] = finishReason
+	}
+	return map[string]any{"id": "chatcmpl-1", "object": "chat.completion.chunk", "created": 1, "model": "gpt-4o", "choices": []any{choice}}
+}
+
+func TestGuardrailWriter(t *testing.T) {
+	err := guardrail.UpdateRulesByJSONString(`[
+		{"name": "email", "type": "pii", "pii": "email", "action": "redact", "scope": "response"},
+		{"name": "secret", "type": "keyword", "keywords": ["Project X"], "action": "block", "scope": "response"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = guardrail.UpdateRulesByJSONString("[]") }()
+
+	Convey("redacts matches split across the chunks of a chat stream", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		common.SetEventStreamHeaders(c)
+		So(render.ObjectData(c, chatChunk("", "let me think about foo@exa", "")), ShouldBeNil)
+		So(render.ObjectData(c, chatChunk("mail foo@exa", "mple.com first", "")), ShouldBeNil)
+		So(render.ObjectData(c, chatChunk("mple.com please", "", "")), ShouldBeNil)
+		render.Done(c)
+		writer.finish()
+
+		events := streamEvents(recorder.Body.String())
+		So(events[len(events)-1], ShouldEqual, "[DONE]")
+		content, reasoning, _ := streamText(events)
+		So(content, ShouldEqual, "mail [REDACTED] please")
+		So(reasoning, ShouldEqual, "let me think about [REDACTED] first")
+		So(recorder.Body.String(), ShouldNotContainSubstring, "foo@")
+	})
+
+	Convey("releases the held back text of a finished choice", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		common.SetEventStreamHeaders(c)
+		So(render.ObjectData(c, chatChunk("hello", "", "")), ShouldBeNil)
+		So(render.ObjectData(c, chatChunk(" world", "", "stop")), ShouldBeNil)
+		So(render.ObjectData(c, map[string]any{"id": "chatcmpl-1", "choices": []any{}, "usage": map[string]any{"total_tokens": 3}}), ShouldBeNil)
+		render.Done(c)
+		writer.finish()
+
+		events := streamEvents(recorder.Body.String())
+		So(len(events), ShouldEqual, 4)
+		content, _, finishReason := streamText(events[:2])
+		So(content, ShouldEqual, "hello world")
+		So(finishReason, ShouldEqual, "stop")
+		So(events[2], ShouldContainSubstring, "total_tokens")
+	})
+
+	Convey("flushes a stream terminated without [DONE]", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		common.SetEventStreamHeaders(c)
+		So(render.ObjectData(c, chatChunk("reach me at foo@example.com", "", "")), ShouldBeNil)
+		writer.finish()
+		content, _, _ := streamText(streamEvents(recorder.Body.String()))
+		So(content, ShouldEqual, "reach me at [REDACTED]")
+	})
+
+	Convey("guards the text of a completions stream", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		common.SetEventStreamHeaders(c)
+		for _, text := range []string{"write to ", "foo@example", ".com"} {
+			So(render.ObjectData(c, map[string]any{"object": "text_completion", "choices": []any{map[string]any{"index": 0, "text": text}}}), ShouldBeNil)
+		}
+		render.Done(c)
+		writer.finish()
+		events := streamEvents(recorder.Body.String())
+		content, _, _ := streamText(events)
+		So(content, ShouldEqual, "write to [REDACTED]")
+		So(events[len(events)-1], ShouldEqual, "[DONE]")
+	})
+
+	Convey("blocks a stream and drops what follows", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		common.SetEventStreamHeaders(c)
+		So(render.ObjectData(c, chatChunk("the plan of Proj", "", "")), ShouldBeNil)
+		So(render.ObjectData(c, chatChunk("ect X is", "", "")), ShouldBeNil)
+		So(render.ObjectData(c, chatChunk(" secret", "", "")), ShouldBeNil)
+		render.Done(c)
+		writer.finish()
+
+		events := streamEvents(recorder.Body.String())
+		So(len(events), ShouldEqual, 3)
+		So(events[2], ShouldEqual, "[DONE]")
+		content, _, finishReason := streamText(events)
+		So(content, ShouldNotContainSubstring, "Project X")
+		So(finishReason, ShouldEqual, finishReasonContentFilter)
+	})
+
+	Convey("guards a non-stream chat completion", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		body := `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"mail foo@example.com","reasoning_content":"bar@example.com"},"finish_reason":"stop"}]}`
+		c.Writer.Header().Set("Content-Type", "application/json")
+		c.Writer.Header().Set("Content-Length", "123")
+		c.Writer.WriteHeader(http.StatusOK)
+		_, err := c.Writer.Write([]byte(body))
+		So(err, ShouldBeNil)
+		So(recorder.Body.Len(), ShouldEqual, 0)
+		writer.finish()
+
+		So(recorder.Header().Get("Content-Length"), ShouldBeEmpty)
+		var response struct {
+			Choices []struct {
+				Message struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		So(json.Unmarshal(recorder.Body.Bytes(), &response), ShouldBeNil)
+		So(response.Choices[0].Message.Content, ShouldEqual, "mail [REDACTED]")
+		So(response.Choices[0].Message.ReasoningContent, ShouldEqual, "[REDACTED]")
+	})
+
+	Convey("blocks a non-stream text completion", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		c.JSON(http.StatusOK, map[string]any{"object": "text_completion", "choices": []any{map[string]any{"index": 0, "text": "about Project X", "finish_reason": "stop"}}})
+		writer.finish()
+		So(recorder.Body.String(), ShouldNotContainSubstring, "Project X")
+		So(recorder.Body.String(), ShouldContainSubstring, finishReasonContentFilter)
+	})
+
+	Convey("passes unchanged bodies through", t, func() {
+		c, recorder, writer := newGuardedContext(t)
+		body := `{"error":{"message":"foo@example.com is not allowed"}}`
+		c.Writer.Header().Set("Content-Type", "application/json")
+		c.Writer.WriteHeader(http.StatusBadRequest)
+		_, _ = c.Writer.Write([]byte(body))
+		writer.finish()
+		So(recorder.Code, ShouldEqual, http.StatusBadRequest)
+		So(recorder.Body.String(), ShouldEqual, body)
+	})
+}
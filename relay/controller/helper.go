@@ -1,12 +1,16 @@
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/relay/constant/role"
@@ -15,12 +19,16 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
-	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/billing"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller/validator"
+	"github.com/songquanpeng/one-api/relay/guardrail"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
@@ -65,56 +73,79 @@ func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTok
 	return int64(float64(preConsumedTokens) * ratio)
 }
 
-func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
-	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+// preConsumeQuota reserves the estimated quota, which is settled by postConsumeQuota or returned on failure
+func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (*model.QuotaReservation, *relaymodel.ErrorWithStatusCode) {
+	ctx, span := tracing.Start(ctx, "preConsumeQuota")
+	defer span.End()
+	return reserveQuota(ctx, meta, getPreConsumedQuota(textRequest, promptTokens, ratio))
+}
 
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
-	if err != nil {
-		return preConsumedQuota, openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
-	}
-	if userQuota-preConsumedQuota < 0 {
-		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
-	}
-	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
-	if err != nil {
-		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
-	}
-	if userQuota > 100*preConsumedQuota {
-		// in this case, we do not pre-consume quota
-		// because the user has enough quota
-		preConsumedQuota = 0
-		logger.Info(ctx, fmt.Sprintf("user %d has enough quota %d, trusted and no need to pre-consume", meta.UserId, userQuota))
-	}
-	if preConsumedQuota > 0 {
-		err := model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
-		if err != nil {
-			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+func reserveQuota(ctx context.Context, meta *meta.Meta, quota int64) (*model.QuotaReservation, *relaymodel.ErrorWithStatusCode) {
+	var ephemeral *model.EphemeralTokenLimit
+	if meta.EphemeralTokenId != "" {
+		ephemeral = &model.EphemeralTokenLimit{
+			Id:        meta.EphemeralTokenId,
+			ExpiresAt: meta.EphemeralTokenExpiresAt,
+			Quota:     meta.EphemeralTokenQuota,
 		}
 	}
-	return preConsumedQuota, nil
+	reservation, err := model.ReserveQuota(ctx, meta.UserId, meta.TokenId, meta.TokenUnlimitedQuota, ephemeral, quota)
+	switch {
+	case err == nil:
+		return reservation, nil
+	case errors.Is(err, model.ErrInsufficientUserQuota):
+		return nil, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	case errors.Is(err, model.ErrInsufficientTokenQuota), errors.Is(err, model.ErrInsufficientEphemeralTokenQuota):
+		return nil, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+	default:
+		return nil, openai.ErrorWrapper(err, "reserve_quota_failed", http.StatusInternalServerError)
+	}
 }
 
-func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest, ratio float64, preConsumedQuota int64, modelRatio float64, groupRatio float64, systemPromptReset bool) {
-	if usage == nil {
-		logger.Error(ctx, "usage is nil, which is unexpected")
-		return
+func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest, reservation *model.QuotaReservation, groupRatio float64, systemPromptReset bool) {
+	ctx, span := tracing.Start(ctx, "postConsumeQuota")
+	defer span.End()
+	log := &model.Log{
+		UserId:            meta.UserId,
+		ChannelId:         meta.ChannelId,
+		ModelName:         textRequest.Model,
+		TokenName:         meta.TokenName,
+		TokenId:           meta.TokenId,
+		IsStream:          meta.IsStream,
+		ElapsedTime:       helper.CalcElapsedTime(meta.StartTime),
+		SystemPromptReset: systemPromptReset,
+		RetryChain:        meta.RetryChain,
+		FirstTokenTime:    meta.FirstTokenTime,
+		UpstreamTime:      meta.UpstreamTime,
 	}
 	var quota int64
-	completionRatio := billingratio.GetCompletionRatio(textRequest.Model, meta.ChannelType)
-	promptTokens := usage.PromptTokens
-	completionTokens := usage.CompletionTokens
-	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
-	if ratio != 0 && quota <= 0 {
-		quota = 1
-	}
-	totalTokens := promptTokens + completionTokens
-	if totalTokens == 0 {
-		// in this case, must be some error happened
-		// we cannot just return, because we may have to return the pre-consumed quota
-		quota = 0
-	}
-	quotaDelta := quota - preConsumedQuota
-	err := model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
+	if usage == nil {
+		// the response was delivered, so the estimate is charged and accounted like any other request instead of
+		// leaving the quota reserved until it expires
+		logger.Error(ctx, "usage is nil, which is unexpected, charging the pre-consumed quota")
+		quota = reservation.Quota
+		log.Content = "上游未返回用量，按预扣额度计费"
+		log.UpstreamCost = int(billing.EstimateUpstreamCost(quota, groupRatio, meta.UpstreamDiscount))
+	} else {
+		cost := billing.CalculateCost(textRequest.Model, meta.ChannelType, usage, groupRatio, meta.StartTime.Unix())
+		quota = cost.Quota
+		upstreamCost := billing.CalculateUpstreamCost(textRequest.Model, meta.ChannelType, usage, meta.UpstreamPrices, meta.UpstreamDiscount, meta.StartTime.Unix())
+		log.PromptTokens = usage.PromptTokens
+		// the completion tokens include the reasoning ones, which are billed as text output
+		log.CompletionTokens = usage.CompletionTokens
+		log.CachedTokens = usage.GetCachedTokens()
+		log.CacheCreationTokens = usage.GetCacheCreationTokens()
+		log.ReasoningTokens = usage.GetReasoningTokens()
+		log.Content = cost.Describe()
+		if log.ReasoningTokens > 0 {
+			log.Content += fmt.Sprintf("，其中推理 %d", log.ReasoningTokens)
+		}
+		log.TokensPerSecond = getTokensPerSecond(log.CompletionTokens, meta)
+		log.Cost = cost.JSONString()
+		log.PriceVersionId = cost.PriceVersionId
+		log.UpstreamCost = int(upstreamCost.Quota)
+	}
+	err := model.SettleQuotaReservation(ctx, reservation, quota)
 	if err != nil {
 		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
 	}
@@ -122,24 +153,52 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 	if err != nil {
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
-	logContent := fmt.Sprintf("倍率：%.2f × %.2f × %.2f", modelRatio, groupRatio, completionRatio)
-	model.RecordConsumeLog(ctx, &model.Log{
-		UserId:            meta.UserId,
-		ChannelId:         meta.ChannelId,
-		PromptTokens:      promptTokens,
-		CompletionTokens:  completionTokens,
-		ModelName:         textRequest.Model,
-		TokenName:         meta.TokenName,
-		Quota:             int(quota),
-		Content:           logContent,
-		IsStream:          meta.IsStream,
-		ElapsedTime:       helper.CalcElapsedTime(meta.StartTime),
-		SystemPromptReset: systemPromptReset,
-	})
+	metrics.ObserveConsumption(textRequest.Model, meta.Group, log.PromptTokens, log.CompletionTokens, quota)
+	log.Quota = int(quota)
+	model.RecordConsumeLog(ctx, log)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
 }
 
+// getServiceTier returns the requested tier, which is only an estimate since the upstream may process the request
+// in another tier.
+func getServiceTier(textRequest *relaymodel.GeneralOpenAIRequest) string {
+	if textRequest.ServiceTier == nil {
+		return ""
+	}
+	return *textRequest.ServiceTier
+}
+
+func applyRequestGuardrail(c *gin.Context, guard *guardrail.Guard, textRequest *relaymodel.GeneralOpenAIRequest) *relaymodel.ErrorWithStatusCode {
+	modified, blockedBy := guard.CheckRequest(textRequest)
+	if blockedBy != nil {
+		return openai.ErrorWrapper(fmt.Errorf("request is blocked by guardrail rule: %s", blockedBy.Name), "guardrail_blocked", http.StatusBadRequest)
+	}
+	if !modified {
+		return nil
+	}
+	// replace the reusable body, so that pass-through requests and retries only see the redacted content
+	jsonData, err := json.Marshal(textRequest)
+	if err != nil {
+		return openai.ErrorWrapper(err, "marshal_redacted_request_failed", http.StatusInternalServerError)
+	}
+	c.Set(ctxkey.KeyRequestBody, jsonData)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	return nil
+}
+
+func recordGuardrailViolations(ctx context.Context, meta *meta.Meta, guard *guardrail.Guard) {
+	for _, violation := range guard.Violations() {
+		model.RecordGuardrailLog(ctx, &model.Log{
+			UserId:    meta.UserId,
+			ChannelId: meta.ChannelId,
+			ModelName: meta.OriginModelName,
+			TokenName: meta.TokenName,
+			Content:   violation,
+		})
+	}
+}
+
 func getMappedModelName(modelName string, mapping map[string]string) (string, bool) {
 	if mapping == nil {
 		return modelName, false
@@ -196,3 +255,27 @@ func setSystemPrompt(ctx context.Context, request *relaymodel.GeneralOpenAIReque
 	logger.Infof(ctx, "add system prompt")
 	return true
 }
+
+// setResponseTiming measures the upstream time and the time to first token of a stream from the upstream call,
+// so that both share the same time base
+func setResponseTiming(c *gin.Context, meta *meta.Meta, upstreamStartTime time.Time) {
+	meta.UpstreamTime = time.Since(upstreamStartTime).Milliseconds()
+	if !meta.IsStream {
+		return
+	}
+	if firstTokenAt, ok := c.Get(ctxkey.FirstTokenAt); ok && firstTokenAt.(time.Time).After(upstreamStartTime) {
+		meta.FirstTokenTime = firstTokenAt.(time.Time).Sub(upstreamStartTime).Milliseconds()
+	}
+}
+
+// getTokensPerSecond excludes the time to first token of streams, which is mostly prompt processing
+func getTokensPerSecond(completionTokens int, meta *meta.Meta) float64 {
+	generationTime := meta.UpstreamTime
+	if meta.IsStream && meta.FirstTokenTime > 0 {
+		generationTime = meta.UpstreamTime - meta.FirstTokenTime
+	}
+	if completionTokens <= 0 || generationTime <= 0 {
+		return 0
+	}
+	return math.Round(float64(completionTokens)/(float64(generationTime)/1000)*100) / 100
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestResponseTiming(t *testing.T) {
+	Convey("TestResponseTiming", t, func() {
+		gin.SetMode(gin.TestMode)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		upstreamStartTime := time.Now().Add(-2 * time.Second)
+		// the request was converted for a while before the upstream call
+		m := &meta.Meta{IsStream: true, StartTime: upstreamStartTime.Add(-3 * time.Second)}
+		c.Set(ctxkey.FirstTokenAt, upstreamStartTime.Add(time.Second))
+
+		setResponseTiming(c, m, upstreamStartTime)
+		So(m.UpstreamTime, ShouldBeBetweenOrEqual, 2000, 2100)
+		So(m.FirstTokenTime, ShouldEqual, 1000)
+		// 100 tokens generated in the second after the first token
+		So(getTokensPerSecond(100, m), ShouldBeBetweenOrEqual, 90, 100)
+
+		Convey("uses the upstream time of non-stream requests", func() {
+			m := &meta.Meta{UpstreamTime: 2000}
+			So(getTokensPerSecond(100, m), ShouldEqual, 50)
+			So(getTokensPerSecond(0, m), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestPostConsumeQuotaWithoutUsage(t *testing.T) {
+	Convey("TestPostConsumeQuotaWithoutUsage", t, func() {
+		model.UseTestDB(t, &model.User{}, &model.Token{}, &model.QuotaReservation{}, &model.Subscription{}, &model.LedgerEntry{}, &model.Log{}, &model.Channel{})
+		db := model.DB
+
+		ctx := context.Background()
+		user := &model.User{Username: "alice", Password: "password", Quota: 1000, AffCode: "a1", AccessToken: "t1"}
+		So(db.Create(user).Error, ShouldBeNil)
+		channel := &model.Channel{Name: "openai"}
+		So(db.Create(channel).Error, ShouldBeNil)
+		reservation, err := model.ReserveQuota(ctx, user.Id, 1, true, nil, 300)
+		So(err, ShouldBeNil)
+
+		// the estimate is charged and accounted like any other request, the reservation is not left to expire
+		postConsumeQuota(ctx, nil, &meta.Meta{UserId: user.Id, ChannelId: channel.Id}, &relaymodel.GeneralOpenAIRequest{Model: "gpt-4o"}, reservation, 1, false)
+		var settled model.QuotaReservation
+		So(db.First(&settled, "id = ?", reservation.Id).Error, ShouldBeNil)
+		So(settled.Status, ShouldEqual, model.QuotaReservationStatusSettled)
+		So(settled.SettledQuota, ShouldEqual, 300)
+		quota, _ := model.GetUserQuota(user.Id)
+		So(quota, ShouldEqual, 700)
+
+		So(db.First(user, user.Id).Error, ShouldBeNil)
+		So(user.UsedQuota, ShouldEqual, 300)
+		So(user.RequestCount, ShouldEqual, 1)
+		So(db.First(channel, channel.Id).Error, ShouldBeNil)
+		So(channel.UsedQuota, ShouldEqual, 300)
+		var logs []*model.Log
+		So(db.Where("type = ?", model.LogTypeConsume).Find(&logs).Error, ShouldBeNil)
+		So(len(logs), ShouldEqual, 1)
+		So(logs[0].Quota, ShouldEqual, 300)
+		So(logs[0].ModelName, ShouldEqual, "gpt-4o")
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
@@ -168,11 +169,11 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		requestBody = bytes.NewBuffer(jsonStr)
 	}
 
-	modelRatio := billingratio.GetModelRatio(imageModel, meta.ChannelType)
+	// priced by the catalog in effect when the request started, like the text requests
+	modelRatio := billingratio.GetModelRatioAt(imageModel, meta.ChannelType, meta.StartTime.Unix())
+	priceVersionId := billingratio.GetPriceVersionIdAt(imageModel, meta.ChannelType, meta.StartTime.Unix())
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	ratio := modelRatio * groupRatio
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
-
 	var quota int64
 	switch meta.ChannelType {
 	case channeltype.Replicate:
@@ -182,14 +183,16 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		quota = int64(ratio*imageCostRatio*1000) * int64(imageRequest.N)
 	}
 
-	if userQuota-quota < 0 {
-		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	reservation, bizErr := reserveQuota(ctx, meta, quota)
+	if bizErr != nil {
+		return bizErr
 	}
 
 	// do request
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, reservation)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
@@ -197,10 +200,11 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if resp != nil &&
 			resp.StatusCode != http.StatusCreated && // replicate returns 201
 			resp.StatusCode != http.StatusOK {
+			billing.ReturnPreConsumedQuota(ctx, reservation)
 			return
 		}
 
-		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
+		err := model.SettleQuotaReservation(ctx, reservation, quota)
 		if err != nil {
 			logger.SysError("error consuming token remain quota: " + err.Error())
 		}
@@ -218,8 +222,12 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				CompletionTokens: 0,
 				ModelName:        imageRequest.Model,
 				TokenName:        tokenName,
+				TokenId:          meta.TokenId,
 				Quota:            int(quota),
 				Content:          logContent,
+				RetryChain:       meta.RetryChain,
+				UpstreamCost:     int(billing.EstimateUpstreamCost(quota, groupRatio, meta.UpstreamDiscount)),
+				PriceVersionId:   priceVersionId,
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 			channelId := c.GetInt(ctxkey.ChannelId)
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tracing"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
@@ -18,6 +20,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/guardrail"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
 )
@@ -32,6 +35,21 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
 	}
 	meta.IsStream = textRequest.Stream
+	// apply guardrails before anything is sent upstream
+	if guard := guardrail.New(meta.Group, meta.TokenId); guard != nil {
+		defer recordGuardrailViolations(ctx, meta, guard)
+		if bizErr := applyRequestGuardrail(c, guard, textRequest); bizErr != nil {
+			return bizErr
+		}
+		// the response rules are applied to whatever the adaptor renders
+		if writer := newGuardrailWriter(c, guard); writer != nil {
+			c.Writer = writer
+			defer func() {
+				writer.finish()
+				c.Writer = writer.ResponseWriter
+			}()
+		}
+	}
 
 	// map model name
 	meta.OriginModelName = textRequest.Model
@@ -39,18 +57,26 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	meta.ActualModelName = textRequest.Model
 	// set system prompt if not empty
 	systemPromptReset := setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
-	// get model ratio & group ratio
-	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType)
-	groupRatio := billingratio.GetGroupRatio(meta.Group)
-	ratio := modelRatio * groupRatio
 	// pre-consume quota
 	promptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
-	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
+	// get model ratio & group ratio, priced by the long context and service tiers
+	// priced at the start of the request, like the billing after the response
+	modelRatio := billingratio.GetModelRatioAt(textRequest.Model, meta.ChannelType, meta.StartTime.Unix())
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	pricing := billingratio.GetModelPricing(textRequest.Model, meta.ChannelType)
+	ratio := modelRatio * groupRatio * pricing.GetTier(promptTokens).PromptRatio * pricing.GetServiceTierRatio(getServiceTier(textRequest))
+	reservation, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
 	if bizErr != nil {
 		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
 		return bizErr
 	}
+	settled := false
+	defer func() {
+		if !settled {
+			billing.ReturnPreConsumedQuota(ctx, reservation)
+		}
+	}()
 
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
@@ -59,31 +85,43 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	adaptor.Init(meta)
 
 	// get request body
+	_, span := tracing.Start(ctx, "ConvertRequest")
 	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
+	tracing.End(span, err)
 	if err != nil {
 		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 
 	// do request
+	upstreamStartTime := time.Now()
+	_, span = tracing.Start(ctx, "DoRequest", tracing.AttrChannelId.Int(meta.ChannelId), tracing.AttrModel.String(meta.ActualModelName))
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	tracing.End(span, err)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 	if isErrorHappened(meta, resp) {
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return RelayErrorHandler(resp)
 	}
 
 	// do response
+	_, span = tracing.Start(ctx, "DoResponse")
 	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	if respErr != nil {
+		err = fmt.Errorf("%s", respErr.Message)
+	}
+	tracing.End(span, err)
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return respErr
 	}
+	setResponseTiming(c, meta, upstreamStartTime)
 	// post-consume quota
-	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+	settled = true
+	billing.Go(func() {
+		postConsumeQuota(ctx, usage, meta, textRequest, reservation, groupRatio, systemPromptReset)
+	})
 	return nil
 }
 
@@ -0,0 +1,140 @@
+package guardrail
+
+import (
+	"fmt"
+
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// Guard applies the rules of one request, the violations are recorded once the request is done.
+type Guard struct {
+	group      string
+	tokenId    int
+	rules      []*Rule
+	violations []violation
+}
+
+type violation struct {
+	rule  *Rule
+	scope string
+}
+
+// New returns nil when no rule applies to the group and token.
+func New(group string, tokenId int) *Guard {
+	rules := getRules(group, tokenId)
+	if len(rules) == 0 {
+		return nil
+	}
+	return &Guard{
+		group:   group,
+		tokenId: tokenId,
+		rules:   rules,
+	}
+}
+
+func (g *Guard) hasScope(scope string) bool {
+	if g == nil {
+		return false
+	}
+	for _, rule := range g.rules {
+		if rule.appliesTo(g.group, g.tokenId, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Guard) addViolation(rule *Rule, scope string) {
+	for _, v := range g.violations {
+		if v.rule == rule && v.scope == scope {
+			return
+		}
+	}
+	g.violations = append(g.violations, violation{rule: rule, scope: scope})
+}
+
+// Check applies the rules of scope to text, it returns the redacted text and the rule blocking it if any.
+func (g *Guard) Check(scope string, text string) (string, *Rule) {
+	if g == nil || text == "" {
+		return text, nil
+	}
+	for _, rule := range g.rules {
+		if !rule.appliesTo(g.group, g.tokenId, scope) {
+			continue
+		}
+		matches := rule.find(text)
+		if len(matches) == 0 {
+			continue
+		}
+		g.addViolation(rule, scope)
+		switch rule.Action {
+		case ActionBlock:
+			return text, rule
+		case ActionRedact:
+			text = rule.redact(text, matches)
+		}
+	}
+	return text, nil
+}
+
+// CheckRequest applies the request rules to the messages, prompt and input of request in place.
+func (g *Guard) CheckRequest(request *relaymodel.GeneralOpenAIRequest) (modified bool, blockedBy *Rule) {
+	if !g.hasScope(ScopeRequest) {
+		return false, nil
+	}
+	check := func(text string) string {
+		if blockedBy != nil {
+			return text
+		}
+		var checked string
+		checked, blockedBy = g.Check(ScopeRequest, text)
+		if checked != text {
+			modified = true
+		}
+		return checked
+	}
+	for i := range request.Messages {
+		request.Messages[i].Content = checkContent(request.Messages[i].Content, check)
+	}
+	request.Prompt = checkContent(request.Prompt, check)
+	request.Input = checkContent(request.Input, check)
+	return modified, blockedBy
+}
+
+// checkContent handles plain strings, string lists and lists of content parts
+func checkContent(content any, check func(string) string) any {
+	switch v := content.(type) {
+	case string:
+		return check(v)
+	case []any:
+		for i, item := range v {
+			switch part := item.(type) {
+			case string:
+				v[i] = check(part)
+			case map[string]any:
+				if text, ok := part["text"].(string); ok && part["type"] == relaymodel.ContentTypeText {
+					part["text"] = check(text)
+				}
+			}
+		}
+		return v
+	}
+	return content
+}
+
+// Violations describes every rule matched during the request, without the matched content.
+func (g *Guard) Violations() []string {
+	if g == nil {
+		return nil
+	}
+	var descriptions []string
+	for _, v := range g.violations {
+		descriptions = append(descriptions, fmt.Sprintf("规则 %s（%s）命中%s内容，处理方式：%s", v.rule.Name, v.rule.Type, scopeNames[v.scope], v.rule.Action))
+	}
+	return descriptions
+}
+
+var scopeNames = map[string]string{
+	ScopeRequest:  "请求",
+	ScopeResponse: "响应",
+}
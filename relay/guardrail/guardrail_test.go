@@ -0,0 +1,132 @@
+package guardrail
+
+import (
+	"regexp/syntax"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestGuardrail(t *testing.T) {
+	err := UpdateRulesByJSONString(`[
+		{"name": "secret", "type": "keyword", "keywords": ["Project X"], "action": "block", "scope": "request"},
+		{"name": "email", "type": "pii", "pii": "email", "action": "redact"},
+		{"name": "card", "type": "pii", "pii": "credit_card", "action": "redact", "replacement": "[CARD]"},
+		{"name": "id", "type": "pii", "pii": "cn_id", "action": "log", "groups": ["vip"]}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = UpdateRulesByJSONString("[]") }()
+
+	Convey("invalid rules are rejected", t, func() {
+		So(UpdateRulesByJSONString(`[{"name": "bad", "type": "regex", "pattern": "(", "action": "block"}]`), ShouldNotBeNil)
+		So(UpdateRulesByJSONString(`[{"name": "bad", "type": "pii", "pii": "ssn", "action": "block"}]`), ShouldNotBeNil)
+	})
+
+	Convey("request rules", t, func() {
+		guard := New("default", 1)
+		request := &relaymodel.GeneralOpenAIRequest{
+			Messages: []relaymodel.Message{
+				{Role: "user", Content: "mail me at foo@example.com, card 4111 1111 1111 1111 or 4111 1111 1111 1112"},
+			},
+		}
+		modified, blockedBy := guard.CheckRequest(request)
+		So(modified, ShouldBeTrue)
+		So(blockedBy, ShouldBeNil)
+		So(request.Messages[0].Content, ShouldEqual, "mail me at [REDACTED], card [CARD] or 4111 1111 1111 1112")
+
+		request.Messages = append(request.Messages, relaymodel.Message{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "about project x"},
+		}})
+		_, blockedBy = guard.CheckRequest(request)
+		So(blockedBy, ShouldNotBeNil)
+		So(blockedBy.Name, ShouldEqual, "secret")
+		So(len(guard.Violations()), ShouldEqual, 3)
+	})
+
+	Convey("group filter", t, func() {
+		So(New("default", 1).hasRule("id"), ShouldBeFalse)
+		So(New("vip", 1).hasRule("id"), ShouldBeTrue)
+		_, blockedBy := New("vip", 1).Check(ScopeRequest, "11010519491231002X")
+		So(blockedBy, ShouldBeNil)
+	})
+
+	Convey("stream holds back matches spanning chunks", t, func() {
+		stream := New("default", 1).NewStream()
+		var output strings.Builder
+		for _, delta := range []string{"contact: foo", "@exam", "ple.com ", strings.Repeat("a", 100)} {
+			output.WriteString(stream.Process(0, delta))
+		}
+		output.WriteString(stream.FlushChoice(0))
+		So(output.String(), ShouldEqual, "contact: [REDACTED] "+strings.Repeat("a", 100))
+	})
+
+	Convey("redact pii", t, func() {
+		So(RedactPII("mail foo@example.com, id 11010519491231002X, order 12345"), ShouldEqual, "mail [REDACTED], id [REDACTED], order 12345")
+	})
+}
+
+func TestStreamHoldback(t *testing.T) {
+	secret := strings.Repeat("classified ", 10)
+	err := UpdateRulesByJSONString(`[
+		{"name": "secret", "type": "keyword", "keywords": ["` + secret + `"], "action": "block", "scope": "response"},
+		{"name": "order", "type": "regex", "pattern": "ORDER-[0-9]{100,150}", "action": "redact", "scope": "response"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = UpdateRulesByJSONString("[]") }()
+	process := func(stream *StreamGuard, text string, chunkSize int) string {
+		var output strings.Builder
+		runes := []rune(text)
+		for i := 0; i < len(runes); i += chunkSize {
+			end := i + chunkSize
+			if end > len(runes) {
+				end = len(runes)
+			}
+			output.WriteString(stream.Process(0, string(runes[i:end])))
+		}
+		output.WriteString(stream.FlushChoice(0))
+		return output.String()
+	}
+
+	Convey("blocks a keyword longer than the chunks before any of it is sent", t, func() {
+		stream := New("default", 1).NewStream()
+		output := process(stream, "the plan is "+secret+"and more", 7)
+		So(stream.BlockedBy, ShouldNotBeNil)
+		So(output, ShouldNotContainSubstring, "classified")
+	})
+
+	Convey("redacts a long bounded regex match spanning chunks", t, func() {
+		stream := New("default", 1).NewStream()
+		output := process(stream, "your order ORDER-"+strings.Repeat("7", 120)+" is shipped", 9)
+		So(output, ShouldEqual, "your order [REDACTED] is shipped")
+	})
+
+	Convey("rejects response rules whose matches are unbounded or too long", t, func() {
+		So(UpdateRulesByJSONString(`[{"name": "bad", "type": "regex", "pattern": "secret.*", "action": "block"}]`), ShouldNotBeNil)
+		So(UpdateRulesByJSONString(`[{"name": "bad", "type": "regex", "pattern": "a{2000}", "action": "block", "scope": "response"}]`), ShouldNotBeNil)
+		So(UpdateRulesByJSONString(`[{"name": "ok", "type": "regex", "pattern": "secret.*", "action": "block", "scope": "request"}]`), ShouldBeNil)
+	})
+
+	Convey("the longest pii matches are known", t, func() {
+		for _, piiType := range []string{"phone", "credit_card", "cn_id"} {
+			parsed, err := syntax.Parse(piiDetectors[piiType].regexp.String(), syntax.Perl)
+			So(err, ShouldBeNil)
+			So(piiDetectors[piiType].maxLength, ShouldEqual, maxMatchLength(parsed))
+		}
+	})
+}
+
+func (g *Guard) hasRule(name string) bool {
+	for _, rule := range g.rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
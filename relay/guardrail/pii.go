@@ -0,0 +1,81 @@
+package guardrail
+
+import (
+	"regexp"
+	"strings"
+)
+
+type piiDetector struct {
+	regexp    *regexp.Regexp
+	validator func(string) bool
+	maxLength int // the most characters a match can have
+}
+
+var piiDetectors = map[string]piiDetector{
+	"email": {
+		// addresses are at most 254 characters, longer matches are only redacted outside of streams
+		regexp:    regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		maxLength: 254,
+	},
+	"phone": {
+		// mainland China mobile numbers and international numbers with a country code
+		regexp:    regexp.MustCompile(`(?:\+?86[\s\-]?)?\b1[3-9]\d{9}\b|\+\d{1,3}[\s\-]?\(?\d{1,4}\)?[\s\-]?\d{3,4}[\s\-]?\d{3,4}\b`),
+		maxLength: 21,
+	},
+	"credit_card": {
+		regexp:    regexp.MustCompile(`\b\d(?:[\s\-]?\d){12,18}\b`),
+		validator: isValidCardNumber,
+		maxLength: 37,
+	},
+	"cn_id": {
+		regexp:    regexp.MustCompile(`\b\d{17}[\dXx]\b`),
+		validator: isValidChineseId,
+		maxLength: 18,
+	},
+}
+
+// isValidCardNumber checks the Luhn checksum
+func isValidCardNumber(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+var chineseIdWeights = []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+const chineseIdCheckCodes = "10X98765432"
+
+// isValidChineseId checks the GB 11643 check digit of an 18 digit resident id number
+func isValidChineseId(s string) bool {
+	sum := 0
+	for i, weight := range chineseIdWeights {
+		sum += int(s[i]-'0') * weight
+	}
+	return chineseIdCheckCodes[sum%11] == strings.ToUpper(s[17:])[0]
+}
+
+var piiTypes = []string{"email", "phone", "credit_card", "cn_id"}
+
+// RedactPII replaces every detected pii in text with the default replacement.
+func RedactPII(text string) string {
+	for _, piiType := range piiTypes {
+		detector := piiDetectors[piiType]
+		rule := &Rule{regexp: detector.regexp, validator: detector.validator, Replacement: defaultReplacement}
+		if matches := rule.find(text); len(matches) != 0 {
+			text = rule.redact(text, matches)
+		}
+	}
+	return text
+}
@@ -0,0 +1,238 @@
+package guardrail
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	TypeKeyword = "keyword"
+	TypeRegex   = "regex"
+	TypePII     = "pii"
+)
+
+const (
+	ActionBlock  = "block"
+	ActionRedact = "redact"
+	ActionLog    = "log"
+)
+
+const (
+	ScopeRequest  = "request"
+	ScopeResponse = "response"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+// Rule is a single guardrail rule, rules without groups and token ids apply to every request.
+type Rule struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	PII         string   `json:"pii,omitempty"` // email, phone, credit_card or cn_id
+	Action      string   `json:"action"`
+	Scope       string   `json:"scope,omitempty"` // request, response or empty for both
+	Replacement string   `json:"replacement,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	TokenIds    []int    `json:"token_ids,omitempty"`
+
+	regexp    *regexp.Regexp
+	validator func(string) bool
+	// maxLength is the most characters a match can have, -1 when unbounded
+	maxLength int
+}
+
+// maxStreamHoldback caps the characters held back from stream clients, the response rules must not match more
+const maxStreamHoldback = 1024
+
+func (r *Rule) compile() error {
+	switch r.Type {
+	case TypeKeyword:
+		var quoted []string
+		for _, keyword := range r.Keywords {
+			if keyword != "" {
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+				if length := len([]rune(keyword)); length > r.maxLength {
+					r.maxLength = length
+				}
+			}
+		}
+		if len(quoted) == 0 {
+			return fmt.Errorf("rule %s: keywords are empty", r.Name)
+		}
+		r.regexp = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+	case TypeRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		parsed, err := syntax.Parse(r.Pattern, syntax.Perl)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", r.Name, err)
+		}
+		r.regexp = re
+		r.maxLength = maxMatchLength(parsed)
+	case TypePII:
+		detector, ok := piiDetectors[r.PII]
+		if !ok {
+			return fmt.Errorf("rule %s: unknown pii type %s", r.Name, r.PII)
+		}
+		r.regexp = detector.regexp
+		r.validator = detector.validator
+		r.maxLength = detector.maxLength
+	default:
+		return fmt.Errorf("rule %s: unknown type %s", r.Name, r.Type)
+	}
+	switch r.Action {
+	case ActionBlock, ActionRedact, ActionLog:
+	default:
+		return fmt.Errorf("rule %s: unknown action %s", r.Name, r.Action)
+	}
+	switch r.Scope {
+	case "", ScopeRequest, ScopeResponse:
+	default:
+		return fmt.Errorf("rule %s: unknown scope %s", r.Name, r.Scope)
+	}
+	// streams are checked with a window of the longest match, so a longer match would reach the client
+	if r.Scope != ScopeRequest && (r.maxLength < 0 || r.maxLength > maxStreamHoldback) {
+		return fmt.Errorf("rule %s: response rules must not match more than %d characters, use bounded repetitions like {1,100}", r.Name, maxStreamHoldback)
+	}
+	if r.Replacement == "" {
+		r.Replacement = defaultReplacement
+	}
+	return nil
+}
+
+// maxMatchLength returns the most characters re can match, or -1 when unbounded
+func maxMatchLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune)
+	case syntax.OpCharClass, syntax.OpAnyCharNotNL, syntax.OpAnyChar:
+		return 1
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxMatchLength(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return -1
+	case syntax.OpRepeat:
+		length := maxMatchLength(re.Sub[0])
+		if re.Max < 0 || length < 0 {
+			return -1
+		}
+		return re.Max * length
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			length := maxMatchLength(sub)
+			if length < 0 {
+				return -1
+			}
+			total += length
+		}
+		return total
+	case syntax.OpAlternate:
+		longest := 0
+		for _, sub := range re.Sub {
+			length := maxMatchLength(sub)
+			if length < 0 {
+				return -1
+			}
+			if length > longest {
+				longest = length
+			}
+		}
+		return longest
+	}
+	// empty matches and assertions
+	return 0
+}
+
+func (r *Rule) appliesTo(group string, tokenId int, scope string) bool {
+	if r.Scope != "" && r.Scope != scope {
+		return false
+	}
+	if len(r.Groups) != 0 && !slices.Contains(r.Groups, group) {
+		return false
+	}
+	if len(r.TokenIds) != 0 && !slices.Contains(r.TokenIds, tokenId) {
+		return false
+	}
+	return true
+}
+
+// find returns the index pairs of all matches in text
+func (r *Rule) find(text string) [][]int {
+	matches := r.regexp.FindAllStringIndex(text, -1)
+	if r.validator == nil {
+		return matches
+	}
+	var validMatches [][]int
+	for _, match := range matches {
+		if r.validator(text[match[0]:match[1]]) {
+			validMatches = append(validMatches, match)
+		}
+	}
+	return validMatches
+}
+
+func (r *Rule) redact(text string, matches [][]int) string {
+	var builder strings.Builder
+	last := 0
+	for _, match := range matches {
+		builder.WriteString(text[last:match[0]])
+		builder.WriteString(r.Replacement)
+		last = match[1]
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+var rulesLock sync.RWMutex
+var rules = make([]*Rule, 0)
+
+func Rules2JSONString() string {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	jsonBytes, err := json.Marshal(rules)
+	if err != nil {
+		logger.SysError("error marshalling guardrail rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRulesByJSONString(jsonStr string) error {
+	newRules := make([]*Rule, 0)
+	err := json.Unmarshal([]byte(jsonStr), &newRules)
+	if err != nil {
+		return err
+	}
+	for _, rule := range newRules {
+		if err = rule.compile(); err != nil {
+			return err
+		}
+	}
+	rulesLock.Lock()
+	defer rulesLock.Unlock()
+	rules = newRules
+	return nil
+}
+
+func getRules(group string, tokenId int) []*Rule {
+	rulesLock.RLock()
+	defer rulesLock.RUnlock()
+	var matchedRules []*Rule
+	for _, rule := range rules {
+		if rule.appliesTo(group, tokenId, ScopeRequest) || rule.appliesTo(group, tokenId, ScopeResponse) {
+			matchedRules = append(matchedRules, rule)
+		}
+	}
+	return matchedRules
+}
@@ -0,0 +1,103 @@
+package guardrail
+
+// StreamGuard applies the response rules incrementally to the choices of a stream.
+type StreamGuard struct {
+	guard *Guard
+	// pending is kept unredacted, so that a match growing with the next chunks is redacted as a whole
+	pending map[int]string
+	// holdback is the number of trailing characters held back from the client, the longest match of the
+	// response rules, so that matches spanning several chunks can still be redacted or blocked.
+	holdback  int
+	BlockedBy *Rule
+}
+
+// NewStream returns nil when there are no response rules.
+func (g *Guard) NewStream() *StreamGuard {
+	if !g.hasScope(ScopeResponse) {
+		return nil
+	}
+	holdback := 0
+	for _, rule := range g.rules {
+		if rule.appliesTo(g.group, g.tokenId, ScopeResponse) && rule.maxLength > holdback {
+			holdback = rule.maxLength
+		}
+	}
+	return &StreamGuard{
+		guard:    g,
+		pending:  make(map[int]string),
+		holdback: holdback,
+	}
+}
+
+// Process takes the delta of a choice and returns the text which is safe to send.
+func (s *StreamGuard) Process(index int, delta string) string {
+	if s.BlockedBy != nil {
+		return ""
+	}
+	text := s.pending[index] + delta
+	if _, blockedBy := s.guard.Check(ScopeResponse, text); blockedBy != nil {
+		s.BlockedBy = blockedBy
+		s.pending = make(map[int]string)
+		return ""
+	}
+	cut := s.cut(text)
+	s.pending[index] = text[cut:]
+	return s.redact(text[:cut])
+}
+
+// cut returns the end of the text which can be sent, the last holdback characters are held back.
+// A match crossing the end can't grow any more since it's at most holdback long, so it's sent as a whole.
+func (s *StreamGuard) cut(text string) int {
+	runes := []rune(text)
+	if len(runes) <= s.holdback {
+		return 0
+	}
+	cut := len(string(runes[:len(runes)-s.holdback]))
+	for moved := true; moved; {
+		moved = false
+		for _, rule := range s.guard.rules {
+			if !rule.appliesTo(s.guard.group, s.guard.tokenId, ScopeResponse) {
+				continue
+			}
+			for _, match := range rule.find(text) {
+				if match[0] < cut && match[1] > cut {
+					cut = match[1]
+					moved = true
+				}
+			}
+		}
+	}
+	return cut
+}
+
+func (s *StreamGuard) redact(text string) string {
+	redacted, _ := s.guard.Check(ScopeResponse, text)
+	return redacted
+}
+
+// FlushChoice returns the held back text of a finished choice.
+func (s *StreamGuard) FlushChoice(index int) string {
+	text := s.pending[index]
+	delete(s.pending, index)
+	return s.redact(text)
+}
+
+// Flush returns the held back text of every choice at the end of the stream.
+func (s *StreamGuard) Flush() map[int]string {
+	flushed := make(map[int]string)
+	for index, text := range s.pending {
+		if text != "" {
+			flushed[index] = s.redact(text)
+		}
+	}
+	s.pending = make(map[int]string)
+	return flushed
+}
+
+// CheckResponse applies the response rules to a complete response text.
+func (g *Guard) CheckResponse(text string) (string, *Rule) {
+	if !g.hasScope(ScopeResponse) {
+		return text, nil
+	}
+	return g.Check(ScopeResponse, text)
+}
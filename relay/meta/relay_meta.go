@@ -8,19 +8,22 @@ import (
 
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 type Meta struct {
-	Mode         int
-	ChannelType  int
-	ChannelId    int
-	TokenId      int
-	TokenName    string
-	UserId       int
-	Group        string
-	ModelMapping map[string]string
+	Mode        int
+	ChannelType int
+	ChannelId   int
+	TokenId     int
+	TokenName   string
+	// TokenUnlimitedQuota is loaded with the token by the auth middleware
+	TokenUnlimitedQuota bool
+	UserId              int
+	Group               string
+	ModelMapping        map[string]string
 	// BaseURL is the proxy url set in the channel config
 	BaseURL  string
 	APIKey   string
@@ -35,29 +38,50 @@ type Meta struct {
 	PromptTokens       int // only for DoResponse
 	ForcedSystemPrompt string
 	StartTime          time.Time
+	// EphemeralTokenId is set when the request is authorized by an ephemeral token of TokenId
+	EphemeralTokenId        string
+	EphemeralTokenExpiresAt int64
+	EphemeralTokenQuota     int64
+	// RetryChain is the comma separated ids of the channels tried so far, ending with ChannelId
+	RetryChain string
+	// FirstTokenTime and UpstreamTime are measured once the response is handled, unit is ms
+	FirstTokenTime int64
+	UpstreamTime   int64
+	// UpstreamPrices and UpstreamDiscount are what the channel charges us, used to calculate the margin
+	UpstreamPrices   map[string]billingratio.ModelPrice
+	UpstreamDiscount float64
 }
 
 func GetByContext(c *gin.Context) *Meta {
 	meta := Meta{
-		Mode:               relaymode.GetByPath(c.Request.URL.Path),
-		ChannelType:        c.GetInt(ctxkey.Channel),
-		ChannelId:          c.GetInt(ctxkey.ChannelId),
-		TokenId:            c.GetInt(ctxkey.TokenId),
-		TokenName:          c.GetString(ctxkey.TokenName),
-		UserId:             c.GetInt(ctxkey.Id),
-		Group:              c.GetString(ctxkey.Group),
-		ModelMapping:       c.GetStringMapString(ctxkey.ModelMapping),
-		OriginModelName:    c.GetString(ctxkey.RequestModel),
-		BaseURL:            c.GetString(ctxkey.BaseURL),
-		APIKey:             strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
-		RequestURLPath:     c.Request.URL.String(),
-		ForcedSystemPrompt: c.GetString(ctxkey.SystemPrompt),
-		StartTime:          time.Now(),
+		Mode:                    relaymode.GetByPath(c.Request.URL.Path),
+		ChannelType:             c.GetInt(ctxkey.Channel),
+		ChannelId:               c.GetInt(ctxkey.ChannelId),
+		TokenId:                 c.GetInt(ctxkey.TokenId),
+		TokenName:               c.GetString(ctxkey.TokenName),
+		TokenUnlimitedQuota:     c.GetBool(ctxkey.TokenUnlimited),
+		UserId:                  c.GetInt(ctxkey.Id),
+		Group:                   c.GetString(ctxkey.Group),
+		ModelMapping:            c.GetStringMapString(ctxkey.ModelMapping),
+		OriginModelName:         c.GetString(ctxkey.RequestModel),
+		BaseURL:                 c.GetString(ctxkey.BaseURL),
+		APIKey:                  strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
+		RequestURLPath:          c.Request.URL.String(),
+		ForcedSystemPrompt:      c.GetString(ctxkey.SystemPrompt),
+		StartTime:               time.Now(),
+		EphemeralTokenId:        c.GetString(ctxkey.EphemeralTokenId),
+		EphemeralTokenExpiresAt: c.GetInt64(ctxkey.EphemeralTokenExpiresAt),
+		EphemeralTokenQuota:     c.GetInt64(ctxkey.EphemeralTokenQuota),
+		RetryChain:              c.GetString(ctxkey.RetryChain),
 	}
 	cfg, ok := c.Get(ctxkey.Config)
 	if ok {
 		meta.Config = cfg.(model.ChannelConfig)
 	}
+	if prices, ok := c.Get(ctxkey.UpstreamPrices); ok {
+		meta.UpstreamPrices, _ = prices.(map[string]billingratio.ModelPrice)
+	}
+	meta.UpstreamDiscount = c.GetFloat64(ctxkey.UpstreamDiscount)
 	if meta.BaseURL == "" {
 		meta.BaseURL = channeltype.ChannelBaseURLs[meta.ChannelType]
 	}
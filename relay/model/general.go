@@ -27,6 +27,8 @@ type GeneralOpenAIRequest struct {
 	Model               string          `json:"model,omitempty"`
 	Store               *bool           `json:"store,omitempty"`
 	ReasoningEffort     *string         `json:"reasoning_effort,omitempty"`
+	Thinking            *Thinking       `json:"thinking,omitempty"`
+	Reasoning           *Reasoning      `json:"reasoning,omitempty"`
 	Metadata            any             `json:"metadata,omitempty"`
 	FrequencyPenalty    *float64        `json:"frequency_penalty,omitempty"`
 	LogitBias           any             `json:"logit_bias,omitempty"`
@@ -4,6 +4,7 @@ type Message struct {
 	Role             string  `json:"role,omitempty"`
 	Content          any     `json:"content,omitempty"`
 	ReasoningContent any     `json:"reasoning_content,omitempty"`
+	Reasoning        *string `json:"reasoning,omitempty"` // used by OpenRouter, normalized into ReasoningContent
 	Name             *string `json:"name,omitempty"`
 	ToolCalls        []Tool  `json:"tool_calls,omitempty"`
 	ToolCallId       string  `json:"tool_call_id,omitempty"`
@@ -59,8 +60,9 @@ func (m Message) ParseContent() []MessageContent {
 			case ContentTypeText:
 				if subStr, ok := contentMap["text"].(string); ok {
 					contentList = append(contentList, MessageContent{
-						Type: ContentTypeText,
-						Text: subStr,
+						Type:         ContentTypeText,
+						Text:         subStr,
+						CacheControl: contentMap["cache_control"],
 					})
 				}
 			case ContentTypeImageURL:
@@ -70,6 +72,7 @@ func (m Message) ParseContent() []MessageContent {
 						ImageURL: &ImageURL{
 							Url: subObj["url"].(string),
 						},
+						CacheControl: contentMap["cache_control"],
 					})
 				}
 			}
@@ -85,7 +88,8 @@ type ImageURL struct {
 }
 
 type MessageContent struct {
-	Type     string    `json:"type,omitempty"`
-	Text     string    `json:"text"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type         string    `json:"type,omitempty"`
+	Text         string    `json:"text"`
+	ImageURL     *ImageURL `json:"image_url,omitempty"`
+	CacheControl any       `json:"cache_control,omitempty"` // Anthropic prompt caching marker
 }
@@ -5,13 +5,98 @@ type Usage struct {
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
 
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
 	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+
+	// DeepSeek reports the cache hits here instead of prompt_tokens_details
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens,omitempty"`
+
+	// ServiceTier is the tier the upstream processed the request with, which is billed rather than the requested one
+	ServiceTier string `json:"-"`
+}
+
+// PromptTokensDetails breaks down the prompt tokens, which include the cached, audio and image ones.
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // only Anthropic charges cache writes
+	AudioTokens         int `json:"audio_tokens,omitempty"`
+	ImageTokens         int `json:"image_tokens,omitempty"`
+
+	// CachedTokensDetails breaks down the cached tokens, the cached audio and image tokens are also in AudioTokens and ImageTokens
+	CachedTokensDetails *CachedTokensDetails `json:"cached_tokens_details,omitempty"`
+}
+
+type CachedTokensDetails struct {
+	AudioTokens int `json:"audio_tokens,omitempty"`
+	ImageTokens int `json:"image_tokens,omitempty"`
+}
+
+// GetCachedTokens returns the prompt tokens read from the prompt cache.
+func (u *Usage) GetCachedTokens() int {
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		return u.PromptTokensDetails.CachedTokens
+	}
+	return u.PromptCacheHitTokens
+}
+
+// GetCacheCreationTokens returns the prompt tokens written to the prompt cache.
+func (u *Usage) GetCacheCreationTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CacheCreationTokens
 }
 
 type CompletionTokensDetails struct {
 	ReasoningTokens          int `json:"reasoning_tokens"`
 	AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
 	RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
+	AudioTokens              int `json:"audio_tokens,omitempty"`
+	ImageTokens              int `json:"image_tokens,omitempty"`
+}
+
+// GetPromptAudioTokens and the other modality getters return the tokens included in the prompt or completion tokens.
+func (u *Usage) GetPromptAudioTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.AudioTokens
+}
+
+func (u *Usage) GetPromptImageTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.ImageTokens
+}
+
+// GetCachedPromptAudioTokens and GetCachedPromptImageTokens return the part of the cached tokens in the modality.
+func (u *Usage) GetCachedPromptAudioTokens() int {
+	if u.PromptTokensDetails == nil || u.PromptTokensDetails.CachedTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokensDetails.AudioTokens
+}
+
+func (u *Usage) GetCachedPromptImageTokens() int {
+	if u.PromptTokensDetails == nil || u.PromptTokensDetails.CachedTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokensDetails.ImageTokens
+}
+
+func (u *Usage) GetCompletionAudioTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.AudioTokens
+}
+
+func (u *Usage) GetCompletionImageTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ImageTokens
 }
 
 type Error struct {
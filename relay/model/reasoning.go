@@ -0,0 +1,96 @@
+package model
+
+const (
+	ReasoningEffortNone    = "none"
+	ReasoningEffortMinimal = "minimal"
+	ReasoningEffortLow     = "low"
+	ReasoningEffortMedium  = "medium"
+	ReasoningEffortHigh    = "high"
+)
+
+// ReasoningEffortBudgets maps reasoning_effort to a thinking budget for the providers which take a token budget
+var ReasoningEffortBudgets = map[string]int{
+	ReasoningEffortMinimal: 1024,
+	ReasoningEffortLow:     2048,
+	ReasoningEffortMedium:  8192,
+	ReasoningEffortHigh:    24576,
+}
+
+// Thinking is the explicit thinking budget in the Anthropic format, it takes precedence over reasoning_effort.
+// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking
+type Thinking struct {
+	Type         string `json:"type"` // enabled or disabled
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// Reasoning is the reasoning config of OpenRouter.
+// https://openrouter.ai/docs/use-cases/reasoning-tokens
+type Reasoning struct {
+	Effort    string `json:"effort,omitempty"`
+	MaxTokens int    `json:"max_tokens,omitempty"`
+	Exclude   bool   `json:"exclude,omitempty"`
+}
+
+// GetThinkingBudget returns the thinking budget in tokens and whether the request controls thinking at all,
+// a zero budget means thinking is disabled.
+func (r GeneralOpenAIRequest) GetThinkingBudget() (int, bool) {
+	if r.Thinking != nil {
+		if r.Thinking.Type == "disabled" {
+			return 0, true
+		}
+		if r.Thinking.BudgetTokens > 0 {
+			return r.Thinking.BudgetTokens, true
+		}
+		return ReasoningEffortBudgets[ReasoningEffortMedium], true
+	}
+	if r.Reasoning != nil && r.Reasoning.MaxTokens > 0 {
+		return r.Reasoning.MaxTokens, true
+	}
+	effort := r.GetReasoningEffort()
+	if effort == "" {
+		return 0, false
+	}
+	if effort == ReasoningEffortNone {
+		return 0, true
+	}
+	budget, ok := ReasoningEffortBudgets[effort]
+	return budget, ok
+}
+
+// GetReasoningEffort returns the reasoning effort, derived from the thinking budget when only a budget is given.
+func (r GeneralOpenAIRequest) GetReasoningEffort() string {
+	if r.ReasoningEffort != nil {
+		return *r.ReasoningEffort
+	}
+	if r.Reasoning != nil && r.Reasoning.Effort != "" {
+		return r.Reasoning.Effort
+	}
+	budget := 0
+	if r.Thinking != nil {
+		if r.Thinking.Type == "disabled" {
+			return ReasoningEffortNone
+		}
+		budget = r.Thinking.BudgetTokens
+	} else if r.Reasoning != nil {
+		budget = r.Reasoning.MaxTokens
+	} else {
+		return ""
+	}
+	switch {
+	case budget <= 0:
+		return ReasoningEffortMedium
+	case budget <= ReasoningEffortBudgets[ReasoningEffortLow]:
+		return ReasoningEffortLow
+	case budget <= ReasoningEffortBudgets[ReasoningEffortMedium]:
+		return ReasoningEffortMedium
+	}
+	return ReasoningEffortHigh
+}
+
+// GetReasoningTokens returns the reasoning tokens, which are included in the completion tokens.
+func (u *Usage) GetReasoningTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
@@ -4,6 +4,8 @@ type Tool struct {
 	Id       string   `json:"id,omitempty"`
 	Type     string   `json:"type,omitempty"` // when splicing claude tools stream messages, it is empty
 	Function Function `json:"function"`
+
+	CacheControl any `json:"cache_control,omitempty"` // Anthropic prompt caching marker
 }
 
 type Function struct {
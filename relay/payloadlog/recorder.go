@@ -0,0 +1,207 @@
+package payloadlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/guardrail"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+type Attempt struct {
+	ChannelId  int    `json:"channel_id"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+}
+
+// Recorder wraps the response writer of a sampled request to capture what the client receives.
+type Recorder struct {
+	gin.ResponseWriter
+	attempts []Attempt
+	request  limitedBuffer
+	response limitedBuffer
+	isStream bool
+	pending  []byte // incomplete line of a stream
+}
+
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) append(p []byte) {
+	if b.truncated {
+		return
+	}
+	remain := config.PayloadLogMaxBodySize - b.Len()
+	if len(p) > remain {
+		// cut on a rune boundary, so that the body stays valid UTF-8
+		for remain > 0 && !utf8.RuneStart(p[remain]) {
+			remain--
+		}
+		if remain > 0 {
+			b.Write(p[:remain])
+		}
+		b.truncated = true
+		return
+	}
+	b.Write(p)
+}
+
+// Start decides whether the request is sampled, and installs a recorder if it is.
+func Start(c *gin.Context) {
+	rate := model.GetPayloadLogSampleRate(c.GetString(ctxkey.Group), c.GetInt(ctxkey.TokenId))
+	if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+		return
+	}
+	recorder := &Recorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Set(ctxkey.PayloadRecorder, recorder)
+}
+
+func GetByContext(c *gin.Context) *Recorder {
+	recorder, ok := c.Get(ctxkey.PayloadRecorder)
+	if !ok {
+		return nil
+	}
+	return recorder.(*Recorder)
+}
+
+// CaptureRequest records the body sent upstream and returns a reader replaying it,
+// only the last attempt is kept.
+func CaptureRequest(c *gin.Context, requestBody io.Reader) io.Reader {
+	recorder := GetByContext(c)
+	if recorder == nil || requestBody == nil {
+		return requestBody
+	}
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		logger.SysError("error reading upstream request body: " + err.Error())
+	}
+	recorder.request.Reset()
+	recorder.request.truncated = false
+	recorder.request.append(body)
+	return bytes.NewReader(body)
+}
+
+// RecordAttempt adds a failed attempt to the retry chain.
+func RecordAttempt(c *gin.Context, bizErr *relaymodel.ErrorWithStatusCode) {
+	recorder := GetByContext(c)
+	if recorder == nil || bizErr == nil {
+		return
+	}
+	recorder.attempts = append(recorder.attempts, Attempt{
+		ChannelId:  c.GetInt(ctxkey.ChannelId),
+		StatusCode: bizErr.StatusCode,
+		Message:    bizErr.Message,
+	})
+}
+
+func (r *Recorder) Write(data []byte) (int, error) {
+	r.capture(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *Recorder) WriteString(s string) (int, error) {
+	r.capture([]byte(s))
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *Recorder) capture(data []byte) {
+	if !r.isStream && r.response.Len() == 0 {
+		r.isStream = strings.HasPrefix(r.Header().Get("Content-Type"), "text/event-stream")
+	}
+	if !r.isStream {
+		r.response.append(data)
+		return
+	}
+	r.pending = append(r.pending, data...)
+	for {
+		i := bytes.IndexByte(r.pending, '\n')
+		if i < 0 {
+			return
+		}
+		r.captureStreamLine(bytes.TrimSpace(r.pending[:i]))
+		r.pending = r.pending[i+1:]
+	}
+}
+
+type streamChunk struct {
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content any `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// captureStreamLine reassembles the content of the chunks, lines that can't be parsed are kept as is
+func (r *Recorder) captureStreamLine(line []byte) {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return
+	}
+	var chunk streamChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		r.response.append(line)
+		r.response.append([]byte("\n"))
+		return
+	}
+	for _, choice := range chunk.Choices {
+		r.response.append([]byte(choice.Text))
+		if content, ok := choice.Delta.Content.(string); ok {
+			r.response.append([]byte(content))
+		}
+	}
+}
+
+// Finish saves the payload log of a sampled request.
+func Finish(c *gin.Context) {
+	recorder := GetByContext(c)
+	if recorder == nil {
+		return
+	}
+	log := &model.PayloadLog{
+		RequestId:         c.GetString(helper.RequestIdKey),
+		UserId:            c.GetInt(ctxkey.Id),
+		TokenId:           c.GetInt(ctxkey.TokenId),
+		ChannelId:         c.GetInt(ctxkey.ChannelId),
+		ModelName:         c.GetString(ctxkey.RequestModel),
+		RequestBody:       recorder.request.String(),
+		RequestTruncated:  recorder.request.truncated,
+		ResponseBody:      recorder.response.String(),
+		ResponseTruncated: recorder.response.truncated,
+		StatusCode:        recorder.Status(),
+		IsStream:          recorder.isStream,
+	}
+	if len(recorder.attempts) != 0 {
+		retryChain, err := json.Marshal(recorder.attempts)
+		if err != nil {
+			logger.SysError("error marshalling retry chain: " + err.Error())
+		}
+		log.RetryChain = string(retryChain)
+	}
+	go func() {
+		if config.PayloadLogRedactionEnabled {
+			log.RequestBody = guardrail.RedactPII(log.RequestBody)
+			log.ResponseBody = guardrail.RedactPII(log.ResponseBody)
+		}
+		if err := model.RecordPayloadLog(log); err != nil {
+			logger.SysError("failed to record payload log: " + err.Error())
+		}
+	}()
+}
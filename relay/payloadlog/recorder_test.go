@@ -0,0 +1,61 @@
+package payloadlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/render"
+)
+
+func TestLimitedBuffer(t *testing.T) {
+	Convey("TestLimitedBuffer", t, func() {
+		previousMaxBodySize := config.PayloadLogMaxBodySize
+		config.PayloadLogMaxBodySize = 8
+		defer func() { config.PayloadLogMaxBodySize = previousMaxBodySize }()
+
+		Convey("truncates on a rune boundary", func() {
+			b := &limitedBuffer{}
+			b.append([]byte("ab"))
+			b.append([]byte("你好世界"))
+			So(b.truncated, ShouldBeTrue)
+			So(b.String(), ShouldEqual, "ab你好")
+			So(utf8.ValidString(b.String()), ShouldBeTrue)
+			// nothing is appended after the cut
+			b.append([]byte("c"))
+			So(b.String(), ShouldEqual, "ab你好")
+		})
+
+		Convey("keeps bodies within the limit", func() {
+			b := &limitedBuffer{}
+			b.append([]byte("abcd"))
+			b.append([]byte("efgh"))
+			So(b.truncated, ShouldBeFalse)
+			So(b.String(), ShouldEqual, "abcdefgh")
+		})
+	})
+}
+
+func TestRecorderStream(t *testing.T) {
+	Convey("TestRecorderStream", t, func() {
+		gin.SetMode(gin.TestMode)
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		recorder := &Recorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		common.SetEventStreamHeaders(c)
+		So(render.ObjectData(c, map[string]any{"choices": []any{map[string]any{"delta": map[string]any{"content": "你"}}}}), ShouldBeNil)
+		So(render.ObjectData(c, map[string]any{"choices": []any{map[string]any{"delta": map[string]any{"content": "好"}}}}), ShouldBeNil)
+		render.Done(c)
+
+		So(recorder.isStream, ShouldBeTrue)
+		So(recorder.response.String(), ShouldEqual, "你好")
+	})
+}
@@ -73,6 +73,18 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
 		}
+		pricingRoute := apiRouter.Group("/pricing")
+		pricingRoute.Use(middleware.RootAuth())
+		{
+			pricingRoute.GET("/prices", controller.GetModelPrices)
+			pricingRoute.POST("/prices", controller.ImportModelPrices)
+			pricingRoute.GET("/validation", controller.ValidateModelPrices)
+			pricingRoute.GET("/versions", controller.GetPriceVersions)
+			pricingRoute.GET("/versions/:id", controller.GetPriceVersion)
+			pricingRoute.POST("/versions", controller.CreatePriceVersion)
+			pricingRoute.POST("/versions/preview", controller.PreviewPriceVersion)
+			pricingRoute.DELETE("/versions/:id", controller.DeletePriceVersion)
+		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
 		{
@@ -89,6 +101,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 		}
+		apiRouter.POST("/token/ephemeral", middleware.TokenOrUserAuth(), controller.AddEphemeralToken)
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
 		{
@@ -96,6 +109,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
 			tokenRoute.POST("/", controller.AddToken)
+			tokenRoute.POST("/:id/rotate", controller.RotateToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
@@ -112,11 +126,39 @@ func SetApiRouter(router *gin.Engine) {
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
+		logRoute.GET("/archive", middleware.AdminAuth(), controller.GetLogArchives)
+		logRoute.POST("/archive", middleware.AdminAuth(), controller.ArchiveLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		logRoute.GET("/payload/:request_id", middleware.AdminAuth(), controller.GetPayloadLog)
+		logRoute.GET("/self/payload/:request_id", middleware.UserAuth(), controller.GetUserPayloadLog)
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.GET("/", middleware.AdminAuth(), controller.GetLedgerEntries)
+		ledgerRoute.GET("/self", middleware.UserAuth(), controller.GetSelfLedgerEntries)
+		ledgerRoute.GET("/reconciliation", middleware.RootAuth(), controller.GetLedgerReconciliation)
+		ledgerRoute.POST("/reconciliation", middleware.RootAuth(), controller.ReconcileLedger)
+		analyticsRoute := apiRouter.Group("/analytics")
+		analyticsRoute.GET("/usage", middleware.AdminAuth(), controller.GetUsageAnalytics)
+		analyticsRoute.GET("/self/usage", middleware.UserAuth(), controller.GetUserUsageAnalytics)
+		analyticsRoute.GET("/margin", middleware.AdminAuth(), controller.GetChannelMargins)
+		analyticsRoute.POST("/backfill", middleware.RootAuth(), controller.BackfillUsageRollups)
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.GET("/plans", middleware.UserAuth(), controller.GetSubscriptionPlans)
+		subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+		subscriptionRoute.PUT("/self/auto_renew", middleware.UserAuth(), controller.UpdateSelfSubscriptionAutoRenew)
+		subscriptionRoute.GET("/", middleware.AdminAuth(), controller.GetAllSubscriptions)
+		subscriptionPlanRoute := subscriptionRoute.Group("/plan")
+		subscriptionPlanRoute.Use(middleware.AdminAuth())
+		{
+			subscriptionPlanRoute.GET("/", controller.GetAllSubscriptionPlans)
+			subscriptionPlanRoute.GET("/:id", controller.GetSubscriptionPlan)
+			subscriptionPlanRoute.POST("/", controller.AddSubscriptionPlan)
+			subscriptionPlanRoute.PUT("/", controller.UpdateSubscriptionPlan)
+			subscriptionPlanRoute.DELETE("/:id", controller.DeleteSubscriptionPlan)
+		}
 		groupRoute := apiRouter.Group("/group")
 		groupRoute.Use(middleware.AdminAuth())
 		{
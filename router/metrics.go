@@ -0,0 +1,20 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	if !config.EnablePrometheus {
+		return
+	}
+	if config.PrometheusToken == "" {
+		logger.SysError("ENABLE_PROMETHEUS is set without PROMETHEUS_TOKEN, /metrics is not mounted")
+		return
+	}
+	router.GET("/metrics", middleware.MetricsAuth(), gin.WrapH(promhttp.Handler()))
+}
@@ -0,0 +1,144 @@
+import PropTypes from 'prop-types';
+import { useState } from 'react';
+import { useSelector } from 'react-redux';
+
+import {
+  Popover,
+  MenuItem,
+  Dialog,
+  DialogActions,
+  DialogContent,
+  DialogContentText,
+  DialogTitle,
+  Button,
+  Stack,
+  ButtonGroup,
+  Alert,
+  Typography
+} from '@mui/material';
+
+import { copy } from 'utils/common';
+
+import { IconCaretDownFilled } from '@tabler/icons-react';
+
+const COPY_OPTIONS = [
+  {
+    key: 'next',
+    text: 'ChatGPT Next',
+    url: 'https://app.nextchat.dev/#/?settings={"key":"sk-{key}","url":"{serverAddress}"}',
+    encode: false
+  },
+  { key: 'ama', text: 'BotGem', url: 'ama://set-api-key?server={serverAddress}&key=sk-{key}', encode: true },
+  { key: 'opencat', text: 'OpenCat', url: 'opencat://team/join?domain={serverAddress}&token=sk-{key}', encode: true },
+  { key: 'lobechat', text: 'LobeChat', url: 'https://lobehub.com/?settings={"keyVaults":{"openai":{"apiKey":"sk-{key}","baseURL":"{serverAddress}"}}}', encode: true }
+];
+
+function replacePlaceholders(text, key, serverAddress) {
+  return text.replace('{key}', key).replace('{serverAddress}', serverAddress);
+}
+
+// 令牌明文仅在创建时返回一次，在这里展示
+export default function KeyDialog({ tokenKey, onClose }) {
+  const [open, setOpen] = useState(null);
+  const [menuType, setMenuType] = useState('copy');
+  const siteInfo = useSelector((state) => state.siteInfo);
+
+  const handleOpenMenu = (event, type) => {
+    setMenuType(type);
+    setOpen(event.currentTarget);
+  };
+
+  const handleCloseMenu = () => {
+    setOpen(null);
+  };
+
+  const handleCopy = (option, type) => {
+    let serverAddress = '';
+    if (siteInfo?.server_address) {
+      serverAddress = siteInfo.server_address;
+    } else {
+      serverAddress = window.location.host;
+    }
+
+    if (option.encode) {
+      serverAddress = encodeURIComponent(serverAddress);
+    }
+
+    let url = option.url;
+
+    if (option.key === 'next' && siteInfo?.chat_link) {
+      url = siteInfo.chat_link + `/#/?settings={"key":"sk-{key}","url":"{serverAddress}"}`;
+    }
+
+    const text = replacePlaceholders(url, tokenKey, serverAddress);
+    if (type === 'link') {
+      window.open(text);
+    } else {
+      copy(text);
+    }
+    handleCloseMenu();
+  };
+
+  return (
+    <>
+      <Dialog open={!!tokenKey} onClose={onClose} fullWidth maxWidth={'sm'}>
+        <DialogTitle>新令牌</DialogTitle>
+        <DialogContent>
+          <Alert severity="warning" sx={{ mb: 2 }}>
+            令牌仅显示这一次，请立即复制并妥善保存，关闭后将无法再次查看！
+          </Alert>
+          <DialogContentText>
+            <Typography component="code" sx={{ wordBreak: 'break-all' }}>{`sk-${tokenKey}`}</Typography>
+          </DialogContentText>
+        </DialogContent>
+        <DialogActions>
+          <Stack direction="row" spacing={1}>
+            <ButtonGroup size="small" aria-label="split button">
+              <Button
+                color="primary"
+                onClick={() => {
+                  copy(`sk-${tokenKey}`);
+                }}
+              >
+                复制
+              </Button>
+              <Button size="small" onClick={(e) => handleOpenMenu(e, 'copy')}>
+                <IconCaretDownFilled size={'16px'} />
+              </Button>
+            </ButtonGroup>
+            <ButtonGroup size="small" aria-label="split button">
+              <Button color="primary" onClick={() => handleCopy(COPY_OPTIONS[0], 'link')}>
+                聊天
+              </Button>
+              <Button size="small" onClick={(e) => handleOpenMenu(e, 'link')}>
+                <IconCaretDownFilled size={'16px'} />
+              </Button>
+            </ButtonGroup>
+            <Button onClick={onClose}>关闭</Button>
+          </Stack>
+        </DialogActions>
+      </Dialog>
+      <Popover
+        open={!!open}
+        anchorEl={open}
+        onClose={handleCloseMenu}
+        anchorOrigin={{ vertical: 'top', horizontal: 'left' }}
+        transformOrigin={{ vertical: 'top', horizontal: 'right' }}
+        PaperProps={{
+          sx: { width: 140 }
+        }}
+      >
+        {COPY_OPTIONS.map((option, index) => (
+          <MenuItem key={index} onClick={() => handleCopy(option, menuType)}>
+            {option.text}
+          </MenuItem>
+        ))}
+      </Popover>
+    </>
+  );
+}
+
+KeyDialog.propTypes = {
+  tokenKey: PropTypes.string,
+  onClose: PropTypes.func
+};
@@ -19,6 +19,7 @@ import { API } from 'utils/api';
 import { ITEMS_PER_PAGE } from 'constants';
 import { IconRefresh, IconPlus } from '@tabler/icons-react';
 import EditeModal from './component/EditModal';
+import KeyDialog from './component/KeyDialog';
 import { useSelector } from 'react-redux';
 
 export default function Token() {
@@ -28,6 +29,7 @@ export default function Token() {
   const [searchKeyword, setSearchKeyword] = useState('');
   const [openModal, setOpenModal] = useState(false);
   const [editTokenId, setEditTokenId] = useState(0);
+  const [createdKey, setCreatedKey] = useState('');
   const siteInfo = useSelector((state) => state.siteInfo);
 
   const loadTokens = async (startIdx) => {
@@ -132,9 +134,12 @@ export default function Token() {
     setEditTokenId(0);
   };
 
-  const handleOkModal = (status) => {
+  const handleOkModal = (status, key) => {
     if (status === true) {
       handleCloseModal();
+      if (key) {
+        setCreatedKey(key);
+      }
       handleRefresh();
     }
   };
@@ -156,7 +161,7 @@ export default function Token() {
       </Stack>
       <Stack mb={2}>
         <Alert severity="info">
-          将 OpenAI API 基础地址 https://api.openai.com 替换为 <b>{siteInfo.server_address}</b>，复制下面的密钥即可使用
+          将 OpenAI API 基础地址 https://api.openai.com 替换为 <b>{siteInfo.server_address}</b>，使用创建令牌时复制的密钥即可
         </Alert>
       </Stack>
       <Card>
@@ -209,6 +214,7 @@ export default function Token() {
         />
       </Card>
       <EditeModal open={openModal} onCancel={handleCloseModal} onOk={handleOkModal} tokenId={editTokenId} />
+      <KeyDialog tokenKey={createdKey} onClose={() => setCreatedKey('')} />
     </>
   );
 }
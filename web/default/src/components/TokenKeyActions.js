@@ -0,0 +1,114 @@
+import React from 'react';
+import { useTranslation } from 'react-i18next';
+import { Button, Dropdown } from 'semantic-ui-react';
+import { copy, showSuccess, showWarning } from '../helpers';
+
+function getServerAddress() {
+  let status = localStorage.getItem('status');
+  let serverAddress = '';
+  if (status) {
+    status = JSON.parse(status);
+    serverAddress = status.server_address;
+  }
+  if (serverAddress === '') {
+    serverAddress = window.location.origin;
+  }
+  return serverAddress;
+}
+
+function getNextChatUrl(chatLink, key, serverAddress) {
+  if (chatLink) {
+    return (
+      chatLink + `/#/?settings={"key":"sk-${key}","url":"${serverAddress}"}`
+    );
+  }
+  return `https://app.nextchat.dev/#/?settings={"key":"sk-${key}","url":"${serverAddress}"}`;
+}
+
+export function getTokenKeyUrl(type, key) {
+  const serverAddress = getServerAddress();
+  const encodedServerAddress = encodeURIComponent(serverAddress);
+  const chatLink = localStorage.getItem('chat_link');
+  switch (type) {
+    case 'ama':
+      return `ama://set-api-key?server=${encodedServerAddress}&key=sk-${key}`;
+    case 'opencat':
+      return `opencat://team/join?domain=${encodedServerAddress}&token=sk-${key}`;
+    case 'next':
+      return getNextChatUrl(chatLink, key, serverAddress);
+    case 'lobechat':
+      return (
+        chatLink +
+        `/?settings={"keyVaults":{"openai":{"apiKey":"sk-${key}","baseURL":"${serverAddress}/v1"}}}`
+      );
+    default:
+      return `sk-${key}`;
+  }
+}
+
+// The full key is only returned when the token is created, the actions are shown there.
+const TokenKeyActions = ({ tokenKey }) => {
+  const { t } = useTranslation();
+
+  const COPY_OPTIONS = [
+    { key: 'raw', text: t('token.copy_options.raw'), value: '' },
+    { key: 'next', text: t('token.copy_options.next'), value: 'next' },
+    { key: 'ama', text: t('token.copy_options.ama'), value: 'ama' },
+    { key: 'opencat', text: t('token.copy_options.opencat'), value: 'opencat' },
+    { key: 'lobe', text: t('token.copy_options.lobe'), value: 'lobechat' },
+  ];
+
+  const OPEN_LINK_OPTIONS = [
+    { key: 'next', text: t('token.copy_options.next'), value: 'next' },
+    { key: 'ama', text: t('token.copy_options.ama'), value: 'ama' },
+    { key: 'opencat', text: t('token.copy_options.opencat'), value: 'opencat' },
+    { key: 'lobe', text: t('token.copy_options.lobe'), value: 'lobechat' },
+  ];
+
+  const onCopy = async (type) => {
+    if (await copy(getTokenKeyUrl(type, tokenKey))) {
+      showSuccess(t('token.messages.copy_success'));
+    } else {
+      showWarning(t('token.messages.copy_failed'));
+    }
+  };
+
+  const onOpenLink = (type) => {
+    window.open(getTokenKeyUrl(type || 'next', tokenKey), '_blank');
+  };
+
+  return (
+    <>
+      <Button.Group color='green' size={'tiny'}>
+        <Button size={'tiny'} positive onClick={async () => await onCopy('')}>
+          {t('token.buttons.copy')}
+        </Button>
+        <Dropdown
+          className='button icon'
+          floating
+          options={COPY_OPTIONS.map((option) => ({
+            ...option,
+            onClick: async () => await onCopy(option.value),
+          }))}
+          trigger={<></>}
+        />
+      </Button.Group>{' '}
+      <Button.Group color='olive' size={'tiny'}>
+        <Button size={'tiny'} positive onClick={() => onOpenLink('')}>
+          {t('token.buttons.chat')}
+        </Button>
+        <Dropdown
+          className='button icon'
+          floating
+          options={OPEN_LINK_OPTIONS.map((option) => ({
+            ...option,
+            onClick: () => onOpenLink(option.value),
+          }))}
+          trigger={<></>}
+        />
+      </Button.Group>
+    </>
+  );
+};
+
+export default TokenKeyActions;
@@ -17,6 +17,7 @@ import {
   timestamp2string,
 } from '../../helpers';
 import { renderQuotaWithPrompt } from '../../helpers/render';
+import TokenKeyActions from '../../components/TokenKeyActions';
 
 const EditToken = () => {
   const { t } = useTranslation();
@@ -34,6 +35,7 @@ const EditToken = () => {
     subnet: '',
   };
   const [inputs, setInputs] = useState(originInputs);
+  const [createdKey, setCreatedKey] = useState('');
   const { name, remain_quota, expired_time, unlimited_quota } = inputs;
   const navigate = useNavigate();
   const handleInputChange = (e, { name, value }) => {
@@ -139,12 +141,13 @@ const EditToken = () => {
     } else {
       res = await API.post(`/api/token/`, localInputs);
     }
-    const { success, message } = res.data;
+    const { success, message, data } = res.data;
     if (success) {
       if (isEdit) {
         showSuccess(t('token.edit.messages.update_success'));
       } else {
         showSuccess(t('token.edit.messages.create_success'));
+        setCreatedKey(data.key);
         setInputs(originInputs);
       }
     } else {
@@ -159,6 +162,16 @@ const EditToken = () => {
           <Card.Header className='header'>
             {isEdit ? t('token.edit.title_edit') : t('token.edit.title_create')}
           </Card.Header>
+          {createdKey && (
+            <Message warning>
+              <Message.Header>{t('token.edit.created_key')}</Message.Header>
+              <p>
+                <code>{`sk-${createdKey}`}</code>
+              </p>
+              <p>{t('token.edit.created_key_notice')}</p>
+              <TokenKeyActions tokenKey={createdKey} />
+            </Message>
+          )}
           <Form loading={loading} autoComplete='new-password'>
             <Form.Field>
               <Form.Input